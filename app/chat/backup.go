@@ -0,0 +1,346 @@
+package chat
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/expr-lang/expr/vm"
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/spf13/viper"
+	"go.uber.org/multierr"
+
+	"github.com/iyear/tdl/app/internal/tctx"
+	"github.com/iyear/tdl/core/downloader"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/pkg/bandwidth"
+	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
+	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/texpr"
+	"github.com/iyear/tdl/pkg/utils"
+)
+
+const (
+	backupJSON = "result.json"
+	backupHTML = "messages.html"
+)
+
+//go:embed backup.html.tmpl
+var backupTmpl string
+
+var backupTemplate = template.Must(template.New("backup").Funcs(template.FuncMap{
+	"text": renderText,
+}).Parse(backupTmpl))
+
+// backup exports the chat like Telegram Desktop into output directory, with media downloaded alongside.
+// The result.json can be used by 'tdl dl -f' directly.
+func backup(ctx context.Context, c *telegram.Client, kvd storage.Storage, manager *peers.Manager, peer peers.Peer, filter *vm.Program, opts ExportOptions) error {
+	self, err := manager.Self(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get self")
+	}
+
+	id, err := exportID(ctx, peer, opts)
+	if err != nil {
+		return err
+	}
+
+	since, exported := 0, []*backupMessage(nil)
+	if opts.Incremental {
+		if since, err = loadIncremental(ctx, kvd, id, opts); err != nil {
+			return err
+		}
+		if exported, err = readBackup(opts.Output, id); err != nil {
+			return err
+		}
+	}
+
+	if err = os.MkdirAll(opts.Output, 0o755); err != nil {
+		return errors.Wrap(err, "create output dir")
+	}
+
+	conv := &converter{self: self.Raw(), chat: peer, withMedia: opts.WithMedia}
+	result := &backupChat{
+		Name:     peer.VisibleName(),
+		Type:     conv.chatType(),
+		ID:       id,
+		Messages: make([]*backupMessage, 0),
+	}
+
+	color.Blue("Type: %s | Input: %v", opts.Type, opts.Input)
+
+	iter, err := newMessageIter(ctx, c, manager, peer, opts)
+	if err != nil {
+		return err
+	}
+
+	pw, tracker := newExportProgress(fmt.Sprintf("%s-%d", peer.VisibleName(), peer.ID()))
+	go pw.Render()
+	count, newest := int64(0), since
+	for iter.Next(ctx) {
+		elem := iter.Value()
+		if outOfRange(opts, elem.Msg, count) || elem.Msg.GetID() <= since {
+			break
+		}
+		newest = max(newest, elem.Msg.GetID())
+
+		switch m := elem.Msg.(type) {
+		case *tg.Message:
+			b, err := texpr.Run(filter, texpr.ConvertEnvMessage(m))
+			if err != nil {
+				return fmt.Errorf("failed to run filter: %w", err)
+			}
+			if !b.(bool) { // filtered
+				continue
+			}
+			result.Messages = append(result.Messages, conv.message(elem.Entities, m))
+		case *tg.MessageService:
+			result.Messages = append(result.Messages, conv.service(elem.Entities, m))
+		default:
+			continue
+		}
+
+		count++
+		tracker.SetValue(count)
+	}
+	if err = iter.Err(); err != nil {
+		return err
+	}
+
+	tracker.MarkAsDone()
+	prog.Wait(ctx, pw)
+
+	// messages are exported from the oldest to the newest like Telegram Desktop
+	slices.Reverse(result.Messages)
+
+	if opts.WithMedia {
+		if err = downloadMedia(ctx, c, opts.Output, result.Messages); err != nil {
+			return errors.Wrap(err, "download media")
+		}
+	}
+
+	// new messages are appended to the existing backup
+	result.Messages = append(exported, result.Messages...)
+
+	if err = writeBackup(opts.Output, result, opts.HTML); err != nil {
+		return err
+	}
+
+	if opts.Incremental {
+		if err = saveIncremental(ctx, kvd, id, opts, newest); err != nil {
+			return err
+		}
+	}
+
+	color.Green("Chat is exported to '%s'", opts.Output)
+	return nil
+}
+
+func writeBackup(dir string, result *backupChat, html bool) (rerr error) {
+	b, err := json.MarshalIndent(result, "", " ")
+	if err != nil {
+		return errors.Wrap(err, "marshal result")
+	}
+	// existing result is read back by incremental export, so it's replaced only after fully written
+	path := filepath.Join(dir, backupJSON)
+	if err = os.WriteFile(path+tempExt, b, 0o644); err != nil {
+		return errors.Wrap(err, "write result")
+	}
+	if err = os.Rename(path+tempExt, path); err != nil {
+		return errors.Wrap(err, "replace result")
+	}
+
+	if !html {
+		return nil
+	}
+
+	f, err := os.Create(filepath.Join(dir, backupHTML))
+	if err != nil {
+		return errors.Wrap(err, "create html")
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(f))
+
+	return backupTemplate.Execute(f, result)
+}
+
+// renderText renders text entities as HTML
+func renderText(entities []textEntity) template.HTML {
+	b := &strings.Builder{}
+	for _, e := range entities {
+		text := template.HTMLEscapeString(e.Text)
+		switch e.Type {
+		case "bold":
+			text = "<strong>" + text + "</strong>"
+		case "italic":
+			text = "<em>" + text + "</em>"
+		case "underline":
+			text = "<u>" + text + "</u>"
+		case "strikethrough":
+			text = "<s>" + text + "</s>"
+		case "code":
+			text = "<code>" + text + "</code>"
+		case "pre":
+			text = "<pre>" + text + "</pre>"
+		case "blockquote":
+			text = "<blockquote>" + text + "</blockquote>"
+		case "spoiler":
+			text = `<span class="spoiler">` + text + "</span>"
+		case "link":
+			text = `<a href="` + template.HTMLEscapeString(e.Text) + `">` + text + "</a>"
+		case "text_link":
+			text = `<a href="` + template.HTMLEscapeString(e.Href) + `">` + text + "</a>"
+		case "email":
+			text = `<a href="mailto:` + template.HTMLEscapeString(e.Text) + `">` + text + "</a>"
+		}
+		b.WriteString(strings.ReplaceAll(text, "\n", "<br>"))
+	}
+	return template.HTML(b.String())
+}
+
+// downloadMedia downloads media of messages into the directory. Existing files with the same size are skipped,
+// so media is not downloaded again when exporting into the same directory. Failed media is marked as not included.
+func downloadMedia(ctx context.Context, c *telegram.Client, dir string, messages []*backupMessage) (rerr error) {
+	pool := tctx.NewPool(ctx, c)
+	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+
+	elems := make([]*backupElem, 0)
+	for _, m := range messages {
+		if m.media == nil {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(m.File))
+		if m.Photo != "" {
+			path = filepath.Join(dir, filepath.FromSlash(m.Photo))
+		}
+		if stat, err := os.Stat(path); err == nil && stat.Size() == m.media.Size {
+			continue
+		}
+
+		elems = append(elems, &backupElem{message: m, path: path})
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+
+	p := prog.New(utils.Byte.FormatBinaryBytes)
+	p.SetNumTrackersExpected(len(elems))
+	prog.EnablePS(ctx, p)
+	go p.Render()
+	defer prog.Wait(ctx, p)
+
+	return downloader.New(downloader.Options{
+		Pool:     pool,
+		Threads:  viper.GetInt(consts.FlagThreads),
+		Iter:     &backupIter{elems: elems, index: -1},
+		Progress: metrics.Download(&backupProgress{pw: p}),
+		Limiter:  bandwidth.From(ctx),
+	}).Download(ctx, viper.GetInt(consts.FlagLimit))
+}
+
+type backupElem struct {
+	message *backupMessage
+	path    string
+	to      *os.File
+	tracker *progress.Tracker
+}
+
+func (e *backupElem) File() downloader.File { return e }
+
+func (e *backupElem) To() io.WriterAt { return e.to }
+
+func (e *backupElem) AsTakeout() bool { return false }
+
+func (e *backupElem) Location() tg.InputFileLocationClass { return e.media().InputFileLoc }
+
+func (e *backupElem) Size() int64 { return e.media().Size }
+
+func (e *backupElem) DC() int { return e.media().DC }
+
+func (e *backupElem) media() *tmedia.Media { return e.message.media }
+
+type backupIter struct {
+	elems []*backupElem
+	index int
+	err   error
+}
+
+func (i *backupIter) Next(ctx context.Context) bool {
+	if i.err != nil || ctx.Err() != nil || i.index+1 >= len(i.elems) {
+		return false
+	}
+	i.index++
+
+	e := i.elems[i.index]
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+		i.err = errors.Wrap(err, "create dir")
+		return false
+	}
+	if e.to, i.err = os.Create(e.path + tempExt); i.err != nil {
+		return false
+	}
+	return true
+}
+
+func (i *backupIter) Value() downloader.Elem { return i.elems[i.index] }
+
+func (i *backupIter) Err() error { return i.err }
+
+type backupProgress struct {
+	pw progress.Writer
+}
+
+func (p *backupProgress) OnAdd(elem downloader.Elem) {
+	e := elem.(*backupElem)
+	e.tracker = prog.AppendTracker(p.pw, utils.Byte.FormatBinaryBytes, filepath.Base(e.path), e.Size())
+}
+
+func (p *backupProgress) OnDownload(elem downloader.Elem, state downloader.ProgressState) {
+	t := elem.(*backupElem).tracker
+	t.UpdateTotal(state.Total)
+	t.SetValue(state.Downloaded)
+}
+
+func (p *backupProgress) OnDone(elem downloader.Elem, err error) {
+	e := elem.(*backupElem)
+
+	if err == nil {
+		err = e.to.Close()
+	} else {
+		_ = e.to.Close()
+	}
+	if err == nil {
+		err = os.Rename(e.to.Name(), e.path)
+	}
+
+	if err != nil {
+		_ = os.Remove(e.to.Name())
+
+		// result.json shouldn't refer to missing files
+		if e.message.Photo != "" {
+			e.message.Photo = fileNotIncluded
+		} else {
+			e.message.File = fileNotIncluded
+		}
+
+		p.pw.Log(color.RedString("%s error: %s", filepath.Base(e.path), err.Error()))
+		e.tracker.MarkAsErrored()
+		return
+	}
+
+	e.tracker.MarkAsDone()
+}
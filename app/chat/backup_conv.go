@@ -0,0 +1,516 @@
+package chat
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/flytam/filenamify"
+	"github.com/gotd/td/telegram/message/peer"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+
+	"github.com/iyear/tdl/core/tmedia"
+)
+
+// fileNotIncluded is the placeholder of media which is not downloaded, same as Telegram Desktop
+const fileNotIncluded = "(File not included. Change data exporting settings to download.)"
+
+// backupChat is the result.json of Telegram Desktop single chat export
+type backupChat struct {
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	ID       int64            `json:"id"`
+	Messages []*backupMessage `json:"messages"`
+}
+
+type backupMessage struct {
+	ID         int    `json:"id"`
+	Type       string `json:"type"` // message, service
+	Date       string `json:"date"`
+	DateUnix   string `json:"date_unixtime"`
+	Edited     string `json:"edited,omitempty"`
+	EditedUnix string `json:"edited_unixtime,omitempty"`
+
+	// sender of message
+	From   string `json:"from,omitempty"`
+	FromID string `json:"from_id,omitempty"`
+
+	// service message
+	Actor     string   `json:"actor,omitempty"`
+	ActorID   string   `json:"actor_id,omitempty"`
+	Action    string   `json:"action,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	Members   []string `json:"members,omitempty"`
+	MessageID int      `json:"message_id,omitempty"` // pinned message
+	Duration  int      `json:"duration_seconds,omitempty"`
+
+	ForwardedFrom string `json:"forwarded_from,omitempty"`
+	ReplyTo       int    `json:"reply_to_message_id,omitempty"`
+
+	Photo     string          `json:"photo,omitempty"`
+	File      string          `json:"file,omitempty"`
+	FileName  string          `json:"file_name,omitempty"`
+	FileSize  int64           `json:"file_size,omitempty"`
+	MediaType string          `json:"media_type,omitempty"`
+	MimeType  string          `json:"mime_type,omitempty"`
+	Width     int             `json:"width,omitempty"`
+	Height    int             `json:"height,omitempty"`
+	Location  *backupLocation `json:"location_information,omitempty"`
+	Contact   *backupContact  `json:"contact_information,omitempty"`
+	Poll      *backupPoll     `json:"poll,omitempty"`
+
+	// Text is a string if there is no formatting, otherwise mixed array of strings and entities
+	Text         any          `json:"text"`
+	TextEntities []textEntity `json:"text_entities"`
+	Reactions    []reaction   `json:"reactions,omitempty"`
+
+	// media to download, nil if the message has no downloadable media
+	media *tmedia.Media
+}
+
+type backupLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type backupContact struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+type backupPoll struct {
+	Question    string       `json:"question"`
+	Closed      bool         `json:"closed"`
+	TotalVoters int          `json:"total_voters"`
+	Answers     []pollAnswer `json:"answers"`
+}
+
+type pollAnswer struct {
+	Text   string `json:"text"`
+	Voters int    `json:"voters"`
+	Chosen bool   `json:"chosen"`
+}
+
+type textEntity struct {
+	Type       string `json:"type"`
+	Text       string `json:"text"`
+	Href       string `json:"href,omitempty"`
+	UserID     int64  `json:"user_id,omitempty"`
+	Language   string `json:"language,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+type reaction struct {
+	Type       string `json:"type"` // emoji, custom_emoji, paid
+	Count      int    `json:"count"`
+	Emoji      string `json:"emoji,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+// converter converts messages into Telegram Desktop format
+type converter struct {
+	self      *tg.User
+	chat      peers.Peer
+	withMedia bool
+}
+
+func (c *converter) chatType() string {
+	switch p := c.chat.(type) {
+	case peers.User:
+		if p.ID() == c.self.ID {
+			return "saved_messages"
+		}
+		if p.Raw().Bot {
+			return "bot_chat"
+		}
+		return "personal_chat"
+	case peers.Chat:
+		return "private_group"
+	case peers.Channel:
+		_, public := p.Username()
+		switch {
+		case p.IsBroadcast() && public:
+			return "public_channel"
+		case p.IsBroadcast():
+			return "private_channel"
+		case public:
+			return "public_supergroup"
+		default:
+			return "private_supergroup"
+		}
+	default:
+		return ""
+	}
+}
+
+func formatDate(unix int) (string, string) {
+	return time.Unix(int64(unix), 0).Format("2006-01-02T15:04:05"), strconv.Itoa(unix)
+}
+
+// sender returns name and id of the sender. Messages without sender are sent by the chat itself,
+// or by self or the other side in private chats.
+func (c *converter) sender(ent peer.Entities, from tg.PeerClass, out bool) (string, string) {
+	if from != nil {
+		return peerName(ent, from)
+	}
+
+	if out {
+		return userName(c.self), "user" + strconv.FormatInt(c.self.ID, 10)
+	}
+
+	switch p := c.chat.(type) {
+	case peers.User:
+		return userName(p.Raw()), "user" + strconv.FormatInt(p.ID(), 10)
+	case peers.Chat:
+		return p.VisibleName(), "chat" + strconv.FormatInt(p.ID(), 10)
+	default:
+		return c.chat.VisibleName(), "channel" + strconv.FormatInt(c.chat.ID(), 10)
+	}
+}
+
+// peerName returns name and id of the peer in Telegram Desktop format, name is empty if it's not in entities
+func peerName(ent peer.Entities, p tg.PeerClass) (string, string) {
+	switch p := p.(type) {
+	case *tg.PeerUser:
+		name := ""
+		if u, ok := ent.User(p.UserID); ok {
+			name = userName(u)
+		}
+		return name, "user" + strconv.FormatInt(p.UserID, 10)
+	case *tg.PeerChat:
+		name := ""
+		if c, ok := ent.Chat(p.ChatID); ok {
+			name = c.Title
+		}
+		return name, "chat" + strconv.FormatInt(p.ChatID, 10)
+	case *tg.PeerChannel:
+		name := ""
+		if c, ok := ent.Channels()[p.ChannelID]; ok {
+			name = c.Title
+		}
+		return name, "channel" + strconv.FormatInt(p.ChannelID, 10)
+	default:
+		return "", ""
+	}
+}
+
+func userName(u *tg.User) string {
+	if u.Deleted {
+		return "Deleted Account"
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func (c *converter) message(ent peer.Entities, m *tg.Message) *backupMessage {
+	bm := &backupMessage{ID: m.ID, Type: "message"}
+	bm.Date, bm.DateUnix = formatDate(m.Date)
+	if edit, ok := m.GetEditDate(); ok {
+		bm.Edited, bm.EditedUnix = formatDate(edit)
+	}
+	bm.From, bm.FromID = c.sender(ent, m.FromID, m.Out)
+
+	if fwd, ok := m.GetFwdFrom(); ok {
+		bm.ForwardedFrom = fwd.FromName
+		if from, ok := fwd.GetFromID(); ok && bm.ForwardedFrom == "" {
+			bm.ForwardedFrom, _ = peerName(ent, from)
+		}
+	}
+	if reply, ok := m.ReplyTo.(*tg.MessageReplyHeader); ok {
+		bm.ReplyTo = reply.ReplyToMsgID
+	}
+
+	c.media(bm, m)
+	bm.Text, bm.TextEntities = convText(m.Message, m.Entities)
+
+	if reactions, ok := m.GetReactions(); ok {
+		for _, r := range reactions.Results {
+			rc := reaction{Count: r.Count}
+			switch r := r.Reaction.(type) {
+			case *tg.ReactionEmoji:
+				rc.Type, rc.Emoji = "emoji", r.Emoticon
+			case *tg.ReactionCustomEmoji:
+				rc.Type, rc.DocumentID = "custom_emoji", strconv.FormatInt(r.DocumentID, 10)
+			case *tg.ReactionPaid:
+				rc.Type = "paid"
+			default:
+				continue
+			}
+			bm.Reactions = append(bm.Reactions, rc)
+		}
+	}
+
+	return bm
+}
+
+// media fills media fields of message. Path of downloadable media is '<folder>/<message id>_<file name>'.
+func (c *converter) media(bm *backupMessage, m *tg.Message) {
+	filePath := func(folder string) string {
+		md, ok := tmedia.ExtractMedia(m.Media)
+		if !ok {
+			return ""
+		}
+		if !c.withMedia {
+			return fileNotIncluded
+		}
+
+		name, err := filenamify.FilenamifyV2(md.Name)
+		if err != nil {
+			name = strconv.FormatInt(md.Size, 10)
+		}
+		bm.media = md
+		return path.Join(folder, fmt.Sprintf("%d_%s", m.ID, name))
+	}
+
+	switch media := m.Media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := media.Photo.(*tg.Photo)
+		if !ok {
+			return
+		}
+		for _, size := range photo.Sizes {
+			if s, ok := size.(interface{ GetW() int }); ok && s.GetW() > bm.Width {
+				bm.Width = s.GetW()
+				if h, ok := size.(interface{ GetH() int }); ok {
+					bm.Height = h.GetH()
+				}
+			}
+		}
+		bm.Photo = filePath("photos")
+	case *tg.MessageMediaDocument:
+		doc, ok := media.Document.(*tg.Document)
+		if !ok {
+			return
+		}
+
+		folder := "files"
+		for _, attr := range doc.Attributes {
+			switch attr := attr.(type) {
+			case *tg.DocumentAttributeFilename:
+				bm.FileName = attr.FileName
+			case *tg.DocumentAttributeVideo:
+				folder, bm.MediaType = "video_files", "video_file"
+				if attr.RoundMessage {
+					folder, bm.MediaType = "round_video_messages", "video_message"
+				}
+				bm.Duration, bm.Width, bm.Height = int(attr.Duration), attr.W, attr.H
+			case *tg.DocumentAttributeAudio:
+				folder, bm.MediaType = "files", "audio_file"
+				if attr.Voice {
+					folder, bm.MediaType = "voice_messages", "voice_message"
+				}
+				bm.Duration = attr.Duration
+			case *tg.DocumentAttributeSticker:
+				folder, bm.MediaType = "stickers", "sticker"
+			case *tg.DocumentAttributeImageSize:
+				bm.Width, bm.Height = attr.W, attr.H
+			}
+		}
+		// animation has video attribute, so it's checked at last
+		for _, attr := range doc.Attributes {
+			if _, ok := attr.(*tg.DocumentAttributeAnimated); ok && bm.MediaType != "sticker" {
+				folder, bm.MediaType = "video_files", "animation"
+			}
+		}
+
+		bm.MimeType, bm.FileSize = doc.MimeType, doc.Size
+		bm.File = filePath(folder)
+	case *tg.MessageMediaGeo:
+		if geo, ok := media.Geo.(*tg.GeoPoint); ok {
+			bm.Location = &backupLocation{Latitude: geo.Lat, Longitude: geo.Long}
+		}
+	case *tg.MessageMediaContact:
+		bm.Contact = &backupContact{FirstName: media.FirstName, LastName: media.LastName, PhoneNumber: media.PhoneNumber}
+	case *tg.MessageMediaPoll:
+		poll := &backupPoll{
+			Question:    media.Poll.Question.Text,
+			Closed:      media.Poll.Closed,
+			TotalVoters: media.Results.TotalVoters,
+			Answers:     make([]pollAnswer, 0, len(media.Poll.Answers)),
+		}
+		for _, a := range media.Poll.Answers {
+			answer := pollAnswer{Text: a.Text.Text}
+			for _, r := range media.Results.Results {
+				if string(r.Option) == string(a.Option) {
+					answer.Voters, answer.Chosen = r.Voters, r.Chosen
+				}
+			}
+			poll.Answers = append(poll.Answers, answer)
+		}
+		bm.Poll = poll
+	}
+}
+
+func (c *converter) service(ent peer.Entities, m *tg.MessageService) *backupMessage {
+	bm := &backupMessage{ID: m.ID, Type: "service"}
+	bm.Date, bm.DateUnix = formatDate(m.Date)
+	bm.Actor, bm.ActorID = c.sender(ent, m.FromID, m.Out)
+	bm.Text, bm.TextEntities = "", []textEntity{}
+
+	members := func(ids []int64) []string {
+		names := make([]string, 0, len(ids))
+		for _, id := range ids {
+			name, _ := peerName(ent, &tg.PeerUser{UserID: id})
+			names = append(names, name)
+		}
+		return names
+	}
+
+	switch a := m.Action.(type) {
+	case *tg.MessageActionChatCreate:
+		bm.Action, bm.Title, bm.Members = "create_group", a.Title, members(a.Users)
+	case *tg.MessageActionChannelCreate:
+		bm.Action, bm.Title = "create_channel", a.Title
+	case *tg.MessageActionChatEditTitle:
+		bm.Action, bm.Title = "edit_group_title", a.Title
+	case *tg.MessageActionChatEditPhoto:
+		bm.Action = "edit_group_photo"
+	case *tg.MessageActionChatDeletePhoto:
+		bm.Action = "delete_group_photo"
+	case *tg.MessageActionChatAddUser:
+		bm.Action, bm.Members = "invite_members", members(a.Users)
+	case *tg.MessageActionChatDeleteUser:
+		bm.Action, bm.Members = "remove_members", members([]int64{a.UserID})
+	case *tg.MessageActionChatJoinedByLink:
+		bm.Action = "join_group_by_link"
+	case *tg.MessageActionChatJoinedByRequest:
+		bm.Action = "join_group_by_request"
+	case *tg.MessageActionChatMigrateTo:
+		bm.Action = "migrate_to_supergroup"
+	case *tg.MessageActionChannelMigrateFrom:
+		bm.Action, bm.Title = "migrate_from_group", a.Title
+	case *tg.MessageActionPinMessage:
+		bm.Action = "pin_message"
+		if reply, ok := m.ReplyTo.(*tg.MessageReplyHeader); ok {
+			bm.MessageID = reply.ReplyToMsgID
+		}
+	case *tg.MessageActionHistoryClear:
+		bm.Action = "clear_history"
+	case *tg.MessageActionPhoneCall:
+		bm.Action = "phone_call"
+		bm.Duration, _ = a.GetDuration()
+	case *tg.MessageActionGroupCall:
+		bm.Action = "group_call"
+		bm.Duration, _ = a.GetDuration()
+	case *tg.MessageActionTopicCreate:
+		bm.Action, bm.Title = "topic_created", a.Title
+	case *tg.MessageActionTopicEdit:
+		bm.Action = "topic_edit"
+		bm.Title, _ = a.GetTitle()
+	default:
+		bm.Action = snakeCase(strings.TrimPrefix(m.Action.TypeName(), "messageAction"))
+	}
+
+	return bm
+}
+
+func snakeCase(s string) string {
+	b := &strings.Builder{}
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// convText splits text by entities like Telegram Desktop. Offsets of entities are in UTF-16 code units.
+// Nested entities are not supported, so only the outer one is kept.
+func convText(text string, entities []tg.MessageEntityClass) (any, []textEntity) {
+	u := utf16.Encode([]rune(text))
+	sub := func(from, to int) string { return string(utf16.Decode(u[from:to])) }
+
+	sorted := make([]tg.MessageEntityClass, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].GetOffset() < sorted[j].GetOffset() })
+
+	parts, cursor, formatted := make([]textEntity, 0), 0, false
+	for _, e := range sorted {
+		offset, end := e.GetOffset(), e.GetOffset()+e.GetLength()
+		if offset < cursor || end > len(u) || offset >= end {
+			continue
+		}
+
+		if offset > cursor {
+			parts = append(parts, textEntity{Type: "plain", Text: sub(cursor, offset)})
+		}
+		parts = append(parts, convEntity(e, sub(offset, end)))
+		cursor, formatted = end, true
+	}
+	if cursor < len(u) {
+		parts = append(parts, textEntity{Type: "plain", Text: sub(cursor, len(u))})
+	}
+
+	if !formatted {
+		return text, parts
+	}
+
+	mixed := make([]any, 0, len(parts))
+	for _, p := range parts {
+		if p.Type == "plain" {
+			mixed = append(mixed, p.Text)
+			continue
+		}
+		mixed = append(mixed, p)
+	}
+	return mixed, parts
+}
+
+func convEntity(e tg.MessageEntityClass, text string) textEntity {
+	t := textEntity{Text: text}
+
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		t.Type = "bold"
+	case *tg.MessageEntityItalic:
+		t.Type = "italic"
+	case *tg.MessageEntityUnderline:
+		t.Type = "underline"
+	case *tg.MessageEntityStrike:
+		t.Type = "strikethrough"
+	case *tg.MessageEntityCode:
+		t.Type = "code"
+	case *tg.MessageEntityPre:
+		t.Type, t.Language = "pre", e.Language
+	case *tg.MessageEntityURL:
+		t.Type = "link"
+	case *tg.MessageEntityTextURL:
+		t.Type, t.Href = "text_link", e.URL
+	case *tg.MessageEntityMention:
+		t.Type = "mention"
+	case *tg.MessageEntityMentionName:
+		t.Type, t.UserID = "mention_name", e.UserID
+	case *tg.MessageEntityHashtag:
+		t.Type = "hashtag"
+	case *tg.MessageEntityCashtag:
+		t.Type = "cashtag"
+	case *tg.MessageEntityBotCommand:
+		t.Type = "bot_command"
+	case *tg.MessageEntityEmail:
+		t.Type = "email"
+	case *tg.MessageEntityPhone:
+		t.Type = "phone"
+	case *tg.MessageEntitySpoiler:
+		t.Type = "spoiler"
+	case *tg.MessageEntityCustomEmoji:
+		t.Type, t.DocumentID = "custom_emoji", strconv.FormatInt(e.DocumentID, 10)
+	case *tg.MessageEntityBlockquote:
+		t.Type = "blockquote"
+	case *tg.MessageEntityBankCard:
+		t.Type = "bank_card"
+	default:
+		t.Type = "unknown"
+	}
+
+	return t
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/telegram/message/peer"
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvText(t *testing.T) {
+	text, entities := convText("hello world", nil)
+	assert.Equal(t, "hello world", text)
+	assert.Equal(t, []textEntity{{Type: "plain", Text: "hello world"}}, entities)
+
+	// emoji takes two UTF-16 code units
+	text, entities = convText("😀 bold and link", []tg.MessageEntityClass{
+		&tg.MessageEntityTextURL{Offset: 12, Length: 4, URL: "https://example.com"},
+		&tg.MessageEntityBold{Offset: 3, Length: 4},
+		&tg.MessageEntityItalic{Offset: 3, Length: 2}, // nested
+	})
+	assert.Equal(t, []any{
+		"😀 ",
+		textEntity{Type: "bold", Text: "bold"},
+		" and ",
+		textEntity{Type: "text_link", Text: "link", Href: "https://example.com"},
+	}, text)
+	assert.Equal(t, []textEntity{
+		{Type: "plain", Text: "😀 "},
+		{Type: "bold", Text: "bold"},
+		{Type: "plain", Text: " and "},
+		{Type: "text_link", Text: "link", Href: "https://example.com"},
+	}, entities)
+}
+
+func TestConverterMessage(t *testing.T) {
+	ent := peer.NewEntities(map[int64]*tg.User{
+		1: {ID: 1, FirstName: "Alice", LastName: "A"},
+		2: {ID: 2, FirstName: "Bob"},
+	}, nil, nil)
+
+	fwd := tg.MessageFwdHeader{}
+	fwd.SetFromID(&tg.PeerUser{UserID: 2})
+
+	msg := &tg.Message{
+		ID:      10,
+		Date:    1700000000,
+		FromID:  &tg.PeerUser{UserID: 1},
+		ReplyTo: &tg.MessageReplyHeader{ReplyToMsgID: 9},
+		Message: "caption",
+		Media: &tg.MessageMediaPhoto{Photo: &tg.Photo{
+			ID:    100,
+			DCID:  2,
+			Sizes: []tg.PhotoSizeClass{&tg.PhotoSize{Type: "x", W: 800, H: 600, Size: 1024}},
+		}},
+	}
+	msg.SetFwdFrom(fwd)
+	msg.SetReactions(tg.MessageReactions{Results: []tg.ReactionCount{
+		{Reaction: &tg.ReactionEmoji{Emoticon: "👍"}, Count: 3},
+		{Reaction: &tg.ReactionCustomEmoji{DocumentID: 5}, Count: 1},
+	}})
+
+	c := &converter{self: &tg.User{ID: 1}}
+	bm := c.message(ent, msg)
+	assert.Equal(t, "message", bm.Type)
+	assert.Equal(t, "1700000000", bm.DateUnix)
+	assert.Equal(t, "Alice A", bm.From)
+	assert.Equal(t, "user1", bm.FromID)
+	assert.Equal(t, "Bob", bm.ForwardedFrom)
+	assert.Equal(t, 9, bm.ReplyTo)
+	assert.Equal(t, 800, bm.Width)
+	assert.Equal(t, 600, bm.Height)
+	assert.Equal(t, fileNotIncluded, bm.Photo)
+	assert.Nil(t, bm.media)
+	assert.Equal(t, "caption", bm.Text)
+	assert.Equal(t, []reaction{
+		{Type: "emoji", Count: 3, Emoji: "👍"},
+		{Type: "custom_emoji", Count: 1, DocumentID: "5"},
+	}, bm.Reactions)
+
+	c.withMedia = true
+	bm = c.message(ent, msg)
+	assert.Equal(t, "photos/10_100.jpg", bm.Photo)
+	require.NotNil(t, bm.media)
+	assert.Equal(t, int64(1024), bm.media.Size)
+}
+
+func TestConverterService(t *testing.T) {
+	ent := peer.NewEntities(map[int64]*tg.User{1: {ID: 1, FirstName: "Alice"}}, nil, nil)
+	c := &converter{self: &tg.User{ID: 1}}
+
+	bm := c.service(ent, &tg.MessageService{
+		ID:     1,
+		FromID: &tg.PeerUser{UserID: 1},
+		Action: &tg.MessageActionChatAddUser{Users: []int64{1}},
+	})
+	assert.Equal(t, "service", bm.Type)
+	assert.Equal(t, "Alice", bm.Actor)
+	assert.Equal(t, "invite_members", bm.Action)
+	assert.Equal(t, []string{"Alice"}, bm.Members)
+
+	bm = c.service(ent, &tg.MessageService{
+		ID:     2,
+		FromID: &tg.PeerUser{UserID: 1},
+		Action: &tg.MessageActionScreenshotTaken{},
+	})
+	assert.Equal(t, "screenshot_taken", bm.Action)
+}
+
+func TestRenderText(t *testing.T) {
+	assert.Equal(t, `a&lt;b&gt;<br><strong>x</strong><a href="https://example.com">y</a>`, string(renderText([]textEntity{
+		{Type: "plain", Text: "a<b>\n"},
+		{Type: "bold", Text: "x"},
+		{Type: "text_link", Text: "y", Href: "https://example.com"},
+	})))
+}
+
+func TestWriteBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	result := &backupChat{
+		Name: "test",
+		Type: "personal_chat",
+		ID:   1,
+		Messages: []*backupMessage{{
+			ID:           1,
+			Type:         "message",
+			Text:         "<hello>",
+			TextEntities: []textEntity{{Type: "plain", Text: "<hello>"}},
+			Photo:        "photos/1_1.jpg",
+		}},
+	}
+	require.NoError(t, writeBackup(dir, result, true))
+
+	b, err := os.ReadFile(filepath.Join(dir, backupJSON))
+	require.NoError(t, err)
+
+	var got backupChat
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "test", got.Name)
+	require.Len(t, got.Messages, 1)
+	assert.Equal(t, "photos/1_1.jpg", got.Messages[0].Photo)
+
+	html, err := os.ReadFile(filepath.Join(dir, backupHTML))
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "&lt;hello&gt;")
+	assert.Contains(t, string(html), "photos/1_1.jpg")
+}
@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/fatih/color"
-	"github.com/go-faster/jx"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/telegram/query"
@@ -29,8 +31,9 @@ import (
 
 type ExportOptions struct {
 	Type        ExportType
-	Chat        string
-	Thread      int // topic id in forum, message id in group
+	Chats       []string // chat ids or domains, defaults to 'Saved Messages' if empty
+	ChatFilter  string   // export dialogs matching the 'chat ls' filter expression as well
+	Thread      int      // topic id in forum, message id in group
 	Input       []int
 	Output      string
 	Filter      string
@@ -38,6 +41,21 @@ type ExportOptions struct {
 	WithContent bool
 	Raw         bool
 	All         bool
+
+	// export like Telegram Desktop into Output directory
+	Backup    bool
+	WithMedia bool // download media alongside
+	HTML      bool // render messages.html
+
+	Format ExportFormat
+
+	// narrow messages by server-side search before local filter
+	Search    string
+	MediaType MediaType
+	FromUser  string
+
+	// export only messages newer than the last exported one, and append them to existing output
+	Incremental bool
 }
 
 type Message struct {
@@ -54,6 +72,19 @@ type Message struct {
 type ExportType int
 
 func Export(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts ExportOptions) (rerr error) {
+	// only output available fields of dialogs
+	if opts.ChatFilter == "-" {
+		fg := texpr.NewFieldsGetter(nil)
+
+		fields, err := fg.Walk(&Dialog{})
+		if err != nil {
+			return fmt.Errorf("failed to walk fields: %w", err)
+		}
+
+		fmt.Print(fg.Sprint(fields, true))
+		return nil
+	}
+
 	// only output available fields
 	if opts.Filter == "-" {
 		fg := texpr.NewFieldsGetter(nil)
@@ -72,102 +103,186 @@ func Export(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts E
 		return fmt.Errorf("failed to compile filter: %w", err)
 	}
 
-	var peer peers.Peer
-
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(c.API())
-	if opts.Chat == "" { // defaults to me(saved messages)
-		peer, err = manager.Self(ctx)
-	} else {
-		peer, err = tutil.GetInputPeer(ctx, manager, opts.Chat)
-	}
+	chats, err := exportPeers(ctx, c, kvd, manager, opts)
 	if err != nil {
-		return fmt.Errorf("failed to get peer: %w", err)
+		return err
 	}
 
-	color.Yellow("WARN: Export only generates minimal JSON for tdl download, not for backup.")
 	color.Cyan("Occasional suspensions are due to Telegram rate limitations, please wait a moment.")
-	fmt.Println()
 
-	color.Blue("Type: %s | Input: %v", opts.Type, opts.Input)
+	if !opts.Multiple() {
+		return exportChat(ctx, c, kvd, manager, chats[0], filter, opts)
+	}
 
-	pw := prog.New(progress.FormatNumber)
-	pw.SetUpdateFrequency(200 * time.Millisecond)
-	pw.Style().Visibility.TrackerOverall = false
-	pw.Style().Visibility.ETA = false
-	pw.Style().Visibility.Percentage = false
+	// one file or backup directory per chat under the output directory
+	if err = os.MkdirAll(opts.Output, 0o755); err != nil {
+		return fmt.Errorf("failed to create output dir: %w", err)
+	}
 
-	tracker := prog.AppendTracker(pw, progress.FormatNumber, fmt.Sprintf("%s-%d", peer.VisibleName(), peer.ID()), 0)
+	for i, peer := range chats {
+		o := opts
+		o.Output = filepath.Join(opts.Output, strconv.FormatInt(peer.ID(), 10))
+		if !opts.Backup {
+			o.Output += opts.Format.Ext()
+		}
 
-	go pw.Render()
+		color.Magenta("[%d/%d] %s(%d)", i+1, len(chats), peer.VisibleName(), peer.ID())
+		if err = exportChat(ctx, c, kvd, manager, peer, filter, o); err != nil {
+			// export other chats, and report errors at last
+			color.Red("Export %s(%d) failed: %v", peer.VisibleName(), peer.ID(), err)
+			rerr = multierr.Append(rerr, fmt.Errorf("export %d: %w", peer.ID(), err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
 
-	var q messages.Query
-	switch {
-	case opts.Thread != 0: // topic messages, reply messages
-		q = query.NewQuery(c.API()).Messages().GetReplies(peer.InputPeer()).MsgID(opts.Thread)
-	default: // history
-		q = query.NewQuery(c.API()).Messages().GetHistory(peer.InputPeer())
+	return rerr
+}
+
+// Multiple reports whether more than one chat may be exported, and then output is a directory
+func (opts ExportOptions) Multiple() bool {
+	return len(opts.Chats) > 1 || opts.ChatFilter != ""
+}
+
+// exportPeers resolves chats and dialogs matching the chat filter, without duplicates
+func exportPeers(ctx context.Context, c *telegram.Client, kvd storage.Storage, manager *peers.Manager, opts ExportOptions) ([]peers.Peer, error) {
+	if len(opts.Chats) == 0 && opts.ChatFilter == "" { // defaults to me(saved messages)
+		self, err := manager.Self(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get peer: %w", err)
+		}
+		return []peers.Peer{self}, nil
 	}
-	iter := messages.NewIterator(q, 100)
 
-	switch opts.Type {
-	case ExportTypeTime:
-		iter = iter.OffsetDate(opts.Input[1] + 1)
-	case ExportTypeId:
-		iter = iter.OffsetID(opts.Input[1] + 1) // #89: retain the last msg id
-	case ExportTypeLast:
+	result := make([]peers.Peer, 0, len(opts.Chats))
+	seen := make(map[int64]struct{})
+	add := func(chat string) error {
+		peer, err := tutil.GetInputPeer(ctx, manager, chat)
+		if err != nil {
+			return fmt.Errorf("failed to get peer %q: %w", chat, err)
+		}
+		if _, ok := seen[peer.ID()]; !ok {
+			seen[peer.ID()] = struct{}{}
+			result = append(result, peer)
+		}
+		return nil
 	}
 
-	f, err := os.Create(opts.Output)
-	if err != nil {
-		return err
+	for _, chat := range opts.Chats {
+		if err := add(chat); err != nil {
+			return nil, err
+		}
 	}
-	defer multierr.AppendInvoke(&rerr, multierr.Close(f))
 
-	enc := jx.NewStreamingEncoder(f, 512)
-	defer multierr.AppendInvoke(&rerr, multierr.Close(enc))
+	if opts.ChatFilter != "" {
+		filter, err := expr.Compile(opts.ChatFilter, expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile chat filter: %w", err)
+		}
 
-	// process thread is reply type and peer is broadcast channel,
-	// so we need to set discussion group id instead of broadcast id
-	id := peer.ID()
-	if p, ok := peer.(peers.Channel); opts.Thread != 0 && ok && p.IsBroadcast() {
-		bc, _ := p.ToBroadcast()
-		raw, err := bc.FullRaw(ctx)
+		dialogs, err := listDialogs(ctx, c, kvd, filter)
 		if err != nil {
-			return fmt.Errorf("failed to get broadcast full raw: %w", err)
+			return nil, err
 		}
+		for _, d := range dialogs {
+			if err = add(strconv.FormatInt(d.ID, 10)); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-		if id, ok = raw.GetLinkedChatID(); !ok {
-			return fmt.Errorf("no linked group")
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no chats match the chat filter")
+	}
+	return result, nil
+}
+
+func exportChat(ctx context.Context, c *telegram.Client, kvd storage.Storage, manager *peers.Manager,
+	peer peers.Peer, filter *vm.Program, opts ExportOptions,
+) (rerr error) {
+	if opts.Backup {
+		return backup(ctx, c, kvd, manager, peer, filter, opts)
+	}
+
+	color.Yellow("WARN: Export only generates minimal JSON for tdl download, use --backup for backup.")
+	fmt.Println()
+
+	color.Blue("Type: %s | Input: %v", opts.Type, opts.Input)
+
+	id, err := exportID(ctx, peer, opts)
+	if err != nil {
+		return err
+	}
+
+	since, exported := 0, []json.RawMessage(nil)
+	if opts.Incremental {
+		if since, err = loadIncremental(ctx, kvd, id, opts); err != nil {
+			return err
 		}
+		// flat formats are appended to directly
+		if opts.Format == ExportFormatJson {
+			if exported, err = readExported(opts.Output, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	iter, err := newMessageIter(ctx, c, manager, peer, opts)
+	if err != nil {
+		return err
+	}
+
+	pw, tracker := newExportProgress(fmt.Sprintf("%s-%d", peer.VisibleName(), peer.ID()))
+	go pw.Render()
+
+	w, err := newOutputWriter(opts.Format, opts.Output, id, opts.Incremental, messagesTable, mediaTable)
+	if err != nil {
+		return err
 	}
+	// no-op if committed, otherwise the existing output is kept
+	defer multierr.AppendInvoke(&rerr, multierr.Close(w))
 
-	enc.ObjStart()
-	defer enc.ObjEnd()
-	enc.Field("id", func(e *jx.Encoder) { e.Int64(id) })
+	newest, err := exportMessages(ctx, iter, w, id, filter, since, exported, opts, tracker)
+	if err != nil {
+		return err
+	}
+	if err = w.commit(); err != nil {
+		return err
+	}
 
-	enc.FieldStart("messages")
-	enc.ArrStart()
-	defer enc.ArrEnd()
+	tracker.MarkAsDone()
+	prog.Wait(ctx, pw)
 
-	count := int64(0)
+	if opts.Incremental {
+		return saveIncremental(ctx, kvd, id, opts, newest)
+	}
+	return nil
+}
+
+// messageIter iterates messages of the chat, which is implemented by messages.Iterator
+type messageIter interface {
+	Next(ctx context.Context) bool
+	Value() messages.Elem
+	Err() error
+}
+
+// exportMessages writes messages newer than since, followed by previously exported ones,
+// and returns id of the newest message
+func exportMessages(ctx context.Context, iter messageIter, w recordWriter, id int64, filter *vm.Program,
+	since int, exported []json.RawMessage, opts ExportOptions, tracker *progress.Tracker,
+) (int, error) {
+	w.begin("messages")
+
+	count, newest := int64(0), since
 
-loop:
 	for iter.Next(ctx) {
 		msg := iter.Value()
-		switch opts.Type {
-		case ExportTypeTime:
-			if msg.Msg.GetDate() < opts.Input[0] {
-				break loop
-			}
-		case ExportTypeId:
-			if msg.Msg.GetID() < opts.Input[0] {
-				break loop
-			}
-		case ExportTypeLast:
-			if count >= int64(opts.Input[0]) {
-				break loop
-			}
+		if outOfRange(opts, msg.Msg, count) || msg.Msg.GetID() <= since {
+			break
 		}
+		newest = max(newest, msg.Msg.GetID())
 
 		m, ok := msg.Msg.(*tg.Message)
 		if !ok {
@@ -181,44 +296,148 @@ loop:
 
 		b, err := texpr.Run(filter, texpr.ConvertEnvMessage(m))
 		if err != nil {
-			return fmt.Errorf("failed to run filter: %w", err)
+			return 0, fmt.Errorf("failed to run filter: %w", err)
 		}
 		if !b.(bool) { // filtered
 			continue
 		}
 
-		fileName := ""
-		if media != nil { // #207
-			fileName = media.Name
+		if err = writeMessage(w, id, m, media, opts.WithContent, opts.Raw); err != nil {
+			return 0, fmt.Errorf("failed to write message: %w", err)
 		}
-		t := &Message{
-			ID:   m.ID,
-			Type: "message",
-			File: fileName,
-		}
-		if opts.WithContent {
-			t.Date = m.Date
-			t.Text = m.Message
-		}
-		if opts.Raw {
-			t.Raw = m
+
+		count++
+		tracker.SetValue(count)
+	}
+
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+
+	// previously exported messages are older than new ones
+	for _, m := range exported {
+		if err := w.write(record{field: "messages", obj: m}); err != nil {
+			return 0, fmt.Errorf("failed to write message: %w", err)
 		}
+	}
+
+	return newest, nil
+}
+
+// writeMessage writes the message, and its media into media table of flat formats
+func writeMessage(w recordWriter, chat int64, m *tg.Message, media *tmedia.Media, withContent, raw bool) error {
+	fileName := ""
+	if media != nil { // #207
+		fileName = media.Name
+	}
+	t := &Message{
+		ID:   m.ID,
+		Type: "message",
+		File: fileName,
+	}
+	if withContent {
+		t.Date = m.Date
+		t.Text = m.Message
+	}
 
-		mb, err := json.Marshal(t)
+	var rawMsg json.RawMessage
+	if raw {
+		t.Raw = m
+
+		b, err := json.Marshal(m)
 		if err != nil {
-			return fmt.Errorf("failed to marshal message: %w", err)
+			return fmt.Errorf("failed to marshal raw message: %w", err)
 		}
-		enc.Raw(mb)
-
-		count++
-		tracker.SetValue(count)
+		rawMsg = b
 	}
 
-	if err = iter.Err(); err != nil {
+	if err := w.write(record{
+		field: "messages",
+		obj:   t,
+		table: messagesTable,
+		row:   []any{chat, t.ID, t.Type, t.File, t.Date, t.Text, rawMsg},
+	}); err != nil {
 		return err
 	}
 
-	tracker.MarkAsDone()
-	prog.Wait(ctx, pw)
-	return nil
+	if media == nil {
+		return nil
+	}
+	return w.write(record{
+		table: mediaTable,
+		row:   []any{chat, m.ID, media.Name, media.Size, media.DC, media.Date},
+	})
+}
+
+func newExportProgress(name string) (progress.Writer, *progress.Tracker) {
+	pw := prog.New(progress.FormatNumber)
+	pw.SetUpdateFrequency(200 * time.Millisecond)
+	pw.Style().Visibility.TrackerOverall = false
+	pw.Style().Visibility.ETA = false
+	pw.Style().Visibility.Percentage = false
+
+	tracker := prog.AppendTracker(pw, progress.FormatNumber, name, 0)
+	return pw, tracker
+}
+
+// newMessageIter iterates messages from the newest one in export range
+func newMessageIter(ctx context.Context, c *telegram.Client, manager *peers.Manager, peer peers.Peer, opts ExportOptions) (*messages.Iterator, error) {
+	var (
+		q   messages.Query
+		err error
+	)
+	switch {
+	case opts.searching(): // server-side search
+		if q, err = newSearchQuery(ctx, c, manager, peer, opts); err != nil {
+			return nil, err
+		}
+	case opts.Thread != 0: // topic messages, reply messages
+		q = query.NewQuery(c.API()).Messages().GetReplies(peer.InputPeer()).MsgID(opts.Thread)
+	default: // history
+		q = query.NewQuery(c.API()).Messages().GetHistory(peer.InputPeer())
+	}
+	iter := messages.NewIterator(q, 100)
+
+	switch opts.Type {
+	case ExportTypeTime:
+		iter = iter.OffsetDate(opts.Input[1] + 1)
+	case ExportTypeId:
+		iter = iter.OffsetID(opts.Input[1] + 1) // #89: retain the last msg id
+	case ExportTypeLast:
+	}
+
+	return iter, nil
+}
+
+// outOfRange reports whether the message and the following ones are out of export range
+func outOfRange(opts ExportOptions, msg tg.NotEmptyMessage, count int64) bool {
+	switch opts.Type {
+	case ExportTypeTime:
+		return msg.GetDate() < opts.Input[0]
+	case ExportTypeId:
+		return msg.GetID() < opts.Input[0]
+	case ExportTypeLast:
+		return count >= int64(opts.Input[0])
+	default:
+		return false
+	}
+}
+
+// exportID returns id of exported chat. If thread is reply type and peer is broadcast channel,
+// we need to set discussion group id instead of broadcast id.
+func exportID(ctx context.Context, peer peers.Peer, opts ExportOptions) (int64, error) {
+	id := peer.ID()
+	if p, ok := peer.(peers.Channel); opts.Thread != 0 && ok && p.IsBroadcast() {
+		bc, _ := p.ToBroadcast()
+		raw, err := bc.FullRaw(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get broadcast full raw: %w", err)
+		}
+
+		if id, ok = raw.GetLinkedChatID(); !ok {
+			return 0, fmt.Errorf("no linked group")
+		}
+	}
+
+	return id, nil
 }
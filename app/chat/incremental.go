@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/key"
+)
+
+// markScope identifies the output and options of an incremental export, so that marks of
+// different outputs, formats or filters don't skip messages of each other
+func markScope(opts ExportOptions) (string, error) {
+	output, err := filepath.Abs(opts.Output)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute output path")
+	}
+
+	b, err := json.Marshal([]any{
+		output, opts.Format, opts.Backup,
+		opts.Type, opts.Input, opts.Filter, opts.All, opts.OnlyMedia,
+		opts.Search, opts.MediaType, opts.FromUser,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal mark scope")
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// loadMark returns the id of the newest exported message of the chat/thread, 0 if it's never exported incrementally
+func loadMark(ctx context.Context, kvd storage.Storage, chat int64, thread int, scope string) (int, error) {
+	b, err := kvd.Get(ctx, key.ExportMark(chat, thread, scope))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "get export mark")
+	}
+
+	mark, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, errors.Wrap(err, "parse export mark")
+	}
+	return mark, nil
+}
+
+// saveMark stores the id of the newest exported message, and never moves the mark backwards
+func saveMark(ctx context.Context, kvd storage.Storage, chat int64, thread int, scope string, mark int) error {
+	prev, err := loadMark(ctx, kvd, chat, thread, scope)
+	if err != nil {
+		return err
+	}
+	if mark <= prev {
+		return nil
+	}
+
+	if err = kvd.Set(ctx, key.ExportMark(chat, thread, scope), []byte(strconv.Itoa(mark))); err != nil {
+		return errors.Wrap(err, "set export mark")
+	}
+	return nil
+}
+
+// readExported reads messages of the existing JSON export, which are appended after newly exported ones.
+// Nil is returned if the file doesn't exist.
+func readExported(path string, chat int64) ([]json.RawMessage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read existing export")
+	}
+
+	var exported struct {
+		ID       int64             `json:"id"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err = json.Unmarshal(b, &exported); err != nil {
+		return nil, errors.Wrap(err, "parse existing export")
+	}
+	if exported.ID != chat {
+		return nil, errors.Errorf("existing export %q is from another chat: %d", path, exported.ID)
+	}
+
+	return exported.Messages, nil
+}
+
+// readBackup reads messages of the existing backup in the directory, nil if it doesn't exist
+func readBackup(dir string, chat int64) ([]*backupMessage, error) {
+	b, err := os.ReadFile(filepath.Join(dir, backupJSON))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read existing backup")
+	}
+
+	var exported backupChat
+	if err = json.Unmarshal(b, &exported); err != nil {
+		return nil, errors.Wrap(err, "parse existing backup")
+	}
+	if exported.ID != chat {
+		return nil, errors.Errorf("existing backup in %q is from another chat: %d", dir, exported.ID)
+	}
+
+	return exported.Messages, nil
+}
+
+// loadIncremental returns the id of the newest exported message, and messages not newer than it are skipped.
+// It fails if the output of the previous export is gone, otherwise messages before the mark would be lost.
+func loadIncremental(ctx context.Context, kvd storage.Storage, chat int64, opts ExportOptions) (int, error) {
+	scope, err := markScope(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	since, err := loadMark(ctx, kvd, chat, opts.Thread, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	if since > 0 {
+		if _, err = os.Stat(opts.Output); err != nil {
+			if os.IsNotExist(err) {
+				return 0, errors.Errorf("output %q of previous incremental export doesn't exist, remove --incremental to export all messages", opts.Output)
+			}
+			return 0, errors.Wrap(err, "stat output")
+		}
+	}
+
+	if since > 0 {
+		color.Blue("Incremental: export messages after #%d", since)
+	}
+	return since, nil
+}
+
+// saveIncremental stores the id of the newest exported message for the next incremental export
+func saveIncremental(ctx context.Context, kvd storage.Storage, chat int64, opts ExportOptions, mark int) error {
+	scope, err := markScope(opts)
+	if err != nil {
+		return err
+	}
+	return saveMark(ctx, kvd, chat, opts.Thread, scope, mark)
+}
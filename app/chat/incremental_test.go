@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/expr-lang/expr"
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/core/storage"
+)
+
+type memStorage map[string][]byte
+
+func (m memStorage) Get(_ context.Context, key string) ([]byte, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m memStorage) Set(_ context.Context, key string, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+func (m memStorage) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestMark(t *testing.T) {
+	ctx, kvd := context.Background(), memStorage{}
+
+	mark, err := loadMark(ctx, kvd, 1, 0, "a")
+	require.NoError(t, err)
+	assert.Zero(t, mark)
+
+	require.NoError(t, saveMark(ctx, kvd, 1, 0, "a", 100))
+	require.NoError(t, saveMark(ctx, kvd, 1, 0, "a", 50)) // never moves backwards
+	require.NoError(t, saveMark(ctx, kvd, 1, 5, "a", 10))
+
+	mark, err = loadMark(ctx, kvd, 1, 0, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 100, mark)
+
+	mark, err = loadMark(ctx, kvd, 1, 5, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 10, mark)
+
+	mark, err = loadMark(ctx, kvd, 1, 0, "b")
+	require.NoError(t, err)
+	assert.Zero(t, mark)
+}
+
+func TestIncremental(t *testing.T) {
+	ctx, kvd := context.Background(), memStorage{}
+	dir := t.TempDir()
+	opts := ExportOptions{Output: filepath.Join(dir, "a.json"), Format: ExportFormatJson}
+
+	require.NoError(t, os.WriteFile(opts.Output, []byte(`{"id":1,"messages":[]}`), 0o644))
+	require.NoError(t, saveIncremental(ctx, kvd, 1, opts, 100))
+
+	since, err := loadIncremental(ctx, kvd, 1, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 100, since)
+
+	// marks are scoped by output and options
+	other := opts
+	other.Output = filepath.Join(dir, "b.json")
+	since, err = loadIncremental(ctx, kvd, 1, other)
+	require.NoError(t, err)
+	assert.Zero(t, since)
+
+	other = opts
+	other.Filter = "Media.Size > 0"
+	since, err = loadIncremental(ctx, kvd, 1, other)
+	require.NoError(t, err)
+	assert.Zero(t, since)
+
+	// output of previous export is gone
+	require.NoError(t, os.Remove(opts.Output))
+	_, err = loadIncremental(ctx, kvd, 1, opts)
+	assert.Error(t, err)
+}
+
+func TestReadExported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.json")
+
+	messages, err := readExported(path, 1)
+	require.NoError(t, err)
+	assert.Nil(t, messages)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":1,"messages":[{"id":2,"type":"message","file":"a.jpg"}]}`), 0o644))
+
+	messages, err = readExported(path, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"id":2,"type":"message","file":"a.jpg"}`)}, messages)
+
+	_, err = readExported(path, 2)
+	assert.Error(t, err)
+}
+
+func TestReadBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	messages, err := readBackup(dir, 1)
+	require.NoError(t, err)
+	assert.Nil(t, messages)
+
+	require.NoError(t, writeBackup(dir, &backupChat{
+		ID:       1,
+		Messages: []*backupMessage{{ID: 1, Type: "message", Text: "a"}, {ID: 2, Type: "message", Text: "b"}},
+	}, false))
+
+	messages, err = readBackup(dir, 1)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, 2, messages[1].ID)
+
+	_, err = readBackup(dir, 2)
+	assert.Error(t, err)
+}
+
+// fakeIter returns messages and then fails with err
+type fakeIter struct {
+	msgs []tg.NotEmptyMessage
+	err  error
+	cur  tg.NotEmptyMessage
+}
+
+func (f *fakeIter) Next(context.Context) bool {
+	if len(f.msgs) == 0 {
+		return false
+	}
+	f.cur, f.msgs = f.msgs[0], f.msgs[1:]
+	return true
+}
+
+func (f *fakeIter) Value() messages.Elem { return messages.Elem{Msg: f.cur} }
+
+func (f *fakeIter) Err() error { return f.err }
+
+func TestExportMessagesKeepOutput(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "export.json")
+	old := `{"id":1,"messages":[{"id":2,"type":"message","file":"a.jpg"}]}`
+	require.NoError(t, os.WriteFile(path, []byte(old), 0o644))
+
+	filter, err := expr.Compile("true", expr.AsBool())
+	require.NoError(t, err)
+	opts := ExportOptions{Type: ExportTypeId, Input: []int{0, 0}, Output: path, Format: ExportFormatJson, All: true, Incremental: true}
+
+	exported, err := readExported(path, 1)
+	require.NoError(t, err)
+
+	export := func(iter messageIter) error {
+		w, err := newOutputWriter(opts.Format, path, 1, true, messagesTable, mediaTable)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, w.Close()) }()
+
+		if _, err = exportMessages(ctx, iter, w, 1, filter, 2, exported, opts, &progress.Tracker{}); err != nil {
+			return err
+		}
+		return w.commit()
+	}
+
+	// iteration fails after some new messages are written
+	err = export(&fakeIter{msgs: []tg.NotEmptyMessage{&tg.Message{ID: 4}, &tg.Message{ID: 3}}, err: errors.New("flood wait")})
+	require.Error(t, err)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, old, string(b))
+	_, err = os.Stat(path + tempExt)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, export(&fakeIter{msgs: []tg.NotEmptyMessage{&tg.Message{ID: 3}}}))
+
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"messages":[{"id":3,"type":"message","file":""},{"id":2,"type":"message","file":"a.jpg"}]}`, string(b))
+}
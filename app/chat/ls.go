@@ -8,8 +8,8 @@ import (
 	"strings"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/fatih/color"
-	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/message/peer"
 	"github.com/gotd/td/telegram/peers"
@@ -57,8 +57,6 @@ type ListOptions struct {
 }
 
 func List(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts ListOptions) error {
-	log := logctx.From(ctx)
-
 	// align output
 	runewidth.EastAsianWidth = false
 	runewidth.DefaultCondition.EastAsianWidth = false
@@ -80,6 +78,32 @@ func List(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Lis
 		return fmt.Errorf("failed to compile filter: %w", err)
 	}
 
+	result, err := listDialogs(ctx, c, kvd, filter)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Output {
+	case ListOutputTable:
+		printTable(result)
+	case ListOutputJson:
+		bytes, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			return fmt.Errorf("marshal json: %w", err)
+		}
+
+		fmt.Println(string(bytes))
+	default:
+		return fmt.Errorf("unknown output: %s", opts.Output)
+	}
+
+	return nil
+}
+
+// listDialogs returns dialogs matching the filter, and updates access hashes of peers in storage
+func listDialogs(ctx context.Context, c *telegram.Client, kvd storage.Storage, filter *vm.Program) ([]*Dialog, error) {
+	log := logctx.From(ctx)
+
 	// Manually iterate through dialogs to handle errors gracefully
 	// This allows us to skip problematic dialogs (deleted/inaccessible channels)
 	// rather than failing completely when ExtractPeer fails
@@ -92,7 +116,7 @@ func List(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Lis
 
 	blocked, err := tutil.GetBlockedDialogs(ctx, c.API())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(c.API())
@@ -128,7 +152,7 @@ func List(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Lis
 		// filter
 		b, err := texpr.Run(filter, r)
 		if err != nil {
-			return fmt.Errorf("failed to run filter: %w", err)
+			return nil, fmt.Errorf("failed to run filter: %w", err)
 		}
 		if !b.(bool) {
 			continue
@@ -137,21 +161,7 @@ func List(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Lis
 		result = append(result, r)
 	}
 
-	switch opts.Output {
-	case ListOutputTable:
-		printTable(result)
-	case ListOutputJson:
-		bytes, err := json.MarshalIndent(result, "", "\t")
-		if err != nil {
-			return fmt.Errorf("marshal json: %w", err)
-		}
-
-		fmt.Println(string(bytes))
-	default:
-		return fmt.Errorf("unknown output: %s", opts.Output)
-	}
-
-	return nil
+	return result, nil
 }
 
 func printTable(result []*Dialog) {
@@ -232,7 +242,7 @@ func processChannel(ctx context.Context, api *tg.Client, id int64, entities peer
 	}
 
 	if c.Forum {
-		topics, err := fetchTopics(ctx, api, c.AsInput())
+		topics, err := tutil.GetForumTopics(ctx, api, c.AsInput())
 		if err != nil {
 			logctx.From(ctx).Error("failed to fetch topics",
 				zap.Int64("channel_id", c.ID),
@@ -241,95 +251,16 @@ func processChannel(ctx context.Context, api *tg.Client, id int64, entities peer
 			return nil
 		}
 
-		d.Topics = topics
-	}
-
-	return d
-}
-
-// fetchTopics https://github.com/telegramdesktop/tdesktop/blob/4047f1733decd5edf96d125589f128758b68d922/Telegram/SourceFiles/data/data_forum.cpp#L135
-func fetchTopics(ctx context.Context, api *tg.Client, c tg.InputChannelClass) ([]Topic, error) {
-	log := logctx.From(ctx)
-	res := make([]Topic, 0)
-	limit := 100 // why can't we use 500 like tdesktop?
-	offsetTopic, offsetID, offsetDate := 0, 0, 0
-	lastOffsetTopic := -1 // Track the last offsetTopic to detect infinite loops
-
-	// Track seen offsetTopics to detect cycles
-	seenOffsets := make(map[int]bool)
-
-	for {
-		// Detect infinite loop: if offsetTopic hasn't changed or we've seen it before
-		if offsetTopic == lastOffsetTopic && lastOffsetTopic != -1 {
-			log.Warn("pagination stuck (same offset), breaking loop",
-				zap.Int("offset_topic", offsetTopic))
-			break
-		}
-		if seenOffsets[offsetTopic] {
-			log.Warn("pagination cycle detected, breaking loop",
-				zap.Int("offset_topic", offsetTopic))
-			break
-		}
-		seenOffsets[offsetTopic] = true
-		lastOffsetTopic = offsetTopic
-
-		req := &tg.ChannelsGetForumTopicsRequest{
-			Channel:     c,
-			Limit:       limit,
-			OffsetTopic: offsetTopic,
-			OffsetID:    offsetID,
-			OffsetDate:  offsetDate,
-		}
-
-		topics, err := api.ChannelsGetForumTopics(ctx, req)
-		if err != nil {
-			return nil, errors.Wrap(err, "get forum topics")
-		}
-
-		// If no topics returned, we're done
-		if len(topics.Topics) == 0 {
-			break
-		}
-
-		for _, tp := range topics.Topics {
-			if t, ok := tp.(*tg.ForumTopic); ok {
-				res = append(res, Topic{
-					ID:    t.ID,
-					Title: t.Title,
-				})
-
-				offsetTopic = t.ID
-			}
-		}
-
-		// Safety break if we've collected all topics
-		if len(res) >= topics.Count {
-			break
-		}
-
-		// last page
-		if len(topics.Topics) < limit {
-			break
-		}
-
-		// Update offset using last message if available
-		// Use a local variable for length to be absolutely safe against index out of range
-		msgCount := len(topics.Messages)
-		if msgCount > 0 {
-			if lastMsg, ok := topics.Messages[msgCount-1].AsNotEmpty(); ok {
-				offsetID, offsetDate = lastMsg.GetID(), lastMsg.GetDate()
-			} else {
-				log.Debug("no valid message for offset, relying on offsetTopic only",
-					zap.Int("offset_topic", offsetTopic))
-			}
-		} else {
-			log.Debug("no messages in topics response, relying on offsetTopic only",
-				zap.Int("offset_topic", offsetTopic),
-				zap.Int("topics_count", len(topics.Topics)))
+		d.Topics = make([]Topic, 0, len(topics))
+		for _, t := range topics {
+			d.Topics = append(d.Topics, Topic{
+				ID:    t.ID,
+				Title: t.Title,
+			})
 		}
 	}
 
-	return res, nil
+	return d
 }
 
 func processChat(id int64, entities peer.Entities) *Dialog {
@@ -0,0 +1,448 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/go-faster/jx"
+	"go.uber.org/multierr"
+	_ "modernc.org/sqlite" // register sqlite driver
+)
+
+//go:generate go-enum --names --values --flag --nocase
+
+// ExportFormat
+// ENUM(json, jsonl, csv, sqlite)
+type ExportFormat int
+
+// Ext returns the file extension of the format
+func (x ExportFormat) Ext() string {
+	if x == ExportFormatSqlite {
+		return ".db"
+	}
+	return "." + x.String()
+}
+
+// table is the schema of flat formats, which are CSV, JSONL and SQLite
+type table struct {
+	name    string
+	columns []column
+	primary []string // primary key of SQLite, rows with the same key are replaced
+}
+
+type column struct {
+	name string
+	typ  string // SQLite type
+}
+
+var (
+	messagesTable = &table{
+		name: "messages",
+		columns: []column{
+			{"chat_id", "INTEGER"},
+			{"id", "INTEGER"},
+			{"type", "TEXT"},
+			{"file", "TEXT"},
+			{"date", "INTEGER"},
+			{"text", "TEXT"},
+			{"raw", "TEXT"},
+		},
+		primary: []string{"chat_id", "id"},
+	}
+	mediaTable = &table{
+		name: "media",
+		columns: []column{
+			{"chat_id", "INTEGER"},
+			{"message_id", "INTEGER"},
+			{"name", "TEXT"},
+			{"size", "INTEGER"},
+			{"dc", "INTEGER"},
+			{"date", "INTEGER"},
+		},
+		primary: []string{"chat_id", "message_id"},
+	}
+	usersTable = &table{
+		name: "users",
+		columns: []column{
+			{"chat_id", "INTEGER"},
+			{"role", "TEXT"},
+			{"id", "INTEGER"},
+			{"username", "TEXT"},
+			{"phone", "TEXT"},
+			{"first_name", "TEXT"},
+			{"last_name", "TEXT"},
+			{"raw", "TEXT"},
+		},
+		primary: []string{"chat_id", "role", "id"},
+	}
+)
+
+// record is an exported entry. JSON format writes obj into the array of field, and others write row into table.
+// Entries without obj are only written by flat formats, and vice versa.
+type record struct {
+	field string
+	obj   any
+	table *table
+	row   []any
+}
+
+// tempExt is the extension of output being written
+const tempExt = ".tmp"
+
+// recordWriter writes records of the chat in the format
+type recordWriter interface {
+	// begin starts the field of JSON output, so that the field is written even if there is no record
+	begin(field string)
+	write(r record) error
+	Close() error
+}
+
+// newRecordWriter creates writer of the format at path. Flat formats write the first table into path,
+// and others into '<path without ext>.<table><ext>'. If appending, rows are added to the existing output.
+func newRecordWriter(format ExportFormat, path string, chat int64, appending bool, tables ...*table) (recordWriter, error) {
+	switch format {
+	case ExportFormatJson:
+		return newJSONWriter(path, chat)
+	case ExportFormatJsonl, ExportFormatCsv:
+		return newFlatWriter(format, path, appending, tables)
+	case ExportFormatSqlite:
+		return newSQLiteWriter(path, tables)
+	default:
+		return nil, errors.Errorf("unknown format: %s", format)
+	}
+}
+
+// outputWriter writes JSON output into a temp file and replaces the output only when it's committed,
+// so that the existing output, which is read back by incremental export, is kept if export fails.
+// Other formats are written in place.
+type outputWriter struct {
+	recordWriter
+	path, tmp string
+	closed    bool
+}
+
+func newOutputWriter(format ExportFormat, path string, chat int64, appending bool, tables ...*table) (*outputWriter, error) {
+	tmp := path
+	if format == ExportFormatJson {
+		tmp = path + tempExt
+	}
+
+	w, err := newRecordWriter(format, tmp, chat, appending, tables...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &outputWriter{recordWriter: w, path: path, tmp: tmp}, nil
+}
+
+// commit closes the writer and replaces the output with the temp file
+func (w *outputWriter) commit() error {
+	w.closed = true
+	if err := w.recordWriter.Close(); err != nil {
+		w.remove()
+		return err
+	}
+
+	if w.tmp != w.path {
+		if err := os.Rename(w.tmp, w.path); err != nil {
+			w.remove()
+			return errors.Wrap(err, "replace output")
+		}
+	}
+	return nil
+}
+
+// Close discards the temp file if it's not committed
+func (w *outputWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.recordWriter.Close()
+	w.remove()
+	return err
+}
+
+func (w *outputWriter) remove() {
+	if w.tmp != w.path {
+		_ = os.Remove(w.tmp)
+	}
+}
+
+// jsonWriter streams '{"id": chat, "<field>": [...], ...}', and records of one field should be written continuously
+type jsonWriter struct {
+	f     *os.File
+	enc   *jx.Encoder
+	field string
+}
+
+func newJSONWriter(path string, chat int64) (*jsonWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := jx.NewStreamingEncoder(f, 512)
+	enc.ObjStart()
+	enc.Field("id", func(e *jx.Encoder) { e.Int64(chat) })
+
+	return &jsonWriter{f: f, enc: enc}, nil
+}
+
+func (w *jsonWriter) write(r record) error {
+	if r.obj == nil {
+		return nil
+	}
+
+	w.begin(r.field)
+
+	b, err := json.Marshal(r.obj)
+	if err != nil {
+		return errors.Wrap(err, "marshal record")
+	}
+	w.enc.Raw(b)
+	return nil
+}
+
+func (w *jsonWriter) begin(field string) {
+	if w.field == field {
+		return
+	}
+	if w.field != "" {
+		w.enc.ArrEnd()
+	}
+	w.field = field
+	w.enc.FieldStart(field)
+	w.enc.ArrStart()
+}
+
+func (w *jsonWriter) Close() (rerr error) {
+	defer multierr.AppendInvoke(&rerr, multierr.Close(w.f))
+
+	if w.field != "" {
+		w.enc.ArrEnd()
+	}
+	w.enc.ObjEnd()
+	return w.enc.Close()
+}
+
+// flatWriter writes CSV or JSONL files, one file per table
+type flatWriter struct {
+	format ExportFormat
+	files  map[*table]*os.File
+	csv    map[*table]*csv.Writer
+}
+
+func newFlatWriter(format ExportFormat, path string, appending bool, tables []*table) (_ *flatWriter, rerr error) {
+	w := &flatWriter{
+		format: format,
+		files:  make(map[*table]*os.File, len(tables)),
+		csv:    make(map[*table]*csv.Writer, len(tables)),
+	}
+	defer func() {
+		if rerr != nil {
+			_ = w.Close()
+		}
+	}()
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	for i, t := range tables {
+		p := path
+		if i > 0 {
+			ext := filepath.Ext(path)
+			p = strings.TrimSuffix(path, ext) + "." + t.name + ext
+		}
+
+		f, err := os.OpenFile(p, flag, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w.files[t] = f
+
+		if format != ExportFormatCsv {
+			continue
+		}
+
+		cw := csv.NewWriter(f)
+		w.csv[t] = cw
+
+		// header is written only into new files
+		if stat, err := f.Stat(); err != nil || stat.Size() > 0 {
+			continue
+		}
+		header := make([]string, 0, len(t.columns))
+		for _, c := range t.columns {
+			header = append(header, c.name)
+		}
+		if err = cw.Write(header); err != nil {
+			return nil, errors.Wrap(err, "write header")
+		}
+	}
+
+	return w, nil
+}
+
+func (w *flatWriter) begin(string) {}
+
+func (w *flatWriter) write(r record) error {
+	if r.table == nil {
+		return nil
+	}
+	f, ok := w.files[r.table]
+	if !ok {
+		return errors.Errorf("unknown table: %s", r.table.name)
+	}
+
+	if w.format == ExportFormatCsv {
+		row := make([]string, 0, len(r.row))
+		for _, v := range r.row {
+			row = append(row, csvValue(v))
+		}
+		return w.csv[r.table].Write(row)
+	}
+
+	// keep the order of columns
+	enc := jx.GetEncoder()
+	defer jx.PutEncoder(enc)
+
+	enc.ObjStart()
+	for i, c := range r.table.columns {
+		b, err := json.Marshal(r.row[i])
+		if err != nil {
+			return errors.Wrapf(err, "marshal %s", c.name)
+		}
+		enc.FieldStart(c.name)
+		enc.Raw(b)
+	}
+	enc.ObjEnd()
+
+	_, err := f.Write(append(enc.Bytes(), '\n'))
+	return err
+}
+
+func (w *flatWriter) Close() (rerr error) {
+	for t, f := range w.files {
+		if cw, ok := w.csv[t]; ok {
+			cw.Flush()
+			multierr.AppendInto(&rerr, cw.Error())
+		}
+		multierr.AppendInto(&rerr, f.Close())
+	}
+	return rerr
+}
+
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.RawMessage:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sqliteWriter inserts rows into tables of SQLite database in one transaction, and existing rows with the same
+// primary key are replaced, so the same database can be exported into repeatedly.
+type sqliteWriter struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	stmts map[*table]*sql.Stmt
+}
+
+func newSQLiteWriter(path string, tables []*table) (_ *sqliteWriter, rerr error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open database")
+	}
+	w := &sqliteWriter{db: db, stmts: make(map[*table]*sql.Stmt, len(tables))}
+	defer func() {
+		if rerr != nil {
+			multierr.AppendInto(&rerr, w.db.Close())
+		}
+	}()
+
+	for _, t := range tables {
+		columns := make([]string, 0, len(t.columns))
+		for _, c := range t.columns {
+			columns = append(columns, fmt.Sprintf("%q %s", c.name, c.typ))
+		}
+		if _, err = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %q (%s, PRIMARY KEY (%s))",
+			t.name, strings.Join(columns, ", "), strings.Join(t.primary, ", "))); err != nil {
+			return nil, errors.Wrapf(err, "create table %s", t.name)
+		}
+	}
+
+	if w.tx, err = db.Begin(); err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+
+	for _, t := range tables {
+		names := make([]string, 0, len(t.columns))
+		for _, c := range t.columns {
+			names = append(names, strconv.Quote(c.name))
+		}
+		stmt, err := w.tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %q (%s) VALUES (%s)",
+			t.name, strings.Join(names, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "prepare insert %s", t.name)
+		}
+		w.stmts[t] = stmt
+	}
+
+	return w, nil
+}
+
+func (w *sqliteWriter) begin(string) {}
+
+func (w *sqliteWriter) write(r record) error {
+	if r.table == nil {
+		return nil
+	}
+	stmt, ok := w.stmts[r.table]
+	if !ok {
+		return errors.Errorf("unknown table: %s", r.table.name)
+	}
+
+	row := make([]any, 0, len(r.row))
+	for _, v := range r.row {
+		if raw, ok := v.(json.RawMessage); ok {
+			v = nil
+			if len(raw) > 0 {
+				v = string(raw)
+			}
+		}
+		row = append(row, v)
+	}
+
+	_, err := stmt.Exec(row...)
+	return err
+}
+
+func (w *sqliteWriter) Close() (rerr error) {
+	defer multierr.AppendInvoke(&rerr, multierr.Close(w.db))
+
+	for _, stmt := range w.stmts {
+		multierr.AppendInto(&rerr, stmt.Close())
+	}
+	// rows written before error are kept
+	return multierr.Append(rerr, w.tx.Commit())
+}
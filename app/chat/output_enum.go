@@ -0,0 +1,113 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version: 0.5.8
+// Revision: 3d844c8ecc59661ed7aa17bfd65727bc06a60ad8
+// Build Date: 2023-09-18T14:55:21Z
+// Built By: goreleaser
+
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ExportFormatJson is a ExportFormat of type Json.
+	ExportFormatJson ExportFormat = iota
+	// ExportFormatJsonl is a ExportFormat of type Jsonl.
+	ExportFormatJsonl
+	// ExportFormatCsv is a ExportFormat of type Csv.
+	ExportFormatCsv
+	// ExportFormatSqlite is a ExportFormat of type Sqlite.
+	ExportFormatSqlite
+)
+
+var ErrInvalidExportFormat = fmt.Errorf("not a valid ExportFormat, try [%s]", strings.Join(_ExportFormatNames, ", "))
+
+const _ExportFormatName = "jsonjsonlcsvsqlite"
+
+var _ExportFormatNames = []string{
+	_ExportFormatName[0:4],
+	_ExportFormatName[4:9],
+	_ExportFormatName[9:12],
+	_ExportFormatName[12:18],
+}
+
+// ExportFormatNames returns a list of possible string values of ExportFormat.
+func ExportFormatNames() []string {
+	tmp := make([]string, len(_ExportFormatNames))
+	copy(tmp, _ExportFormatNames)
+	return tmp
+}
+
+// ExportFormatValues returns a list of the values for ExportFormat
+func ExportFormatValues() []ExportFormat {
+	return []ExportFormat{
+		ExportFormatJson,
+		ExportFormatJsonl,
+		ExportFormatCsv,
+		ExportFormatSqlite,
+	}
+}
+
+var _ExportFormatMap = map[ExportFormat]string{
+	ExportFormatJson:   _ExportFormatName[0:4],
+	ExportFormatJsonl:  _ExportFormatName[4:9],
+	ExportFormatCsv:    _ExportFormatName[9:12],
+	ExportFormatSqlite: _ExportFormatName[12:18],
+}
+
+// String implements the Stringer interface.
+func (x ExportFormat) String() string {
+	if str, ok := _ExportFormatMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("ExportFormat(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x ExportFormat) IsValid() bool {
+	_, ok := _ExportFormatMap[x]
+	return ok
+}
+
+var _ExportFormatValue = map[string]ExportFormat{
+	_ExportFormatName[0:4]:                    ExportFormatJson,
+	strings.ToLower(_ExportFormatName[0:4]):   ExportFormatJson,
+	_ExportFormatName[4:9]:                    ExportFormatJsonl,
+	strings.ToLower(_ExportFormatName[4:9]):   ExportFormatJsonl,
+	_ExportFormatName[9:12]:                   ExportFormatCsv,
+	strings.ToLower(_ExportFormatName[9:12]):  ExportFormatCsv,
+	_ExportFormatName[12:18]:                  ExportFormatSqlite,
+	strings.ToLower(_ExportFormatName[12:18]): ExportFormatSqlite,
+}
+
+// ParseExportFormat attempts to convert a string to a ExportFormat.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	if x, ok := _ExportFormatValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _ExportFormatValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return ExportFormat(0), fmt.Errorf("%s is %w", name, ErrInvalidExportFormat)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *ExportFormat) Set(val string) error {
+	v, err := ParseExportFormat(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *ExportFormat) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *ExportFormat) Type() string {
+	return "ExportFormat"
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRecords(t *testing.T, format ExportFormat, path string, appending bool, records ...record) {
+	w, err := newRecordWriter(format, path, 1, appending, messagesTable, mediaTable)
+	require.NoError(t, err)
+
+	w.begin("messages")
+	for _, r := range records {
+		require.NoError(t, w.write(r))
+	}
+	require.NoError(t, w.Close())
+}
+
+var testRecords = []record{
+	{
+		field: "messages",
+		obj:   &Message{ID: 2, Type: "message", File: "a.jpg"},
+		table: messagesTable,
+		row:   []any{int64(1), 2, "message", "a.jpg", 0, "", json.RawMessage(nil)},
+	},
+	{
+		table: mediaTable,
+		row:   []any{int64(1), 2, "a.jpg", int64(10), 2, int64(0)},
+	},
+	{
+		field: "messages",
+		obj:   &Message{ID: 1, Type: "message"},
+		table: messagesTable,
+		row:   []any{int64(1), 1, "message", "", 0, "hello, \"world\"", json.RawMessage(`{"id":1}`)},
+	},
+}
+
+func TestJSONWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.json")
+
+	writeRecords(t, ExportFormatJson, path, false)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"messages":[]}`, string(b))
+
+	writeRecords(t, ExportFormatJson, path, false, testRecords...)
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"messages":[{"id":2,"type":"message","file":"a.jpg"},{"id":1,"type":"message","file":""}]}`, string(b))
+}
+
+func TestFlatWriter(t *testing.T) {
+	dir := t.TempDir()
+
+	csvPath := filepath.Join(dir, "export.csv")
+	writeRecords(t, ExportFormatCsv, csvPath, false, testRecords[0])
+	writeRecords(t, ExportFormatCsv, csvPath, true, testRecords[1:]...) // header is not written again
+
+	b, err := os.ReadFile(csvPath)
+	require.NoError(t, err)
+	assert.Equal(t, "chat_id,id,type,file,date,text,raw\n"+
+		"1,2,message,a.jpg,0,,\n"+
+		"1,1,message,,0,\"hello, \"\"world\"\"\",\"{\"\"id\"\":1}\"\n", string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "export.media.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "chat_id,message_id,name,size,dc,date\n1,2,a.jpg,10,2,0\n", string(b))
+
+	jsonlPath := filepath.Join(dir, "export.jsonl")
+	writeRecords(t, ExportFormatJsonl, jsonlPath, false, testRecords...)
+
+	b, err = os.ReadFile(jsonlPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{"chat_id":1,"id":2,"type":"message","file":"a.jpg","date":0,"text":"","raw":null}`+"\n"+
+		`{"chat_id":1,"id":1,"type":"message","file":"","date":0,"text":"hello, \"world\"","raw":{"id":1}}`+"\n", string(b))
+}
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.db")
+
+	writeRecords(t, ExportFormatSqlite, path, false, testRecords...)
+	writeRecords(t, ExportFormatSqlite, path, false, testRecords[0]) // replaced
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	var (
+		text string
+		raw  sql.NullString
+	)
+	require.NoError(t, db.QueryRow(`SELECT text, raw FROM messages WHERE id = 1`).Scan(&text, &raw))
+	assert.Equal(t, `hello, "world"`, text)
+	assert.Equal(t, `{"id":1}`, raw.String)
+
+	require.NoError(t, db.QueryRow(`SELECT raw FROM messages WHERE id = 2`).Scan(&raw))
+	assert.False(t, raw.Valid)
+
+	var name string
+	require.NoError(t, db.QueryRow(`SELECT m.file FROM messages m JOIN media d ON d.message_id = m.id WHERE d.size = 10`).Scan(&name))
+	assert.Equal(t, "a.jpg", name)
+}
@@ -0,0 +1,253 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/expr-lang/expr"
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+	"go.uber.org/multierr"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/texpr"
+)
+
+//go:generate go-enum --names --values --flag --nocase
+
+// MediaType
+// ENUM(all, video, photo, document, audio, voice, gif)
+type MediaType int
+
+// Filter returns the server-side search filter of media type
+func (x MediaType) Filter() tg.MessagesFilterClass {
+	switch x {
+	case MediaTypeVideo:
+		return &tg.InputMessagesFilterVideo{}
+	case MediaTypePhoto:
+		return &tg.InputMessagesFilterPhotos{}
+	case MediaTypeDocument:
+		return &tg.InputMessagesFilterDocument{}
+	case MediaTypeAudio:
+		return &tg.InputMessagesFilterMusic{}
+	case MediaTypeVoice:
+		return &tg.InputMessagesFilterVoice{}
+	case MediaTypeGif:
+		return &tg.InputMessagesFilterGif{}
+	default:
+		return &tg.InputMessagesFilterEmpty{}
+	}
+}
+
+// searching reports whether messages are narrowed by server-side search instead of iterating the full history
+func (opts ExportOptions) searching() bool {
+	return opts.Search != "" || opts.MediaType != MediaTypeAll || opts.FromUser != ""
+}
+
+// newSearchQuery builds messages.search query of the chat. Results are from the newest one like history.
+func newSearchQuery(ctx context.Context, c *telegram.Client, manager *peers.Manager, peer peers.Peer, opts ExportOptions) (messages.Query, error) {
+	q := query.NewQuery(c.API()).Messages().Search(peer.InputPeer()).
+		Q(opts.Search).
+		Filter(opts.MediaType.Filter())
+
+	if opts.FromUser != "" {
+		from, err := tutil.GetInputPeer(ctx, manager, opts.FromUser)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve from user %q", opts.FromUser)
+		}
+		q = q.FromID(from.InputPeer())
+	}
+
+	if opts.Thread != 0 {
+		// replies of channel post are in the discussion group, which can't be searched by post id
+		if ch, ok := peer.(peers.Channel); ok && ch.IsBroadcast() {
+			return nil, errors.New("search is not available in channel post replies")
+		}
+		q = q.TopMsgID(opts.Thread)
+	}
+
+	// messages.search ignores offset date, so time range is narrowed by min and max date
+	if opts.Type == ExportTypeTime {
+		if opts.Input[0] > 0 {
+			q = q.MinDate(opts.Input[0] - 1)
+		}
+		if opts.Input[1] < math.MaxInt32 {
+			q = q.MaxDate(opts.Input[1] + 1)
+		}
+	}
+
+	return q, nil
+}
+
+// SearchScope
+// ENUM(all, private, group, channel)
+type SearchScope int
+
+type SearchOptions struct {
+	Query       string
+	MediaType   MediaType
+	Scope       SearchScope
+	Limit       int // max number of messages, 0 means unlimited
+	Filter      string
+	Output      string // directory, one file per dialog
+	Format      ExportFormat
+	All         bool
+	WithContent bool
+	Raw         bool
+}
+
+// searchResult is the exported file of one dialog. Messages are buffered and written after searching,
+// so that only one file is open at a time however many dialogs are matched.
+type searchResult struct {
+	id       int64
+	name     string
+	path     string
+	messages []*tg.Message
+	media    []*tmedia.Media
+}
+
+func (r *searchResult) export(opts SearchOptions) (rerr error) {
+	w, err := newRecordWriter(opts.Format, r.path, r.id, false, messagesTable, mediaTable)
+	if err != nil {
+		return err
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(w))
+
+	w.begin("messages")
+	for i, m := range r.messages {
+		if err = writeMessage(w, r.id, m, r.media[i], opts.WithContent, opts.Raw); err != nil {
+			return fmt.Errorf("failed to write message: %w", err)
+		}
+	}
+	return nil
+}
+
+// Search searches messages across all dialogs by messages.searchGlobal, and exports results of each dialog
+// into '<output>/<chat id><ext>', which can be used by 'tdl dl -f' and 'tdl forward --from' directly.
+func Search(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts SearchOptions) error {
+	// only output available fields
+	if opts.Filter == "-" {
+		fg := texpr.NewFieldsGetter(nil)
+
+		fields, err := fg.Walk(&texpr.EnvMessage{})
+		if err != nil {
+			return fmt.Errorf("failed to walk fields: %w", err)
+		}
+
+		fmt.Print(fg.Sprint(fields, true))
+		return nil
+	}
+
+	if opts.Query == "" && opts.MediaType == MediaTypeAll {
+		return errors.New("query or media type is required")
+	}
+
+	filter, err := expr.Compile(opts.Filter, expr.AsBool())
+	if err != nil {
+		return fmt.Errorf("failed to compile filter: %w", err)
+	}
+
+	if err = os.MkdirAll(opts.Output, 0o755); err != nil {
+		return errors.Wrap(err, "create output dir")
+	}
+
+	q := query.NewQuery(c.API()).Messages().SearchGlobal().
+		Q(opts.Query).
+		Filter(opts.MediaType.Filter())
+	switch opts.Scope {
+	case SearchScopePrivate:
+		q = q.UsersOnly(true)
+	case SearchScopeGroup:
+		q = q.GroupsOnly(true)
+	case SearchScopeChannel:
+		q = q.BroadcastsOnly(true)
+	}
+	iter := messages.NewIterator(q, 100)
+
+	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(c.API())
+
+	color.Cyan("Occasional suspensions are due to Telegram rate limitations, please wait a moment.")
+	color.Blue("Query: %q | Media Type: %s | Scope: %s", opts.Query, opts.MediaType, opts.Scope)
+
+	pw, tracker := newExportProgress("search")
+	go pw.Render()
+
+	results := make(map[int64]*searchResult)
+
+	count := 0
+	for iter.Next(ctx) {
+		if opts.Limit > 0 && count >= opts.Limit {
+			break
+		}
+
+		elem := iter.Value()
+		m, ok := elem.Msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+		media, ok := tmedia.GetMedia(m)
+		if !ok && !opts.All {
+			continue
+		}
+
+		b, err := texpr.Run(filter, texpr.ConvertEnvMessage(m))
+		if err != nil {
+			return fmt.Errorf("failed to run filter: %w", err)
+		}
+		if !b.(bool) { // filtered
+			continue
+		}
+
+		id := tutil.GetInputPeerID(elem.Peer)
+		r, ok := results[id]
+		if !ok {
+			// store access hash, so that exported files can be resolved by id later
+			if err = applyPeers(ctx, manager, elem.Entities, id); err != nil {
+				return errors.Wrap(err, "apply peers")
+			}
+
+			name, _ := peerName(elem.Entities, m.PeerID)
+			r = &searchResult{id: id, name: name, path: filepath.Join(opts.Output, strconv.FormatInt(id, 10)+opts.Format.Ext())}
+			results[id] = r
+		}
+
+		r.messages = append(r.messages, m)
+		r.media = append(r.media, media)
+		count++
+		tracker.SetValue(int64(count))
+	}
+	if err = iter.Err(); err != nil {
+		return err
+	}
+
+	tracker.MarkAsDone()
+	prog.Wait(ctx, pw)
+
+	sorted := make([]*searchResult, 0, len(results))
+	for _, r := range results {
+		if err = r.export(opts); err != nil {
+			return errors.Wrapf(err, "export %q", r.path)
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i].messages) > len(sorted[j].messages) })
+	for _, r := range sorted {
+		fmt.Printf("%s %s %s\n", trunc(strconv.Itoa(len(r.messages)), 6), trunc(r.name, 30), r.path)
+	}
+
+	color.Green("Found %d messages in %d chats, exported to '%s'", count, len(results), opts.Output)
+	return nil
+}
@@ -0,0 +1,235 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version: 0.5.8
+// Revision: 3d844c8ecc59661ed7aa17bfd65727bc06a60ad8
+// Build Date: 2023-09-18T14:55:21Z
+// Built By: goreleaser
+
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MediaTypeAll is a MediaType of type All.
+	MediaTypeAll MediaType = iota
+	// MediaTypeVideo is a MediaType of type Video.
+	MediaTypeVideo
+	// MediaTypePhoto is a MediaType of type Photo.
+	MediaTypePhoto
+	// MediaTypeDocument is a MediaType of type Document.
+	MediaTypeDocument
+	// MediaTypeAudio is a MediaType of type Audio.
+	MediaTypeAudio
+	// MediaTypeVoice is a MediaType of type Voice.
+	MediaTypeVoice
+	// MediaTypeGif is a MediaType of type Gif.
+	MediaTypeGif
+)
+
+var ErrInvalidMediaType = fmt.Errorf("not a valid MediaType, try [%s]", strings.Join(_MediaTypeNames, ", "))
+
+const _MediaTypeName = "allvideophotodocumentaudiovoicegif"
+
+var _MediaTypeNames = []string{
+	_MediaTypeName[0:3],
+	_MediaTypeName[3:8],
+	_MediaTypeName[8:13],
+	_MediaTypeName[13:21],
+	_MediaTypeName[21:26],
+	_MediaTypeName[26:31],
+	_MediaTypeName[31:34],
+}
+
+// MediaTypeNames returns a list of possible string values of MediaType.
+func MediaTypeNames() []string {
+	tmp := make([]string, len(_MediaTypeNames))
+	copy(tmp, _MediaTypeNames)
+	return tmp
+}
+
+// MediaTypeValues returns a list of the values for MediaType
+func MediaTypeValues() []MediaType {
+	return []MediaType{
+		MediaTypeAll,
+		MediaTypeVideo,
+		MediaTypePhoto,
+		MediaTypeDocument,
+		MediaTypeAudio,
+		MediaTypeVoice,
+		MediaTypeGif,
+	}
+}
+
+var _MediaTypeMap = map[MediaType]string{
+	MediaTypeAll:      _MediaTypeName[0:3],
+	MediaTypeVideo:    _MediaTypeName[3:8],
+	MediaTypePhoto:    _MediaTypeName[8:13],
+	MediaTypeDocument: _MediaTypeName[13:21],
+	MediaTypeAudio:    _MediaTypeName[21:26],
+	MediaTypeVoice:    _MediaTypeName[26:31],
+	MediaTypeGif:      _MediaTypeName[31:34],
+}
+
+// String implements the Stringer interface.
+func (x MediaType) String() string {
+	if str, ok := _MediaTypeMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("MediaType(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x MediaType) IsValid() bool {
+	_, ok := _MediaTypeMap[x]
+	return ok
+}
+
+var _MediaTypeValue = map[string]MediaType{
+	_MediaTypeName[0:3]:                    MediaTypeAll,
+	strings.ToLower(_MediaTypeName[0:3]):   MediaTypeAll,
+	_MediaTypeName[3:8]:                    MediaTypeVideo,
+	strings.ToLower(_MediaTypeName[3:8]):   MediaTypeVideo,
+	_MediaTypeName[8:13]:                   MediaTypePhoto,
+	strings.ToLower(_MediaTypeName[8:13]):  MediaTypePhoto,
+	_MediaTypeName[13:21]:                  MediaTypeDocument,
+	strings.ToLower(_MediaTypeName[13:21]): MediaTypeDocument,
+	_MediaTypeName[21:26]:                  MediaTypeAudio,
+	strings.ToLower(_MediaTypeName[21:26]): MediaTypeAudio,
+	_MediaTypeName[26:31]:                  MediaTypeVoice,
+	strings.ToLower(_MediaTypeName[26:31]): MediaTypeVoice,
+	_MediaTypeName[31:34]:                  MediaTypeGif,
+	strings.ToLower(_MediaTypeName[31:34]): MediaTypeGif,
+}
+
+// ParseMediaType attempts to convert a string to a MediaType.
+func ParseMediaType(name string) (MediaType, error) {
+	if x, ok := _MediaTypeValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _MediaTypeValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return MediaType(0), fmt.Errorf("%s is %w", name, ErrInvalidMediaType)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *MediaType) Set(val string) error {
+	v, err := ParseMediaType(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *MediaType) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *MediaType) Type() string {
+	return "MediaType"
+}
+
+const (
+	// SearchScopeAll is a SearchScope of type All.
+	SearchScopeAll SearchScope = iota
+	// SearchScopePrivate is a SearchScope of type Private.
+	SearchScopePrivate
+	// SearchScopeGroup is a SearchScope of type Group.
+	SearchScopeGroup
+	// SearchScopeChannel is a SearchScope of type Channel.
+	SearchScopeChannel
+)
+
+var ErrInvalidSearchScope = fmt.Errorf("not a valid SearchScope, try [%s]", strings.Join(_SearchScopeNames, ", "))
+
+const _SearchScopeName = "allprivategroupchannel"
+
+var _SearchScopeNames = []string{
+	_SearchScopeName[0:3],
+	_SearchScopeName[3:10],
+	_SearchScopeName[10:15],
+	_SearchScopeName[15:22],
+}
+
+// SearchScopeNames returns a list of possible string values of SearchScope.
+func SearchScopeNames() []string {
+	tmp := make([]string, len(_SearchScopeNames))
+	copy(tmp, _SearchScopeNames)
+	return tmp
+}
+
+// SearchScopeValues returns a list of the values for SearchScope
+func SearchScopeValues() []SearchScope {
+	return []SearchScope{
+		SearchScopeAll,
+		SearchScopePrivate,
+		SearchScopeGroup,
+		SearchScopeChannel,
+	}
+}
+
+var _SearchScopeMap = map[SearchScope]string{
+	SearchScopeAll:     _SearchScopeName[0:3],
+	SearchScopePrivate: _SearchScopeName[3:10],
+	SearchScopeGroup:   _SearchScopeName[10:15],
+	SearchScopeChannel: _SearchScopeName[15:22],
+}
+
+// String implements the Stringer interface.
+func (x SearchScope) String() string {
+	if str, ok := _SearchScopeMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("SearchScope(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x SearchScope) IsValid() bool {
+	_, ok := _SearchScopeMap[x]
+	return ok
+}
+
+var _SearchScopeValue = map[string]SearchScope{
+	_SearchScopeName[0:3]:                    SearchScopeAll,
+	strings.ToLower(_SearchScopeName[0:3]):   SearchScopeAll,
+	_SearchScopeName[3:10]:                   SearchScopePrivate,
+	strings.ToLower(_SearchScopeName[3:10]):  SearchScopePrivate,
+	_SearchScopeName[10:15]:                  SearchScopeGroup,
+	strings.ToLower(_SearchScopeName[10:15]): SearchScopeGroup,
+	_SearchScopeName[15:22]:                  SearchScopeChannel,
+	strings.ToLower(_SearchScopeName[15:22]): SearchScopeChannel,
+}
+
+// ParseSearchScope attempts to convert a string to a SearchScope.
+func ParseSearchScope(name string) (SearchScope, error) {
+	if x, ok := _SearchScopeValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _SearchScopeValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return SearchScope(0), fmt.Errorf("%s is %w", name, ErrInvalidSearchScope)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *SearchScope) Set(val string) error {
+	v, err := ParseSearchScope(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *SearchScope) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *SearchScope) Type() string {
+	return "SearchScope"
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/core/tmedia"
+)
+
+func TestMediaTypeFilter(t *testing.T) {
+	tests := map[MediaType]tg.MessagesFilterClass{
+		MediaTypeAll:      &tg.InputMessagesFilterEmpty{},
+		MediaTypeVideo:    &tg.InputMessagesFilterVideo{},
+		MediaTypePhoto:    &tg.InputMessagesFilterPhotos{},
+		MediaTypeDocument: &tg.InputMessagesFilterDocument{},
+		MediaTypeAudio:    &tg.InputMessagesFilterMusic{},
+		MediaTypeVoice:    &tg.InputMessagesFilterVoice{},
+		MediaTypeGif:      &tg.InputMessagesFilterGif{},
+	}
+
+	for typ, filter := range tests {
+		assert.Equal(t, filter, typ.Filter(), typ.String())
+	}
+}
+
+func TestExportOptionsSearching(t *testing.T) {
+	assert.False(t, ExportOptions{}.searching())
+	assert.True(t, ExportOptions{Search: "keyword"}.searching())
+	assert.True(t, ExportOptions{MediaType: MediaTypeVideo}.searching())
+	assert.True(t, ExportOptions{FromUser: "iyear"}.searching())
+}
+
+func TestSearchResultExport(t *testing.T) {
+	r := &searchResult{
+		id:       1,
+		path:     filepath.Join(t.TempDir(), "1.json"),
+		messages: []*tg.Message{{ID: 3}, {ID: 2}},
+		media:    []*tmedia.Media{{Name: "a.jpg"}, nil},
+	}
+	require.NoError(t, r.export(SearchOptions{Format: ExportFormatJson}))
+
+	b, err := os.ReadFile(r.path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"messages":[{"id":3,"type":"message","file":"a.jpg"},{"id":2,"type":"message","file":""}]}`, string(b))
+}
@@ -4,12 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/go-faster/errors"
-	"github.com/go-faster/jx"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/telegram/query/channels/participants"
@@ -26,6 +24,7 @@ import (
 type UsersOptions struct {
 	Chat   string
 	Output string
+	Format ExportFormat
 	Raw    bool
 }
 
@@ -56,18 +55,11 @@ func Users(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Us
 	color.Cyan("Occasional suspensions are due to Telegram rate limitations, please wait a moment.")
 	fmt.Println()
 
-	f, err := os.Create(opts.Output)
+	w, err := newRecordWriter(opts.Format, opts.Output, peer.ID(), false, usersTable)
 	if err != nil {
 		return err
 	}
-	defer multierr.AppendInvoke(&rerr, multierr.Close(f))
-
-	enc := jx.NewStreamingEncoder(f, 512)
-	defer multierr.AppendInvoke(&rerr, multierr.Close(enc))
-
-	enc.ObjStart()
-	defer enc.ObjEnd()
-	enc.Field("id", func(e *jx.Encoder) { e.Int64(peer.ID()) })
+	defer multierr.AppendInvoke(&rerr, multierr.Close(w))
 
 	pw := prog.New(progress.FormatNumber)
 	pw.SetUpdateFrequency(200 * time.Millisecond)
@@ -93,7 +85,7 @@ func Users(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Us
 
 	for field, query := range fields {
 		iter := query.Iter()
-		if err = outputUsers(ctx, pw, peer, enc, field, iter, opts.Raw); err != nil {
+		if err = outputUsers(ctx, pw, peer, w, field, iter, opts.Raw); err != nil {
 			// skip if we get CHAT_ADMIN_REQUIRED error, just export other fields
 			if tgerr.Is(err, tg.ErrChatAdminRequired) {
 				continue
@@ -109,7 +101,7 @@ func Users(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Us
 func outputUsers(ctx context.Context,
 	pw progress.Writer,
 	peer peers.Peer,
-	enc *jx.Encoder,
+	w recordWriter,
 	field string,
 	iter *participants.Iterator,
 	raw bool,
@@ -124,9 +116,7 @@ func outputUsers(ctx context.Context,
 		fmt.Sprintf("%s-%d-%s", peer.VisibleName(), peer.ID(), field),
 		int64(total))
 
-	enc.FieldStart(field)
-	enc.ArrStart()
-	defer enc.ArrEnd()
+	w.begin(field)
 
 	for iter.Next(ctx) {
 		el := iter.Value()
@@ -135,17 +125,24 @@ func outputUsers(ctx context.Context,
 			continue
 		}
 
-		var output any = u
-		if !raw {
-			output = convertTelegramUser(u)
+		user := convertTelegramUser(u)
+		r := record{
+			field: field,
+			obj:   user,
+			table: usersTable,
+			row:   []any{peer.ID(), field, user.ID, user.Username, user.Phone, user.FirstName, user.LastName, json.RawMessage(nil)},
 		}
-
-		buf, err := json.Marshal(output)
-		if err != nil {
-			return errors.Wrap(err, "marshal user")
+		if raw {
+			buf, err := json.Marshal(u)
+			if err != nil {
+				return errors.Wrap(err, "marshal user")
+			}
+			r.obj, r.row[len(r.row)-1] = json.RawMessage(buf), json.RawMessage(buf)
 		}
 
-		enc.Raw(buf)
+		if err = w.write(r); err != nil {
+			return errors.Wrap(err, "write user")
+		}
 
 		tracker.Increment(1)
 	}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iyear/tdl/pkg/consts"
+)
+
+const unixPrefix = "unix://"
+
+// DefaultAddr is the Unix socket in data directory
+var DefaultAddr = unixPrefix + filepath.Join(consts.DataDir, "daemon.sock")
+
+// parseAddr returns network and address of addr, which is 'host:port' or 'unix:///path/to/socket'
+func parseAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+	return "tcp", addr
+}
+
+func listen(addr string) (net.Listener, error) {
+	network, address := parseAddr(addr)
+	if network == "unix" {
+		// remove the socket left by last crash
+		_ = os.Remove(address)
+	}
+
+	return net.Listen(network, address)
+}
+
+func newHTTPClient(addr string) *http.Client {
+	network, address := parseAddr(addr)
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	}}
+}
@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-faster/errors"
+	"github.com/gorilla/mux"
+)
+
+type submitRequest struct {
+	Type    JobType         `json:"type"`
+	Options json.RawMessage `json:"options"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError is returned by handlers to respond with status code other than 400
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+// handler returns the HTTP API, which requires bearer token of daemon:
+//
+//	POST   /jobs      submit a job
+//	GET    /jobs      list all jobs
+//	GET    /jobs/{id} get a job
+//	DELETE /jobs/{id} cancel a queued or running job
+func (d *daemon) handler() http.Handler {
+	router := mux.NewRouter()
+
+	router.Handle("/jobs", handler(func(w http.ResponseWriter, r *http.Request) error {
+		var req submitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return errors.Wrap(err, "decode request")
+		}
+		if !req.Type.IsValid() {
+			return errors.Errorf("invalid job type: %q", req.Type)
+		}
+		if len(req.Options) == 0 {
+			req.Options = json.RawMessage("{}")
+		}
+		if err := validate(req.Type, req.Options); err != nil {
+			return err
+		}
+
+		job, err := d.queue.add(r.Context(), req.Type, req.Options)
+		if err != nil {
+			return &httpError{code: http.StatusInternalServerError, err: errors.Wrap(err, "add job")}
+		}
+
+		writeJSON(w, http.StatusCreated, job)
+		return nil
+	})).Methods(http.MethodPost)
+
+	router.Handle("/jobs", handler(func(w http.ResponseWriter, r *http.Request) error {
+		writeJSON(w, http.StatusOK, d.queue.list())
+		return nil
+	})).Methods(http.MethodGet)
+
+	router.Handle("/jobs/{id:[0-9]+}", handler(func(w http.ResponseWriter, r *http.Request) error {
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+		job, ok := d.queue.get(id)
+		if !ok {
+			return &httpError{code: http.StatusNotFound, err: errors.Errorf("job %d not found", id)}
+		}
+
+		writeJSON(w, http.StatusOK, job)
+		return nil
+	})).Methods(http.MethodGet)
+
+	router.Handle("/jobs/{id:[0-9]+}", handler(func(w http.ResponseWriter, r *http.Request) error {
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+		status, ok, err := d.queue.cancel(r.Context(), id)
+		if err != nil {
+			return &httpError{code: http.StatusInternalServerError, err: errors.Wrap(err, "cancel job")}
+		}
+		if !ok {
+			return &httpError{code: http.StatusNotFound, err: errors.Errorf("job %d not found", id)}
+		}
+		if status == JobStatusRunning {
+			// status is updated by worker after the job returns
+			d.cancel(id)
+		}
+
+		job, _ := d.queue.get(id)
+		writeJSON(w, http.StatusOK, job)
+		return nil
+	})).Methods(http.MethodDelete)
+
+	return authMiddleware(d.token, router)
+}
+
+func handler(h func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil {
+			return
+		}
+
+		code := http.StatusBadRequest
+		var he *httpError
+		if errors.As(err, &he) {
+			code = he.code
+		}
+		writeJSON(w, code, errorResponse{Error: err.Error()})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/pkg/consts"
+)
+
+// TokenPath stores the token of daemon, which is read by clients of the same user
+var TokenPath = filepath.Join(consts.DataDir, "daemon.token")
+
+// loadToken returns the configured token, or the token in TokenPath which is generated if not exists.
+// The token is always written to TokenPath, so local clients can read it.
+func loadToken(token string) (string, error) {
+	if token == "" {
+		b, err := os.ReadFile(TokenPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", errors.Wrap(err, "read token")
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	if token == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", errors.Wrap(err, "generate token")
+		}
+		token = hex.EncodeToString(b)
+	}
+
+	if err := os.WriteFile(TokenPath, []byte(token), 0o600); err != nil {
+		return "", errors.Wrap(err, "write token")
+	}
+	return token, nil
+}
+
+// readToken reads the token written by daemon
+func readToken() (string, error) {
+	b, err := os.ReadFile(TokenPath)
+	if err != nil {
+		return "", errors.Wrap(err, "read daemon token")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// checkListen refuses to expose the API to network without a token set by user,
+// because the generated token can only be read locally.
+func checkListen(addr string, configured bool) error {
+	network, address := parseAddr(addr)
+	if network != "tcp" || configured {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "parse address")
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return errors.Errorf("listening on non-loopback address %q requires --token", addr)
+}
+
+// authMiddleware requires bearer token for all requests, and JSON body for requests with body,
+// so browsers can't submit jobs by cross-site simple requests.
+func authMiddleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid token"})
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "content type must be application/json"})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/app/chat"
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/app/forward"
+	"github.com/iyear/tdl/app/up"
+	"github.com/iyear/tdl/core/util/fsutil"
+)
+
+// Submit submits a job with options of command to the daemon listening on addr, with token in TokenPath.
+// Relative paths in options are resolved against current directory, as the daemon may run elsewhere.
+func Submit(ctx context.Context, addr string, typ JobType, opts any) (*Job, error) {
+	token, err := readToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "is 'tdl daemon' started?")
+	}
+
+	o, err := json.Marshal(absPaths(opts))
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal options")
+	}
+
+	b, err := json.Marshal(submitRequest{Type: typ, Options: o})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://daemon/jobs", bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := newHTTPClient(addr).Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to daemon, is 'tdl daemon' running?")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		var e errorResponse
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return nil, errors.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil, errors.New(e.Error)
+	}
+
+	job := &Job{}
+	if err = json.NewDecoder(resp.Body).Decode(job); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	return job, nil
+}
+
+func absPaths(opts any) any {
+	switch o := opts.(type) {
+	case dl.Options:
+		o.Dir, o.Report = abs(o.Dir), abs(o.Report)
+		o.Files = absSlice(o.Files)
+		// archive sinks, e.g. tar:FILE
+		if kind, path, ok := strings.Cut(o.Sink, ":"); ok && (kind == "tar" || kind == "zip") && path != "-" {
+			o.Sink = kind + ":" + abs(path)
+		}
+		return o
+	case up.Options:
+		o.Report = abs(o.Report)
+		o.Paths = absSlice(o.Paths)
+		return o
+	case forward.Options:
+		o.Report = abs(o.Report)
+		from := make([]string, 0, len(o.From))
+		for _, f := range o.From {
+			// from can be links or files
+			if fsutil.PathExists(f) {
+				f = abs(f)
+			}
+			from = append(from, f)
+		}
+		o.From = from
+		return o
+	case chat.ExportOptions:
+		o.Output = abs(o.Output)
+		return o
+	default:
+		return opts
+	}
+}
+
+func abs(path string) string {
+	if path == "" {
+		return path
+	}
+
+	if p, err := filepath.Abs(path); err == nil {
+		return p
+	}
+	return path
+}
+
+func absSlice(paths []string) []string {
+	r := make([]string, 0, len(paths))
+	for _, p := range paths {
+		r = append(r, abs(p))
+	}
+	return r
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/iyear/tdl/app/chat"
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/app/forward"
+	"github.com/iyear/tdl/app/internal/tctx"
+	"github.com/iyear/tdl/app/up"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+)
+
+type Options struct {
+	Listen string
+	Token  string // bearer token of HTTP API, generated in TokenPath if empty
+}
+
+type daemon struct {
+	client *telegram.Client
+	kvd    storage.Storage
+	queue  *queue
+	token  string
+
+	mu      *sync.Mutex
+	running map[int]context.CancelFunc // cancel functions of running jobs
+}
+
+// Run holds one client and DC pool, and executes jobs submitted by HTTP API one by one until ctx is done.
+func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
+	if err := checkListen(opts.Listen, opts.Token != ""); err != nil {
+		return err
+	}
+
+	token, err := loadToken(opts.Token)
+	if err != nil {
+		return errors.Wrap(err, "load token")
+	}
+
+	q, err := loadQueue(ctx, kvd)
+	if err != nil {
+		return errors.Wrap(err, "load queue")
+	}
+
+	pool := tctx.NewPool(ctx, c)
+	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+
+	ctx = tctx.WithSharedPool(ctx, pool)
+
+	l, err := listen(opts.Listen)
+	if err != nil {
+		return errors.Wrap(err, "listen")
+	}
+
+	d := &daemon{
+		client:  c,
+		kvd:     kvd,
+		queue:   q,
+		token:   token,
+		mu:      &sync.Mutex{},
+		running: make(map[int]context.CancelFunc),
+	}
+
+	srv := &http.Server{
+		Handler:           d.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	color.Green("Daemon is listening on %s", opts.Listen)
+
+	wg, wctx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		<-wctx.Done()
+		return srv.Close()
+	})
+	wg.Go(func() error {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			return errors.Wrap(err, "serve")
+		}
+		return nil
+	})
+	wg.Go(func() error {
+		return d.work(wctx)
+	})
+
+	return wg.Wait()
+}
+
+func (d *daemon) work(ctx context.Context) error {
+	for {
+		job, ok, err := d.queue.next(ctx)
+		if err != nil {
+			return errors.Wrap(err, "next job")
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-d.queue.notify:
+				continue
+			}
+		}
+
+		log := logctx.From(ctx).With(zap.Int("job", job.ID), zap.String("type", job.Type.String()))
+		log.Info("Start job")
+		color.Blue("Start job %d: %s", job.ID, job.Type)
+
+		jctx, cancel := context.WithCancel(logctx.With(ctx, log))
+		d.setRunning(job.ID, cancel)
+		err = d.exec(jctx, job)
+		d.setRunning(job.ID, nil)
+		cancel()
+
+		// daemon is stopped, and the job will be executed again on next start
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Info("Finish job", zap.Error(err))
+		if err != nil {
+			color.Red("Job %d failed: %v", job.ID, err)
+		} else {
+			color.Green("Job %d done", job.ID)
+		}
+
+		if err = d.queue.finish(ctx, job.ID, err); err != nil {
+			return errors.Wrap(err, "finish job")
+		}
+	}
+}
+
+func (d *daemon) setRunning(id int, cancel context.CancelFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if cancel == nil {
+		delete(d.running, id)
+		return
+	}
+	d.running[id] = cancel
+}
+
+func (d *daemon) cancel(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if cancel, ok := d.running[id]; ok {
+		cancel()
+	}
+}
+
+func (d *daemon) exec(ctx context.Context, job Job) error {
+	switch job.Type {
+	case JobTypeDownload:
+		var opts dl.Options
+		if err := json.Unmarshal(job.Options, &opts); err != nil {
+			return errors.Wrap(err, "unmarshal options")
+		}
+		if err := validateDownload(opts); err != nil {
+			return err
+		}
+		// there is no terminal to ask whether to resume
+		if !opts.Restart {
+			opts.Continue = true
+		}
+		return dl.Run(logctx.Named(ctx, "dl"), d.client, d.kvd, opts)
+	case JobTypeUpload:
+		var opts up.Options
+		if err := json.Unmarshal(job.Options, &opts); err != nil {
+			return errors.Wrap(err, "unmarshal options")
+		}
+		return up.Run(logctx.Named(ctx, "up"), d.client, d.kvd, opts)
+	case JobTypeForward:
+		var opts forward.Options
+		if err := json.Unmarshal(job.Options, &opts); err != nil {
+			return errors.Wrap(err, "unmarshal options")
+		}
+		return forward.Run(logctx.Named(ctx, "forward"), d.client, d.kvd, opts)
+	case JobTypeExport:
+		var opts chat.ExportOptions
+		if err := json.Unmarshal(job.Options, &opts); err != nil {
+			return errors.Wrap(err, "unmarshal options")
+		}
+		return chat.Export(logctx.Named(ctx, "export"), d.client, d.kvd, opts)
+	default:
+		return errors.Errorf("unknown job type: %s", job.Type)
+	}
+}
+
+// validate rejects options of jobs which can't be run by daemon
+func validate(typ JobType, options json.RawMessage) error {
+	if typ != JobTypeDownload {
+		return nil
+	}
+
+	var opts dl.Options
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return errors.Wrap(err, "unmarshal options")
+	}
+	return validateDownload(opts)
+}
+
+func validateDownload(opts dl.Options) error {
+	switch {
+	// files would be written to stdout of daemon instead of client
+	case dl.SinkStdout(opts.Sink):
+		return errors.Errorf("sink %q writes to stdout, which is not supported by daemon", opts.Sink)
+	// fields would be printed to stdout of daemon
+	case opts.Filter == "-":
+		return errors.New("listing filter fields is not supported by daemon")
+	// these never finish and would occupy the only worker of daemon
+	case opts.Serve:
+		return errors.New("serve is not supported by daemon")
+	case len(opts.WebDAV) > 0:
+		return errors.New("webdav is not supported by daemon")
+	case len(opts.Watch) > 0:
+		return errors.New("watch is not supported by daemon")
+	}
+	return nil
+}
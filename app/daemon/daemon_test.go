@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/core/storage"
+)
+
+type memStorage map[string][]byte
+
+func (m memStorage) Get(_ context.Context, key string) ([]byte, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m memStorage) Set(_ context.Context, key string, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+func (m memStorage) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	ctx, kvd := context.Background(), memStorage{}
+
+	q, err := loadQueue(ctx, kvd)
+	require.NoError(t, err)
+
+	a, err := q.add(ctx, JobTypeDownload, json.RawMessage(`{}`))
+	require.NoError(t, err)
+	b, err := q.add(ctx, JobTypeUpload, json.RawMessage(`{}`))
+	require.NoError(t, err)
+	c, err := q.add(ctx, JobTypeExport, json.RawMessage(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, []int{a.ID, b.ID, c.ID})
+
+	job, ok, err := q.next(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, a.ID, job.ID)
+
+	status, ok, err := q.cancel(ctx, b.ID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, JobStatusCanceled, status)
+
+	// running job is queued again after restart
+	q, err = loadQueue(ctx, kvd)
+	require.NoError(t, err)
+
+	job, ok, err = q.next(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, a.ID, job.ID)
+	require.NoError(t, q.finish(ctx, job.ID, context.Canceled))
+
+	job, ok, err = q.next(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, c.ID, job.ID)
+	require.NoError(t, q.finish(ctx, job.ID, assert.AnError))
+
+	_, ok, err = q.next(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	statuses := make([]string, 0)
+	for _, j := range q.list() {
+		statuses = append(statuses, j.Status.String())
+	}
+	assert.Equal(t, "canceled,canceled,failed", strings.Join(statuses, ","))
+
+	job, _ = q.get(c.ID)
+	assert.Equal(t, assert.AnError.Error(), job.Error)
+
+	d, err := q.add(ctx, JobTypeForward, json.RawMessage(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, 4, d.ID)
+}
+
+func TestAPI(t *testing.T) {
+	ctx := context.Background()
+
+	q, err := loadQueue(ctx, memStorage{})
+	require.NoError(t, err)
+
+	TokenPath = filepath.Join(t.TempDir(), "daemon.token")
+	token, err := loadToken("")
+	require.NoError(t, err)
+
+	d := &daemon{queue: q, token: token, mu: &sync.Mutex{}, running: make(map[int]context.CancelFunc)}
+	srv := httptest.NewServer(d.handler())
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	do := func(method, path string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	job, err := Submit(ctx, addr, JobTypeDownload, dl.Options{Dir: "downloads", URLs: []string{"https://t.me/tdl/1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.ID)
+	assert.Equal(t, JobStatusQueued, job.Status)
+
+	var opts dl.Options
+	require.NoError(t, json.Unmarshal(job.Options, &opts))
+	assert.True(t, filepath.IsAbs(opts.Dir))
+	assert.Equal(t, []string{"https://t.me/tdl/1"}, opts.URLs)
+
+	_, err = Submit(ctx, addr, JobType("unknown"), nil)
+	assert.ErrorContains(t, err, "invalid job type")
+
+	_, err = Submit(ctx, addr, JobTypeDownload, dl.Options{Sink: "tar:-", URLs: []string{"https://t.me/tdl/1"}})
+	assert.ErrorContains(t, err, "stdout")
+
+	resp := do(http.MethodDelete, "/jobs/1")
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/jobs/1")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, JobStatusCanceled, job.Status)
+
+	resp = do(http.MethodGet, "/jobs/2")
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// requests without token
+	resp, err = http.Get(srv.URL + "/jobs")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// cross-site simple request
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/jobs", strings.NewReader(`{"type":"upload","options":{"Paths":["/etc/passwd"]}}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestLoadToken(t *testing.T) {
+	TokenPath = filepath.Join(t.TempDir(), "daemon.token")
+
+	token, err := loadToken("")
+	require.NoError(t, err)
+	assert.Len(t, token, 64)
+
+	// generated token is reused
+	again, err := loadToken("")
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+
+	// configured token overrides
+	_, err = loadToken("secret")
+	require.NoError(t, err)
+	got, err := readToken()
+	require.NoError(t, err)
+	assert.Equal(t, "secret", got)
+}
+
+func TestCheckListen(t *testing.T) {
+	tests := []struct {
+		addr       string
+		configured bool
+		wantErr    bool
+	}{
+		{addr: "unix:///tmp/daemon.sock"},
+		{addr: "localhost:8079"},
+		{addr: "127.0.0.1:8079"},
+		{addr: "[::1]:8079"},
+		{addr: ":8079", wantErr: true},
+		{addr: "0.0.0.0:8079", wantErr: true},
+		{addr: "192.168.1.2:8079", wantErr: true},
+		{addr: "0.0.0.0:8079", configured: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := checkListen(tt.addr, tt.configured)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateDownload(t *testing.T) {
+	tests := map[string]dl.Options{
+		"stdout": {Sink: "-"},
+		"fields": {Filter: "-"},
+		"serve":  {Serve: true},
+		"webdav": {WebDAV: []string{"tdl"}},
+		"watch":  {Watch: []string{"tdl"}},
+	}
+	for name, opts := range tests {
+		assert.Error(t, validateDownload(opts), name)
+	}
+
+	assert.NoError(t, validateDownload(dl.Options{Dir: "downloads", URLs: []string{"https://t.me/tdl/1"}, Filter: "true"}))
+}
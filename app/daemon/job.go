@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//go:generate go-enum --names --values --flag --nocase
+
+// JobType
+// ENUM(download, upload, forward, export)
+type JobType string
+
+// JobStatus
+// ENUM(queued, running, done, failed, canceled)
+type JobStatus string
+
+// Job is a command submitted to daemon, options are the same as the options of command
+type Job struct {
+	ID       int             `json:"id"`
+	Type     JobType         `json:"type"`
+	Status   JobStatus       `json:"status"`
+	Options  json.RawMessage `json:"options"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+}
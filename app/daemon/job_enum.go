@@ -0,0 +1,187 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version: 0.5.8
+// Revision: 3d844c8ecc59661ed7aa17bfd65727bc06a60ad8
+// Build Date: 2023-09-18T14:55:21Z
+// Built By: goreleaser
+
+package daemon
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// JobStatusQueued is a JobStatus of type queued.
+	JobStatusQueued JobStatus = "queued"
+	// JobStatusRunning is a JobStatus of type running.
+	JobStatusRunning JobStatus = "running"
+	// JobStatusDone is a JobStatus of type done.
+	JobStatusDone JobStatus = "done"
+	// JobStatusFailed is a JobStatus of type failed.
+	JobStatusFailed JobStatus = "failed"
+	// JobStatusCanceled is a JobStatus of type canceled.
+	JobStatusCanceled JobStatus = "canceled"
+)
+
+var ErrInvalidJobStatus = fmt.Errorf("not a valid JobStatus, try [%s]", strings.Join(_JobStatusNames, ", "))
+
+var _JobStatusNames = []string{
+	string(JobStatusQueued),
+	string(JobStatusRunning),
+	string(JobStatusDone),
+	string(JobStatusFailed),
+	string(JobStatusCanceled),
+}
+
+// JobStatusNames returns a list of possible string values of JobStatus.
+func JobStatusNames() []string {
+	tmp := make([]string, len(_JobStatusNames))
+	copy(tmp, _JobStatusNames)
+	return tmp
+}
+
+// JobStatusValues returns a list of the values for JobStatus
+func JobStatusValues() []JobStatus {
+	return []JobStatus{
+		JobStatusQueued,
+		JobStatusRunning,
+		JobStatusDone,
+		JobStatusFailed,
+		JobStatusCanceled,
+	}
+}
+
+// String implements the Stringer interface.
+func (x JobStatus) String() string {
+	return string(x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x JobStatus) IsValid() bool {
+	_, err := ParseJobStatus(string(x))
+	return err == nil
+}
+
+var _JobStatusValue = map[string]JobStatus{
+	"queued":   JobStatusQueued,
+	"running":  JobStatusRunning,
+	"done":     JobStatusDone,
+	"failed":   JobStatusFailed,
+	"canceled": JobStatusCanceled,
+}
+
+// ParseJobStatus attempts to convert a string to a JobStatus.
+func ParseJobStatus(name string) (JobStatus, error) {
+	if x, ok := _JobStatusValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _JobStatusValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return JobStatus(""), fmt.Errorf("%s is %w", name, ErrInvalidJobStatus)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *JobStatus) Set(val string) error {
+	v, err := ParseJobStatus(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *JobStatus) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *JobStatus) Type() string {
+	return "JobStatus"
+}
+
+const (
+	// JobTypeDownload is a JobType of type download.
+	JobTypeDownload JobType = "download"
+	// JobTypeUpload is a JobType of type upload.
+	JobTypeUpload JobType = "upload"
+	// JobTypeForward is a JobType of type forward.
+	JobTypeForward JobType = "forward"
+	// JobTypeExport is a JobType of type export.
+	JobTypeExport JobType = "export"
+)
+
+var ErrInvalidJobType = fmt.Errorf("not a valid JobType, try [%s]", strings.Join(_JobTypeNames, ", "))
+
+var _JobTypeNames = []string{
+	string(JobTypeDownload),
+	string(JobTypeUpload),
+	string(JobTypeForward),
+	string(JobTypeExport),
+}
+
+// JobTypeNames returns a list of possible string values of JobType.
+func JobTypeNames() []string {
+	tmp := make([]string, len(_JobTypeNames))
+	copy(tmp, _JobTypeNames)
+	return tmp
+}
+
+// JobTypeValues returns a list of the values for JobType
+func JobTypeValues() []JobType {
+	return []JobType{
+		JobTypeDownload,
+		JobTypeUpload,
+		JobTypeForward,
+		JobTypeExport,
+	}
+}
+
+// String implements the Stringer interface.
+func (x JobType) String() string {
+	return string(x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x JobType) IsValid() bool {
+	_, err := ParseJobType(string(x))
+	return err == nil
+}
+
+var _JobTypeValue = map[string]JobType{
+	"download": JobTypeDownload,
+	"upload":   JobTypeUpload,
+	"forward":  JobTypeForward,
+	"export":   JobTypeExport,
+}
+
+// ParseJobType attempts to convert a string to a JobType.
+func ParseJobType(name string) (JobType, error) {
+	if x, ok := _JobTypeValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _JobTypeValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return JobType(""), fmt.Errorf("%s is %w", name, ErrInvalidJobType)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *JobType) Set(val string) error {
+	v, err := ParseJobType(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *JobType) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *JobType) Type() string {
+	return "JobType"
+}
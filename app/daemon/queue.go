@@ -0,0 +1,181 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/key"
+)
+
+// queue is the list of jobs persisted in kv storage, so jobs survive daemon restarts
+type queue struct {
+	kvd    storage.Storage
+	mu     *sync.Mutex
+	state  queueState
+	notify chan struct{} // new jobs are added
+}
+
+type queueState struct {
+	Next int    `json:"next"` // id of next job
+	Jobs []*Job `json:"jobs"`
+}
+
+func loadQueue(ctx context.Context, kvd storage.Storage) (*queue, error) {
+	q := &queue{
+		kvd:    kvd,
+		mu:     &sync.Mutex{},
+		state:  queueState{Next: 1, Jobs: make([]*Job, 0)},
+		notify: make(chan struct{}, 1),
+	}
+
+	b, err := kvd.Get(ctx, key.DaemonJobs())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return q, nil
+		}
+		return nil, errors.Wrap(err, "get jobs")
+	}
+	if err = json.Unmarshal(b, &q.state); err != nil {
+		return nil, errors.Wrap(err, "unmarshal jobs")
+	}
+
+	// jobs interrupted by last exit are executed again
+	for _, job := range q.state.Jobs {
+		if job.Status == JobStatusRunning {
+			job.Status, job.Started = JobStatusQueued, nil
+		}
+	}
+
+	return q, q.save(ctx)
+}
+
+// save should be called with lock held
+func (q *queue) save(ctx context.Context) error {
+	b, err := json.Marshal(q.state)
+	if err != nil {
+		return errors.Wrap(err, "marshal jobs")
+	}
+
+	return q.kvd.Set(ctx, key.DaemonJobs(), b)
+}
+
+func (q *queue) add(ctx context.Context, typ JobType, opts json.RawMessage) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := &Job{
+		ID:      q.state.Next,
+		Type:    typ,
+		Status:  JobStatusQueued,
+		Options: opts,
+		Created: time.Now(),
+	}
+	q.state.Next++
+	q.state.Jobs = append(q.state.Jobs, job)
+
+	if err := q.save(ctx); err != nil {
+		return Job{}, err
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return *job, nil
+}
+
+func (q *queue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, 0, len(q.state.Jobs))
+	for _, job := range q.state.Jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+func (q *queue) get(id int) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job := q.find(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+func (q *queue) find(id int) *Job {
+	for _, job := range q.state.Jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// next marks the first queued job as running and returns it. ok is false if there is no queued job.
+func (q *queue) next(ctx context.Context) (_ Job, ok bool, _ error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.state.Jobs {
+		if job.Status != JobStatusQueued {
+			continue
+		}
+
+		now := time.Now()
+		job.Status, job.Started = JobStatusRunning, &now
+		return *job, true, q.save(ctx)
+	}
+
+	return Job{}, false, nil
+}
+
+func (q *queue) finish(ctx context.Context, id int, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return nil
+	}
+
+	now := time.Now()
+	job.Finished = &now
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = JobStatusCanceled
+	case err != nil:
+		job.Status, job.Error = JobStatusFailed, err.Error()
+	default:
+		job.Status = JobStatusDone
+	}
+
+	return q.save(ctx)
+}
+
+// cancel cancels the queued job directly, and returns status of the job.
+// Running jobs should be canceled by caller.
+func (q *queue) cancel(ctx context.Context, id int) (JobStatus, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return "", false, nil
+	}
+	if job.Status != JobStatusQueued {
+		return job.Status, true, nil
+	}
+
+	now := time.Now()
+	job.Status, job.Finished = JobStatusCanceled, &now
+	return job.Status, true, q.save(ctx)
+}
@@ -0,0 +1,70 @@
+package dl
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// mediaCache is a LRU cache of resolved media of messages. Entries also expire after ttl, because file references are not permanent.
+type mediaCache struct {
+	mu    *sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	medias  []*media
+	created time.Time
+}
+
+func newMediaCache(size int, ttl time.Duration) *mediaCache {
+	return &mediaCache{
+		mu:    &sync.Mutex{},
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *mediaCache) get(key string) ([]*media, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*cacheEntry)
+	if time.Since(entry.created) > c.ttl {
+		c.remove(e)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	return entry.medias, true
+}
+
+func (c *mediaCache) set(key string, m []*media) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, medias: m, created: time.Now()})
+
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *mediaCache) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,136 @@
+package dl
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+
+	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/tmessage"
+)
+
+// chatIter streams messages of the chat into downloader, so download starts without collecting the whole chat.
+// Message ids are used as logical positions, which are stable even if new messages are posted between runs.
+type chatIter struct {
+	*iter
+
+	stream  *tmessage.ChatStream
+	album   int64 // last processed album, messages of album are iterated one by one
+	started bool
+}
+
+func newChatIter(ctx context.Context, pool dcpool.Pool, manager *peers.Manager, kvd storage.Storage,
+	opts Options, delay time.Duration,
+) (*chatIter, error) {
+	stream, err := tmessage.NewChatStream(ctx, pool.Default(ctx), manager, opts.Chat, true)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := buildIter(pool, manager, kvd, nil, opts, delay)
+	if err != nil {
+		return nil, err
+	}
+	base.fingerprint = chatFingerprint(stream.Peer().ID(), opts.Chat)
+
+	return &chatIter{
+		iter:   base,
+		stream: stream,
+	}, nil
+}
+
+func (c *chatIter) Next(ctx context.Context) bool {
+	if len(c.elem) > 0 {
+		return true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.err = ctx.Err()
+			return false
+		default:
+		}
+
+		// if delay is set, sleep for a while for each iteration
+		if c.delay > 0 && c.started { // skip first delay
+			time.Sleep(c.delay)
+		}
+		c.started = true
+
+		if !c.stream.Next(ctx) {
+			if err := c.stream.Err(); err != nil {
+				c.err = err
+			}
+			return false
+		}
+
+		ret, skip := c.processMessage(ctx, c.stream.Value())
+		if skip {
+			continue
+		}
+
+		return ret
+	}
+}
+
+func (c *chatIter) processMessage(ctx context.Context, msg *tg.Message) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return false, false
+	}
+
+	from := c.stream.Peer()
+
+	groupedID, ok := msg.GetGroupedID()
+	if !ok || !c.opts.Group {
+		return c.processSingle(ctx, msg, from, msg.ID, 0)
+	}
+
+	// the whole album is downloaded with its first iterated message
+	if groupedID == c.album {
+		return false, true
+	}
+	c.album = groupedID
+
+	grouped, err := tutil.GetGroupedMessages(ctx, c.pool.Default(ctx), from.InputPeer(), msg)
+	if err != nil {
+		c.err = errors.Wrapf(err, "resolve grouped message %d/%d", from.ID(), msg.ID)
+		return false, false
+	}
+
+	queued := false
+	for idx, m := range grouped {
+		ret, skip := c.processSingle(ctx, m, from, m.ID, idx)
+		if !ret && !skip { // c.err is set
+			return false, false
+		}
+		queued = queued || ret
+	}
+
+	return queued, !queued
+}
+
+// chatFingerprint identifies download of the chat by options instead of messages,
+// so that resume still works if new messages are posted in open range
+func chatFingerprint(peer int64, opts tmessage.ChatOptions) string {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Unix()
+	}
+
+	s := fmt.Sprintf("chat:%d:%d:%d-%d:%d-%d", peer, opts.Topic,
+		opts.Range[0], opts.Range[1], unix(opts.Since), unix(opts.Until))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
@@ -0,0 +1,34 @@
+package dl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/iyear/tdl/pkg/tmessage"
+)
+
+func TestChatFingerprint(t *testing.T) {
+	opts := tmessage.ChatOptions{Chat: "tdl", Range: [2]int{100, 0}, Since: time.Unix(1700000000, 0)}
+	fp := chatFingerprint(1, opts)
+
+	// chat is identified by id, and collected messages are not involved
+	same := opts
+	same.Chat = "1"
+	assert.Equal(t, fp, chatFingerprint(1, same))
+
+	assert.NotEqual(t, fp, chatFingerprint(2, opts))
+
+	topic := opts
+	topic.Topic = 5
+	assert.NotEqual(t, fp, chatFingerprint(1, topic))
+
+	rng := opts
+	rng.Range[1] = 200
+	assert.NotEqual(t, fp, chatFingerprint(1, rng))
+
+	until := opts
+	until.Until = time.Unix(1800000000, 0)
+	assert.NotEqual(t, fp, chatFingerprint(1, until))
+}
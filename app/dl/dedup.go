@@ -0,0 +1,154 @@
+package dl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/key"
+)
+
+//go:generate go-enum --names --values --flag --nocase
+
+// Dedup is the action applied to media which has been downloaded before
+// ENUM(none, skip, hardlink, symlink)
+type Dedup int
+
+// dedup is an index of downloaded files in kv storage, keyed by Telegram file id and SHA-256 of the content.
+// It works across different chats and runs.
+type dedup struct {
+	kvd  storage.Storage
+	mode Dedup
+}
+
+func newDedup(kvd storage.Storage, mode Dedup) *dedup {
+	return &dedup{
+		kvd:  kvd,
+		mode: mode,
+	}
+}
+
+func (d *dedup) enabled() bool {
+	return d.mode != DedupNone
+}
+
+// lookupFile returns the existing path of the downloaded media with the same file id
+func (d *dedup) lookupFile(ctx context.Context, loc tg.InputFileLocationClass, size int64) (string, bool, error) {
+	k, ok := fileKey(loc)
+	if !ok {
+		return "", false, nil
+	}
+
+	return d.lookup(ctx, k, size)
+}
+
+// lookupHash returns the existing path of the downloaded file with the same content
+func (d *dedup) lookupHash(ctx context.Context, sum string, size int64) (string, bool, error) {
+	return d.lookup(ctx, key.DedupHash(sum), size)
+}
+
+func (d *dedup) lookup(ctx context.Context, k string, size int64) (string, bool, error) {
+	b, err := d.kvd.Get(ctx, k)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	// the indexed file may be moved or deleted by user
+	path := string(b)
+	if stat, err := os.Stat(path); err != nil || stat.Size() != size {
+		return "", false, nil
+	}
+
+	return path, true, nil
+}
+
+// record indexes the downloaded file by file id and content hash
+func (d *dedup) record(ctx context.Context, loc tg.InputFileLocationClass, sum, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrap(err, "abs path")
+	}
+
+	if k, ok := fileKey(loc); ok {
+		if err = d.kvd.Set(ctx, k, []byte(abs)); err != nil {
+			return err
+		}
+	}
+
+	return d.kvd.Set(ctx, key.DedupHash(sum), []byte(abs))
+}
+
+// apply makes path point to the existing file according to the mode.
+// In skip mode, nothing will be created at path.
+func (d *dedup) apply(existing, path string) error {
+	if same, err := samePath(existing, path); err != nil || same {
+		return err
+	}
+
+	switch d.mode {
+	case DedupHardlink, DedupSymlink:
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return errors.Wrap(err, "create dir")
+		}
+		// replace the file if it exists
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove file")
+		}
+
+		if d.mode == DedupHardlink {
+			return os.Link(existing, path)
+		}
+		return os.Symlink(existing, path)
+	default:
+		return nil
+	}
+}
+
+func fileKey(loc tg.InputFileLocationClass) (string, bool) {
+	switch l := loc.(type) {
+	case *tg.InputDocumentFileLocation:
+		return key.DedupFile(l.ID, l.AccessHash), true
+	case *tg.InputPhotoFileLocation:
+		return key.DedupFile(l.ID, l.AccessHash), true
+	default:
+		return "", false
+	}
+}
+
+func samePath(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+
+	return absA == absB, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,113 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version: 0.5.8
+// Revision: 3d844c8ecc59661ed7aa17bfd65727bc06a60ad8
+// Build Date: 2023-09-18T14:55:21Z
+// Built By: goreleaser
+
+package dl
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DedupNone is a Dedup of type None.
+	DedupNone Dedup = iota
+	// DedupSkip is a Dedup of type Skip.
+	DedupSkip
+	// DedupHardlink is a Dedup of type Hardlink.
+	DedupHardlink
+	// DedupSymlink is a Dedup of type Symlink.
+	DedupSymlink
+)
+
+var ErrInvalidDedup = fmt.Errorf("not a valid Dedup, try [%s]", strings.Join(_DedupNames, ", "))
+
+const _DedupName = "noneskiphardlinksymlink"
+
+var _DedupNames = []string{
+	_DedupName[0:4],
+	_DedupName[4:8],
+	_DedupName[8:16],
+	_DedupName[16:23],
+}
+
+// DedupNames returns a list of possible string values of Dedup.
+func DedupNames() []string {
+	tmp := make([]string, len(_DedupNames))
+	copy(tmp, _DedupNames)
+	return tmp
+}
+
+// DedupValues returns a list of the values for Dedup
+func DedupValues() []Dedup {
+	return []Dedup{
+		DedupNone,
+		DedupSkip,
+		DedupHardlink,
+		DedupSymlink,
+	}
+}
+
+var _DedupMap = map[Dedup]string{
+	DedupNone:     _DedupName[0:4],
+	DedupSkip:     _DedupName[4:8],
+	DedupHardlink: _DedupName[8:16],
+	DedupSymlink:  _DedupName[16:23],
+}
+
+// String implements the Stringer interface.
+func (x Dedup) String() string {
+	if str, ok := _DedupMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("Dedup(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x Dedup) IsValid() bool {
+	_, ok := _DedupMap[x]
+	return ok
+}
+
+var _DedupValue = map[string]Dedup{
+	_DedupName[0:4]:                    DedupNone,
+	strings.ToLower(_DedupName[0:4]):   DedupNone,
+	_DedupName[4:8]:                    DedupSkip,
+	strings.ToLower(_DedupName[4:8]):   DedupSkip,
+	_DedupName[8:16]:                   DedupHardlink,
+	strings.ToLower(_DedupName[8:16]):  DedupHardlink,
+	_DedupName[16:23]:                  DedupSymlink,
+	strings.ToLower(_DedupName[16:23]): DedupSymlink,
+}
+
+// ParseDedup attempts to convert a string to a Dedup.
+func ParseDedup(name string) (Dedup, error) {
+	if x, ok := _DedupValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _DedupValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return Dedup(0), fmt.Errorf("%s is %w", name, ErrInvalidDedup)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *Dedup) Set(val string) error {
+	v, err := ParseDedup(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *Dedup) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *Dedup) Type() string {
+	return "Dedup"
+}
@@ -0,0 +1,72 @@
+package dl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/core/storage"
+)
+
+type memStorage map[string][]byte
+
+func (m memStorage) Get(_ context.Context, key string) ([]byte, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m memStorage) Set(_ context.Context, key string, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+func (m memStorage) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestDedup(t *testing.T) {
+	ctx, dir := context.Background(), t.TempDir()
+
+	existing := filepath.Join(dir, "a.mp4")
+	require.NoError(t, os.WriteFile(existing, []byte("hello"), 0o644))
+	sum, err := fileSHA256(existing)
+	require.NoError(t, err)
+
+	loc := &tg.InputDocumentFileLocation{ID: 1, AccessHash: 2}
+	d := newDedup(memStorage{}, DedupHardlink)
+	require.NoError(t, d.record(ctx, loc, sum, existing))
+
+	path, ok, err := d.lookupFile(ctx, loc, 5)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, existing, path)
+
+	// size mismatch means the indexed file is changed
+	_, ok, err = d.lookupHash(ctx, sum, 6)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = d.lookupFile(ctx, &tg.InputDocumentFileLocation{ID: 3, AccessHash: 2}, 5)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	linked := filepath.Join(dir, "sub", "b.mp4")
+	require.NoError(t, d.apply(existing, linked))
+	b, err := os.ReadFile(linked)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	skip := newDedup(memStorage{}, DedupSkip)
+	require.NoError(t, skip.apply(existing, filepath.Join(dir, "c.mp4")))
+	_, err = os.Stat(filepath.Join(dir, "c.mp4"))
+	assert.True(t, os.IsNotExist(err))
+}
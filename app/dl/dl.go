@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/fatih/color"
@@ -14,27 +16,36 @@ import (
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
-	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/app/internal/tctx"
 	"github.com/iyear/tdl/core/downloader"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
-	"github.com/iyear/tdl/core/tclient"
+	"github.com/iyear/tdl/pkg/bandwidth"
 	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/key"
+	"github.com/iyear/tdl/pkg/metrics"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
+	"github.com/iyear/tdl/pkg/texpr"
 	"github.com/iyear/tdl/pkg/tmessage"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
 type Options struct {
 	Dir        string
+	Sink       string // destination of files, see newSink
 	RewriteExt bool
 	SkipSame   bool
+	Dedup      Dedup
+	Verify     bool
+	Report     string
 	Template   string
 	URLs       []string
 	Files      []string
+	Chat       tmessage.ChatOptions // download from chat directly
 	Include    []string
 	Exclude    []string
+	Filter     string
 	Desc       bool
 	Takeout    bool
 	Group      bool // auto detect grouped message
@@ -43,8 +54,20 @@ type Options struct {
 	Continue, Restart bool
 
 	// serve
-	Serve bool
-	Port  int
+	Serve      bool
+	Bind       string
+	Port       int
+	Inline     bool   // serve media inline so that browsers play them instead of downloading
+	Auth       string // basic auth, format: user:password
+	Token      string // bearer token
+	SignKey    string // HMAC key of signed links, random if empty
+	LinkExpire time.Duration
+	TLSCert    string
+	TLSKey     string
+	WebDAV     []string // chats exposed as read-only WebDAV filesystem
+
+	// watch chats and download new media
+	Watch []string
 }
 
 type parser struct {
@@ -53,14 +76,35 @@ type parser struct {
 }
 
 func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
+	// only output available fields
+	if opts.Filter == "-" {
+		return printFilterFields()
+	}
+
+	redirectOutput(opts)
+
+	pool := tctx.NewPool(ctx, c)
 	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
 
+	if len(opts.WebDAV) > 0 {
+		return serveWebDAV(ctx, kvd, pool, opts)
+	}
+
+	// chat is streamed into downloader instead of being collected, unless it's served
+	streamChat := opts.Chat.Chat != "" && !opts.Serve
+	if streamChat && (len(opts.URLs) > 0 || len(opts.Files) > 0) {
+		return errors.New("chat can't be downloaded together with urls or files")
+	}
+
+	collectChat := opts.Chat
+	if streamChat {
+		collectChat = tmessage.ChatOptions{}
+	}
+
 	parsers := []parser{
 		{Data: opts.URLs, Parser: tmessage.FromURL(ctx, pool, kvd, opts.URLs)},
 		{Data: opts.Files, Parser: tmessage.FromFile(ctx, pool, kvd, opts.Files, true)},
+		{Data: []string{collectChat.Chat}, Parser: tmessage.FromChat(ctx, pool, kvd, collectChat, true)},
 	}
 	dialogs, err := collectDialogs(parsers)
 	if err != nil {
@@ -70,19 +114,32 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		zap.Any("dialogs", dialogs))
 
 	if opts.Serve {
-		return serve(ctx, kvd, pool, dialogs, opts.Port, opts.Takeout)
+		return serve(ctx, kvd, pool, dialogs, opts)
 	}
 
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
 
-	it, err := newIter(pool, manager, dialogs, opts, viper.GetDuration(consts.FlagDelay))
-	if err != nil {
-		return err
+	var (
+		it     *iter
+		dlIter downloader.Iter
+	)
+	if streamChat {
+		ci, err := newChatIter(ctx, pool, manager, kvd, opts, viper.GetDuration(consts.FlagDelay))
+		if err != nil {
+			return err
+		}
+		it, dlIter = ci.iter, ci
+	} else {
+		if it, err = newIter(pool, manager, kvd, dialogs, opts, viper.GetDuration(consts.FlagDelay)); err != nil {
+			return err
+		}
+		dlIter = it
 	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(it.sink))
 
 	if !opts.Restart {
-		// resume download and ask user to continue
-		if err = resume(ctx, kvd, it, !opts.Continue); err != nil {
+		// resume download and ask user to continue, unless stdout is occupied by files
+		if err = resume(ctx, kvd, it, !opts.Continue && !SinkStdout(opts.Sink)); err != nil {
 			return err
 		}
 	} else {
@@ -90,13 +147,21 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 	}
 
 	defer func() { // save progress
-		if rerr != nil { // download is interrupted
+		// download is interrupted, or some files are partially downloaded and can be resumed
+		if rerr != nil || len(it.Partials()) > 0 {
 			multierr.AppendInto(&rerr, saveProgress(ctx, kvd, it))
 		} else { // if finished, we should clear resume key
-			multierr.AppendInto(&rerr, kvd.Delete(ctx, key.Resume(it.Fingerprint())))
+			multierr.AppendInto(&rerr, clearProgress(ctx, kvd, it))
 		}
 	}()
 
+	r, err := report.New("dl", opts.Report)
+	if err != nil {
+		return errors.Wrap(err, "create report")
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(r))
+	it.report = r
+
 	dlProgress := prog.New(utils.Byte.FormatBinaryBytes)
 	dlProgress.SetNumTrackersExpected(it.Total())
 	prog.EnablePS(ctx, dlProgress)
@@ -104,19 +169,24 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 	options := downloader.Options{
 		Pool:     pool,
 		Threads:  viper.GetInt(consts.FlagThreads),
-		Iter:     it,
-		Progress: newProgress(dlProgress, it, opts),
+		Iter:     dlIter,
+		Progress: metrics.Download(newProgress(dlProgress, it, opts, r)),
+		Verify:   opts.Verify,
+		Limiter:  bandwidth.From(ctx),
 	}
 	limit := viper.GetInt(consts.FlagLimit)
 
 	logctx.From(ctx).Info("Start download",
 		zap.String("dir", opts.Dir),
+		zap.String("sink", opts.Sink),
 		zap.Bool("rewrite_ext", opts.RewriteExt),
 		zap.Bool("skip_same", opts.SkipSame),
+		zap.String("dedup", opts.Dedup.String()),
+		zap.Bool("verify", opts.Verify),
 		zap.Int("threads", options.Threads),
 		zap.Int("limit", limit))
 
-	color.Green("All files will be downloaded to '%s' dir", opts.Dir)
+	color.Green("All files will be downloaded to %s", it.sink)
 
 	go dlProgress.Render()
 	defer func() {
@@ -140,6 +210,18 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 	return downloader.New(options).Download(ctx, limit)
 }
 
+func printFilterFields() error {
+	fg := texpr.NewFieldsGetter(nil)
+
+	fields, err := fg.Walk(&texpr.EnvMessage{})
+	if err != nil {
+		return fmt.Errorf("failed to walk fields: %w", err)
+	}
+
+	fmt.Print(fg.Sprint(fields, true))
+	return nil
+}
+
 func collectDialogs(parsers []parser) ([][]*tmessage.Dialog, error) {
 	var dialogs [][]*tmessage.Dialog
 	for _, p := range parsers {
@@ -156,28 +238,31 @@ func resume(ctx context.Context, kvd storage.Storage, iter *iter, ask bool) erro
 	logctx.From(ctx).Debug("Check resume key",
 		zap.String("fingerprint", iter.Fingerprint()))
 
-	b, err := kvd.Get(ctx, key.Resume(iter.Fingerprint()))
-	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+	finished := make(map[int]struct{})
+	if err := loadProgress(ctx, kvd, key.Resume(iter.Fingerprint()), &finished); err != nil {
 		return err
 	}
-	if len(b) == 0 { // no progress
-		return nil
-	}
-
-	finished := make(map[int]struct{})
-	if err = json.Unmarshal(b, &finished); err != nil {
+	partials := make(map[int]*partial)
+	if err := loadProgress(ctx, kvd, key.ResumeParts(iter.Fingerprint()), &partials); err != nil {
 		return err
 	}
 
-	// finished is empty, no need to resume
-	if len(finished) == 0 {
+	// finished and partials are empty, no need to resume
+	if len(finished) == 0 && len(partials) == 0 {
 		return nil
 	}
 
 	confirm := false
-	resumeStr := fmt.Sprintf("Found unfinished download, continue from '%d/%d'", len(finished), iter.Total())
+	total := "?" // streamed chat has unknown total
+	if n := iter.Total(); n > 0 {
+		total = strconv.Itoa(n)
+	}
+	resumeStr := fmt.Sprintf("Found unfinished download, continue from '%d/%s'", len(finished), total)
+	if len(partials) > 0 {
+		resumeStr += fmt.Sprintf(" with %d partially downloaded file(s)", len(partials))
+	}
 	if ask {
-		if err = survey.AskOne(&survey.Confirm{
+		if err := survey.AskOne(&survey.Confirm{
 			Message: color.YellowString(resumeStr + "?"),
 		}, &confirm); err != nil {
 			return err
@@ -188,25 +273,59 @@ func resume(ctx context.Context, kvd storage.Storage, iter *iter, ask bool) erro
 	}
 
 	logctx.From(ctx).Debug("Resume download",
-		zap.Int("finished", len(finished)))
+		zap.Int("finished", len(finished)),
+		zap.Int("partials", len(partials)))
 
 	if !confirm {
 		// clear resume key
-		return kvd.Delete(ctx, key.Resume(iter.Fingerprint()))
+		return clearProgress(ctx, kvd, iter)
 	}
 
 	iter.SetFinished(finished)
+	iter.SetPartials(partials)
 	return nil
 }
 
+func loadProgress(ctx context.Context, kvd storage.Storage, k string, v any) error {
+	b, err := kvd.Get(ctx, k)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if len(b) == 0 { // no progress
+		return nil
+	}
+
+	return json.Unmarshal(b, v)
+}
+
 func saveProgress(ctx context.Context, kvd storage.Storage, it *iter) error {
-	finished := it.Finished()
+	finished, partials := it.Finished(), it.Partials()
 	logctx.From(ctx).Debug("Save progress",
-		zap.Int("finished", len(finished)))
+		zap.Int("finished", len(finished)),
+		zap.Int("partials", len(partials)))
 
 	b, err := json.Marshal(finished)
 	if err != nil {
 		return err
 	}
-	return kvd.Set(ctx, key.Resume(it.Fingerprint()), b)
+	if err = kvd.Set(ctx, key.Resume(it.Fingerprint()), b); err != nil {
+		return err
+	}
+
+	if len(partials) == 0 {
+		return kvd.Delete(ctx, key.ResumeParts(it.Fingerprint()))
+	}
+
+	b, err = json.Marshal(partials)
+	if err != nil {
+		return err
+	}
+	return kvd.Set(ctx, key.ResumeParts(it.Fingerprint()), b)
+}
+
+func clearProgress(ctx context.Context, kvd storage.Storage, it *iter) error {
+	return multierr.Combine(
+		kvd.Delete(ctx, key.Resume(it.Fingerprint())),
+		kvd.Delete(ctx, key.ResumeParts(it.Fingerprint())),
+	)
 }
@@ -2,7 +2,6 @@ package dl
 
 import (
 	"io"
-	"os"
 
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/tg"
@@ -19,7 +18,8 @@ type iterElem struct {
 	fromMsg *tg.Message
 	file    *tmedia.Media
 
-	to *os.File
+	to      sinkFile
+	partial *partial
 
 	opts Options
 }
@@ -37,3 +37,7 @@ func (i *iterElem) Name() string { return i.file.Name }
 func (i *iterElem) Size() int64 { return i.file.Size }
 
 func (i *iterElem) DC() int { return i.file.DC }
+
+func (i *iterElem) Completed(offset int64) bool { return i.partial.completed(offset) }
+
+func (i *iterElem) Complete(offset int64) { i.partial.complete(offset) }
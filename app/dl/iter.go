@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -13,6 +14,8 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/tg"
@@ -22,27 +25,19 @@ import (
 	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/downloader"
 	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/core/tmedia"
 	"github.com/iyear/tdl/core/util/fsutil"
 	"github.com/iyear/tdl/core/util/tutil"
 	"github.com/iyear/tdl/pkg/filterMap"
+	"github.com/iyear/tdl/pkg/report"
+	"github.com/iyear/tdl/pkg/texpr"
 	"github.com/iyear/tdl/pkg/tmessage"
 	"github.com/iyear/tdl/pkg/tplfunc"
-	"github.com/iyear/tdl/pkg/utils"
 )
 
 const tempExt = ".tmp"
 
-type fileTemplate struct {
-	DialogID     int64
-	MessageID    int
-	MessageDate  int64
-	FileName     string
-	FileCaption  string
-	FileSize     string
-	DownloadDate int64
-}
-
 type iter struct {
 	pool    dcpool.Pool
 	manager *peers.Manager
@@ -50,11 +45,19 @@ type iter struct {
 	tpl     *template.Template
 	include map[string]struct{}
 	exclude map[string]struct{}
+	filter  *vm.Program
+	dedup   *dedup
+	sink    sink
 	opts    Options
 	delay   time.Duration
 
+	prefetch    *tmessage.Prefetcher // messages are fetched in batches ahead of download workers
+	resolver    *tplResolver         // resolves topic titles and sender names for file template
+	report      *report.Reporter     // records skipped elements, nil if report is disabled
 	mu          *sync.Mutex
 	finished    map[int]struct{}
+	partials    map[int]*partial // in-flight and resumed temp files
+	watch       bool             // progress is not persisted in watch mode, so temp files can't be resumed
 	fingerprint string
 	// This param is kept for potential future use but is currently unused.
 	// preSum       []int
@@ -70,7 +73,23 @@ type iter struct {
 	err            error
 }
 
-func newIter(pool dcpool.Pool, manager *peers.Manager, dialog [][]*tmessage.Dialog,
+func newIter(pool dcpool.Pool, manager *peers.Manager, kvd storage.Storage, dialog [][]*tmessage.Dialog,
+	opts Options, delay time.Duration,
+) (*iter, error) {
+	dialogs := flatDialogs(dialog)
+	// if msgs is empty, return error to avoid range out of index
+	if len(dialogs) == 0 {
+		return nil, errors.Errorf("you must specify at least one message")
+	}
+
+	// to keep fingerprint stable
+	sortDialogs(dialogs, opts.Desc)
+
+	return buildIter(pool, manager, kvd, dialogs, opts, delay)
+}
+
+// buildIter builds iter without checking dialogs, which can be empty in watch mode
+func buildIter(pool dcpool.Pool, manager *peers.Manager, kvd storage.Storage, dialogs []*tmessage.Dialog,
 	opts Options, delay time.Duration,
 ) (*iter, error) {
 	tpl, err := template.New("dl").
@@ -80,18 +99,19 @@ func newIter(pool dcpool.Pool, manager *peers.Manager, dialog [][]*tmessage.Dial
 		return nil, errors.Wrap(err, "parse template")
 	}
 
-	dialogs := flatDialogs(dialog)
-	// if msgs is empty, return error to avoid range out of index
-	if len(dialogs) == 0 {
-		return nil, errors.Errorf("you must specify at least one message")
-	}
-
 	// include and exclude
 	includeMap := filterMap.New(opts.Include, fsutil.AddPrefixDot)
 	excludeMap := filterMap.New(opts.Exclude, fsutil.AddPrefixDot)
 
-	// to keep fingerprint stable
-	sortDialogs(dialogs, opts.Desc)
+	filter, err := expr.Compile(opts.Filter, expr.AsBool())
+	if err != nil {
+		return nil, errors.Wrap(err, "compile filter")
+	}
+
+	s, err := newSink(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "create sink")
+	}
 
 	return &iter{
 		pool:    pool,
@@ -100,11 +120,16 @@ func newIter(pool dcpool.Pool, manager *peers.Manager, dialog [][]*tmessage.Dial
 		opts:    opts,
 		include: includeMap,
 		exclude: excludeMap,
+		filter:  filter,
+		dedup:   newDedup(kvd, opts.Dedup),
+		sink:    s,
 		tpl:     tpl,
 		delay:   delay,
 
+		resolver:    newTplResolver(manager),
 		mu:          &sync.Mutex{},
 		finished:    make(map[int]struct{}),
+		partials:    make(map[int]*partial),
 		fingerprint: fingerprint(dialogs),
 		// This param is kept for potential future use but is currently unused.
 		// preSum:       preSum(dialogs),
@@ -114,12 +139,22 @@ func newIter(pool dcpool.Pool, manager *peers.Manager, dialog [][]*tmessage.Dial
 		counter:        atomic.NewInt64(-1),
 		skippedDeleted: atomic.NewInt64(0),
 		deletedIDs:     make([]string, 0),
-		elem:           make(chan downloader.Elem, 10), // grouped message buffer
+		elem:           make(chan downloader.Elem, 10*maxItems), // grouped message buffer, each message may have several media
 		err:            nil,
 	}, nil
 }
 
 func (i *iter) Next(ctx context.Context) bool {
+	if i.next(ctx) {
+		return true
+	}
+
+	// stop prefetching once iteration is finished or failed
+	i.prefetch.Close()
+	return false
+}
+
+func (i *iter) next(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
 		i.err = ctx.Err()
@@ -151,63 +186,97 @@ func (i *iter) process(ctx context.Context) (ret bool, skip bool) {
 	defer i.mu.Unlock()
 
 	// end of iteration or error occurred
-	if i.dialogIndex >= len(i.dialogs) || i.messageIndex >= len(i.dialogs[i.dialogIndex].Messages) || i.err != nil {
+	if i.dialogIndex >= len(i.dialogs) || i.messageIndex >= i.dialogs[i.dialogIndex].Len() || i.err != nil {
 		return false, false
 	}
 
-	peer, msg := i.dialogs[i.dialogIndex].Peer, i.dialogs[i.dialogIndex].Messages[i.messageIndex]
-
 	// Record current logical position before processing
 	startLogicalPos := i.logicalPos
 
 	// Defer physical position increment
 	defer func() {
-		if i.messageIndex++; i.dialogIndex < len(i.dialogs) && i.messageIndex >= len(i.dialogs[i.dialogIndex].Messages) {
+		if i.messageIndex++; i.dialogIndex < len(i.dialogs) && i.messageIndex >= i.dialogs[i.dialogIndex].Len() {
 			i.dialogIndex++
 			i.messageIndex = 0
 		}
 	}()
 
-	from, err := i.manager.FromInputPeer(ctx, peer)
-	if err != nil {
-		i.err = errors.Wrap(err, "resolve from input peer")
-		return false, false
+	// messages and stories are fetched in the same order as dialogs, so it's always the current one
+	if i.prefetch == nil {
+		i.prefetch = tmessage.NewPrefetcher(ctx, i.dialogs, i.manager.FromInputPeer,
+			i.fetchMessages, i.fetchStories, tmessage.PrefetchAhead)
 	}
-	message, err := tutil.GetSingleMessage(ctx, i.pool.Default(ctx), peer, msg)
+	fetched, err := i.prefetch.Next(ctx)
 	if err != nil {
-		// Check if the error is due to a deleted message
-		if errors.Is(err, tutil.ErrMessageDeleted) {
-			logctx.From(ctx).Info("Message may be deleted, skipping",
-				zap.Int64("dialog_id", tutil.GetInputPeerID(peer)),
-				zap.Int("message_id", msg),
-			)
-			i.skippedDeleted.Inc()                                                                     // increment skipped deleted counter
-			i.deletedIDs = append(i.deletedIDs, fmt.Sprintf("%d/%d", tutil.GetInputPeerID(peer), msg)) // track deleted message ID
-			i.logicalPos++                                                                             // increment logical position for skipped message
-			return false, true
-		}
 		i.err = errors.Wrap(err, "resolve message")
 		return false, false
 	}
+	from, message := fetched.From, fetched.Message
+
+	// check if the message is deleted
+	if message == nil {
+		peer, msg := tutil.GetInputPeerID(fetched.Peer), fetched.ID
+		logctx.From(ctx).Info("Message may be deleted, skipping",
+			zap.Int64("dialog_id", peer),
+			zap.Int("message_id", msg),
+			zap.Bool("story", fetched.Story),
+		)
+		i.skippedDeleted.Inc()                                               // increment skipped deleted counter
+		i.deletedIDs = append(i.deletedIDs, fmt.Sprintf("%d/%d", peer, msg)) // track deleted message ID
+		i.logicalPos++                                                       // increment logical position for skipped message
+		i.skip(reportPeer(from), msg, "", "deleted")
+		return false, true
+	}
 
 	if _, ok := message.GetGroupedID(); ok && i.opts.Group {
 		return i.processGrouped(ctx, message, from, startLogicalPos)
 	}
 
-	// check if finished
-	if _, ok := i.finished[startLogicalPos]; ok {
-		i.logicalPos++ // increment logical position even if skipped
-		return false, true
-	}
-
-	ret, skip = i.processSingle(ctx, message, from, startLogicalPos)
+	ret, skip = i.processSingle(ctx, message, from, startLogicalPos, 0)
 	i.logicalPos++ // increment logical position after processing
 	return ret, skip
 }
 
-func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peers.Peer, logicalPos int) (bool, bool) {
-	item, ok := tmedia.GetMedia(message)
+func (i *iter) fetchMessages(ctx context.Context, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	return tutil.GetMessages(ctx, i.pool.Default(ctx), peer, ids)
+}
+
+func (i *iter) fetchStories(ctx context.Context, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	return tutil.GetStories(ctx, i.pool.Default(ctx), peer, ids)
+}
+
+// maxItems is the max number of media in one message, like paid media with several items
+const maxItems = 16
+
+// itemPos returns logical position of the media item in message. The first item keeps position of the message,
+// and other items use negative positions, which never conflict with positions of messages.
+func itemPos(pos, idx int) int {
+	if idx == 0 {
+		return pos
+	}
+	return -(pos*maxItems + idx)
+}
+
+// processSingle queues all media of the message, which are skipped if they are finished.
+// groupIdx is the index of message in album, which is used by file template.
+func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peers.Peer, logicalPos, groupIdx int) (bool, bool) {
+	// forwarded stories only carry the story id
+	ok, err := tutil.FillStory(ctx, i.pool.Default(ctx), i.manager, message)
+	if err != nil {
+		i.err = errors.Wrapf(err, "resolve story of message %d/%d", from.ID(), message.ID)
+		return false, false
+	}
 	if !ok {
+		logctx.From(ctx).Info("Story may be deleted or expired, skipping",
+			zap.Int64("dialog_id", from.ID()),
+			zap.Int("message_id", message.ID),
+		)
+		i.skip(reportPeer(from), message.ID, "", "deleted")
+		return false, true
+	}
+
+	items := tmedia.GetMedias(message)
+	if len(items) == 0 {
 		logctx.From(ctx).Warn("Message has no media",
 			zap.Int64("dialog_id", from.ID()),
 			zap.Int("message_id", message.ID),
@@ -216,25 +285,52 @@ func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peer
 		return false, true
 	}
 
+	queued := false
+	for idx, item := range items[:min(len(items), maxItems)] {
+		pos := itemPos(logicalPos, idx)
+		if _, ok := i.finished[pos]; ok {
+			continue
+		}
+
+		ret, skip := i.processItem(ctx, message, from, item, pos, groupIdx, idx)
+		if !ret && !skip { // i.err is set
+			return false, false
+		}
+		queued = queued || ret
+	}
+
+	return queued, !queued
+}
+
+func (i *iter) processItem(ctx context.Context, message *tg.Message, from peers.Peer, item *tmedia.Media,
+	logicalPos, groupIdx, mediaIdx int,
+) (bool, bool) {
 	// process include and exclude
 	ext := filepath.Ext(item.Name)
-	if _, ok = i.include[ext]; len(i.include) > 0 && !ok {
+	if _, ok := i.include[ext]; len(i.include) > 0 && !ok {
+		i.skip(reportPeer(from), message.ID, "", "excluded")
+		return false, true
+	}
+	if _, ok := i.exclude[ext]; len(i.exclude) > 0 && ok {
+		i.skip(reportPeer(from), message.ID, "", "excluded")
 		return false, true
 	}
-	if _, ok = i.exclude[ext]; len(i.exclude) > 0 && ok {
+
+	// process filter expression
+	b, err := texpr.Run(i.filter, texpr.ConvertEnvMessage(message))
+	if err != nil {
+		i.err = errors.Wrap(err, "run filter")
+		return false, false
+	}
+	if !b.(bool) { // filtered
+		i.skip(reportPeer(from), message.ID, "", "filtered")
 		return false, true
 	}
 
 	toName := bytes.Buffer{}
-	err := i.tpl.Execute(&toName, &fileTemplate{
-		DialogID:     from.ID(),
-		MessageID:    message.ID,
-		MessageDate:  int64(message.Date),
-		FileName:     item.Name,
-		FileCaption:  message.Message,
-		FileSize:     utils.Byte.FormatBinaryBytes(item.Size),
-		DownloadDate: time.Now().Unix(),
-	})
+	t := newFileTemplate(ctx, i.resolver, from, message, item)
+	t.GroupIndex, t.MediaIndex = groupIdx, mediaIdx
+	err = i.tpl.Execute(&toName, t)
 	if err != nil {
 		i.err = errors.Wrap(err, "execute template")
 		return false, false
@@ -244,23 +340,37 @@ func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peer
 		if stat, err := os.Stat(filepath.Join(i.opts.Dir, toName.String())); err == nil {
 			if fsutil.GetNameWithoutExt(toName.String()) == fsutil.GetNameWithoutExt(stat.Name()) &&
 				stat.Size() == item.Size {
+				i.skip(reportPeer(from), message.ID, filepath.Join(i.opts.Dir, toName.String()), "same file exists")
 				return false, true
 			}
 		}
 	}
 
-	filename := fmt.Sprintf("%s%s", toName.String(), tempExt)
-	path := filepath.Join(i.opts.Dir, filename)
+	if i.dedup.enabled() {
+		existing, ok, err := i.dedup.lookupFile(ctx, item.InputFileLoc, item.Size)
+		if err != nil {
+			i.err = errors.Wrap(err, "lookup dedup index")
+			return false, false
+		}
+		if ok {
+			if err = i.dedup.apply(existing, filepath.Join(i.opts.Dir, toName.String())); err != nil {
+				i.err = errors.Wrap(err, "apply dedup")
+				return false, false
+			}
 
-	// #113. If path contains dirs, create it. So now we support nested dirs.
-	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		i.err = errors.Wrap(err, "create dir")
-		return false, false
+			logctx.From(ctx).Info("Media has been downloaded before",
+				zap.Int64("dialog_id", from.ID()),
+				zap.Int("message_id", message.ID),
+				zap.String("existing", existing),
+				zap.String("dedup", i.dedup.mode.String()))
+			i.skip(reportPeer(from), message.ID, filepath.Join(i.opts.Dir, toName.String()), "duplicate")
+			return false, true
+		}
 	}
 
-	to, err := os.Create(path)
+	to, p, err := i.openTemp(ctx, logicalPos, toName.String(), item.Size)
 	if err != nil {
-		i.err = errors.Wrap(err, "create file")
+		i.err = err
 		return false, false
 	}
 
@@ -272,7 +382,8 @@ func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peer
 		fromMsg: message,
 		file:    item,
 
-		to: to,
+		to:      to,
+		partial: p,
 
 		opts: i.opts,
 	}
@@ -280,6 +391,57 @@ func (i *iter) processSingle(ctx context.Context, message *tg.Message, from peer
 	return true, false
 }
 
+// openTemp reuses the temp file if it's partially downloaded in the last run, otherwise creates a new one.
+func (i *iter) openTemp(ctx context.Context, logicalPos int, name string, size int64) (sinkFile, *partial, error) {
+	rs, _ := i.sink.(resumableSink)
+	resumable := i.resumable()
+
+	if p, ok := i.partials[logicalPos]; ok && resumable && p.Path == name && p.Size == size {
+		f, err := rs.Reopen(name)
+		if err == nil {
+			logctx.From(ctx).Debug("Resume partial file",
+				zap.String("name", name),
+				zap.Int("parts", len(p.Parts)))
+			return f, p, nil
+		}
+
+		logctx.From(ctx).Warn("Partial file is unavailable, download it again",
+			zap.String("name", name),
+			zap.Error(err))
+	}
+
+	f, err := i.sink.Create(name, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := newPartial(name, size)
+	// partially written files of other sinks can't be reopened in the next run
+	if resumable {
+		i.partials[logicalPos] = p
+	}
+
+	return f, p, nil
+}
+
+// skip records the element which is skipped before download in the report
+func (i *iter) skip(from *report.Peer, message int, path, reason string) {
+	i.report.Skip(report.Entry{From: from, Message: message, Path: path}, reason)
+}
+
+func reportPeer(p peers.Peer) *report.Peer {
+	if p == nil {
+		return nil
+	}
+	return &report.Peer{ID: p.ID(), Name: p.VisibleName()}
+}
+
+// resumable reports whether partially written files can be resumed in the next run
+func (i *iter) resumable() bool {
+	_, ok := i.sink.(resumableSink)
+	return ok && !i.watch
+}
+
 func (i *iter) processGrouped(ctx context.Context, message *tg.Message, from peers.Peer, startLogicalPos int) (bool, bool) {
 	grouped, err := tutil.GetGroupedMessages(ctx, i.pool.Default(ctx), from.InputPeer(), message)
 	if err != nil {
@@ -292,12 +454,7 @@ func (i *iter) processGrouped(ctx context.Context, message *tg.Message, from pee
 	for idx, msg := range grouped {
 		logicalPos := startLogicalPos + idx
 
-		// check if this grouped message is already finished
-		if _, ok := i.finished[logicalPos]; ok {
-			continue
-		}
-
-		ret, skip := i.processSingle(ctx, msg, from, logicalPos)
+		ret, skip := i.processSingle(ctx, msg, from, logicalPos, idx)
 
 		// if processSingle encounters a fatal error (not just skip), propagate it
 		if !ret && !skip {
@@ -338,6 +495,31 @@ func (i *iter) Finished() map[int]struct{} {
 	return i.finished
 }
 
+func (i *iter) SetPartials(partials map[int]*partial) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, p := range partials {
+		p.init()
+	}
+	i.partials = partials
+}
+
+// Partials returns unfinished files which have at least one completed part
+func (i *iter) Partials() map[int]*partial {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	partials := make(map[int]*partial)
+	for pos, p := range i.partials {
+		if p.empty() {
+			continue
+		}
+		partials[pos] = p.snapshot()
+	}
+	return partials
+}
+
 func (i *iter) Fingerprint() string {
 	return i.fingerprint
 }
@@ -347,6 +529,7 @@ func (i *iter) Finish(id int) {
 	defer i.mu.Unlock()
 
 	i.finished[id] = struct{}{}
+	delete(i.partials, id)
 }
 
 func (i *iter) Total() int {
@@ -355,7 +538,7 @@ func (i *iter) Total() int {
 
 	total := 0
 	for _, m := range i.dialogs {
-		total += len(m.Messages)
+		total += m.Len()
 	}
 	return total
 }
@@ -379,10 +562,13 @@ func (i *iter) DeletedIDs() []string {
 func flatDialogs(dialogs [][]*tmessage.Dialog) []*tmessage.Dialog {
 	res := make([]*tmessage.Dialog, 0)
 	for _, d := range dialogs {
-		if len(d) == 0 {
-			continue
+		for _, dd := range d {
+			// empty dialog would end the iteration early
+			if dd.Len() == 0 {
+				continue
+			}
+			res = append(res, dd)
 		}
-		res = append(res, d...)
 	}
 	return res
 }
@@ -394,12 +580,14 @@ func sortDialogs(dialogs []*tmessage.Dialog, desc bool) {
 	})
 
 	for _, m := range dialogs {
-		sort.Slice(m.Messages, func(i, j int) bool {
-			if desc {
-				return m.Messages[i] > m.Messages[j]
-			}
-			return m.Messages[i] < m.Messages[j]
-		})
+		for _, ids := range [][]int{m.Messages, m.Stories} {
+			sort.Slice(ids, func(i, j int) bool {
+				if desc {
+					return ids[i] > ids[j]
+				}
+				return ids[i] < ids[j]
+			})
+		}
 	}
 }
 
@@ -423,6 +611,15 @@ func fingerprint(dialogs []*tmessage.Dialog) string {
 			endian.PutUint64(b, uint64(msg))
 			buf.Write(b)
 		}
+		// stories are separated from messages, and fingerprint of dialogs without stories is unchanged
+		if len(m.Stories) > 0 {
+			endian.PutUint64(b, math.MaxUint64)
+			buf.Write(b)
+		}
+		for _, story := range m.Stories {
+			endian.PutUint64(b, uint64(story))
+			buf.Write(b)
+		}
 	}
 
 	return fmt.Sprintf("%x", sha256.Sum256(buf.Bytes()))
@@ -6,8 +6,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gotd/td/tg"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/tmessage"
 )
 
 // TestIterDeletedMessageHandling verifies that deleted messages are handled correctly
@@ -229,3 +232,27 @@ func TestIterContextCancellation(t *testing.T) {
 		}
 	})
 }
+
+func TestItemPos(t *testing.T) {
+	seen := make(map[int]struct{})
+	for pos := 0; pos < 100; pos++ {
+		assert.Equal(t, pos, itemPos(pos, 0), "first item keeps position of message")
+
+		for idx := 0; idx < maxItems; idx++ {
+			p := itemPos(pos, idx)
+			_, ok := seen[p]
+			require.False(t, ok, "position %d of item %d/%d is duplicated", p, pos, idx)
+			seen[p] = struct{}{}
+		}
+	}
+}
+
+func TestFingerprintStories(t *testing.T) {
+	messages := []*tmessage.Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: []int{1, 2}}}
+	withStories := []*tmessage.Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: []int{1, 2}, Stories: []int{1}}}
+	onlyStories := []*tmessage.Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Stories: []int{1, 2}}}
+
+	assert.NotEqual(t, fingerprint(messages), fingerprint(withStories))
+	assert.NotEqual(t, fingerprint(messages), fingerprint(onlyStories))
+	assert.Equal(t, fingerprint(messages), fingerprint([]*tmessage.Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: []int{1, 2}}}))
+}
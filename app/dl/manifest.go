@@ -0,0 +1,147 @@
+package dl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-faster/errors"
+)
+
+const manifestName = "SHA256SUMS"
+
+// manifest maintains SHA256SUMS file in each download directory,
+// which is compatible with `sha256sum -c` to be checked offline.
+type manifest struct {
+	mu   *sync.Mutex
+	dirs map[string]*manifestDir
+}
+
+// manifestDir is the loaded manifest of one directory
+type manifestDir struct {
+	path    string
+	entries [][2]string
+	index   map[string]int // name -> index of entries
+	rewrite bool           // existing file has no trailing newline, so it can't be appended directly
+}
+
+func newManifest() *manifest {
+	return &manifest{
+		mu:   &sync.Mutex{},
+		dirs: make(map[string]*manifestDir),
+	}
+}
+
+// record sets the checksum of file in the manifest of its directory, existing entry of the same file is replaced.
+// New entries are appended, and the file is only rewritten atomically if an entry is replaced.
+func (m *manifest) record(path, sum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, err := m.dir(filepath.Dir(path))
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+
+	name := filepath.Base(path)
+	if i, ok := d.index[name]; ok {
+		if d.entries[i][0] == sum {
+			return nil
+		}
+		d.entries[i][0] = sum
+		return d.flush()
+	}
+
+	d.index[name] = len(d.entries)
+	d.entries = append(d.entries, [2]string{sum, name})
+	if d.rewrite {
+		return d.flush()
+	}
+
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(f, "%s  %s\n", sum, name); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dir returns the manifest of the directory, which is read from disk only once
+func (m *manifest) dir(dir string) (*manifestDir, error) {
+	if d, ok := m.dirs[dir]; ok {
+		return d, nil
+	}
+
+	d := &manifestDir{path: filepath.Join(dir, manifestName), index: make(map[string]int)}
+
+	b, err := os.ReadFile(d.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if d.entries, err = parseManifest(b); err != nil {
+		return nil, err
+	}
+	for i, e := range d.entries {
+		d.index[e[1]] = i
+	}
+	d.rewrite = len(b) > 0 && b[len(b)-1] != '\n'
+
+	m.dirs[dir] = d
+	return d, nil
+}
+
+// flush writes all entries into temp file and renames it over the manifest,
+// so a crash never leaves a partially written manifest
+func (d *manifestDir) flush() error {
+	buf := &bytes.Buffer{}
+	for _, e := range d.entries {
+		fmt.Fprintf(buf, "%s  %s\n", e[0], e[1])
+	}
+
+	tmp := d.path + tempExt
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	d.rewrite = false
+	return nil
+}
+
+// readManifest returns [sum, name] pairs in order. Missing manifest is treated as empty.
+func readManifest(path string) ([][2]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return parseManifest(b)
+}
+
+func parseManifest(b []byte) ([][2]string, error) {
+	entries := make([][2]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// format: <sum><space><space or '*'><name>
+		sum, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || len(name) < 2 {
+			continue
+		}
+		entries = append(entries, [2]string{sum, name[1:]})
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,59 @@
+package dl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestRecord(t *testing.T) {
+	dir := t.TempDir()
+	m := newManifest()
+
+	require.NoError(t, m.record(filepath.Join(dir, "a.jpg"), "aaa"))
+	require.NoError(t, m.record(filepath.Join(dir, "b c.mp4"), "bbb"))
+	// replace existing entry
+	require.NoError(t, m.record(filepath.Join(dir, "a.jpg"), "ccc"))
+
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	require.NoError(t, err)
+	assert.Equal(t, "ccc  a.jpg\nbbb  b c.mp4\n", string(b))
+
+	// each directory has its own manifest
+	sub := filepath.Join(dir, "sub")
+	require.NoError(t, os.Mkdir(sub, 0o755))
+	require.NoError(t, m.record(filepath.Join(sub, "d.png"), "ddd"))
+
+	entries, err := readManifest(filepath.Join(sub, manifestName))
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{"ddd", "d.png"}}, entries)
+}
+
+func TestReadManifestNotExist(t *testing.T) {
+	entries, err := readManifest(filepath.Join(t.TempDir(), manifestName))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestManifestExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, manifestName)
+	// manifest written by other tools may have no trailing newline
+	require.NoError(t, os.WriteFile(path, []byte("aaa *a.jpg"), 0o644))
+
+	m := newManifest()
+	require.NoError(t, m.record(filepath.Join(dir, "b.jpg"), "bbb"))
+	require.NoError(t, m.record(filepath.Join(dir, "c.jpg"), "ccc"))
+	// same checksum doesn't touch the file
+	require.NoError(t, m.record(filepath.Join(dir, "c.jpg"), "ccc"))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "aaa  a.jpg\nbbb  b.jpg\nccc  c.jpg\n", string(b))
+
+	_, err = os.Stat(path + tempExt)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,78 @@
+package dl
+
+import (
+	"sync"
+)
+
+// partial records completed parts of an unfinished temp file, used for byte-level resume
+type partial struct {
+	Path  string             `json:"path"`
+	Size  int64              `json:"size"`
+	Parts map[int64]struct{} `json:"parts"` // offsets of completed parts
+
+	mu *sync.Mutex
+}
+
+func newPartial(path string, size int64) *partial {
+	return &partial{
+		Path:  path,
+		Size:  size,
+		Parts: make(map[int64]struct{}),
+		mu:    &sync.Mutex{},
+	}
+}
+
+// init fields which are not restored by json.Unmarshal
+func (p *partial) init() {
+	if p.Parts == nil {
+		p.Parts = make(map[int64]struct{})
+	}
+	p.mu = &sync.Mutex{}
+}
+
+func (p *partial) completed(offset int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.Parts[offset]
+	return ok
+}
+
+func (p *partial) complete(offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Parts[offset] = struct{}{}
+}
+
+func (p *partial) empty() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.Parts) == 0
+}
+
+// reset forgets all completed parts, so the file will be downloaded from the beginning
+func (p *partial) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Parts = make(map[int64]struct{})
+}
+
+// snapshot returns a copy which is safe to marshal while downloading
+func (p *partial) snapshot() *partial {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parts := make(map[int64]struct{}, len(p.Parts))
+	for offset := range p.Parts {
+		parts[offset] = struct{}{}
+	}
+
+	return &partial{
+		Path:  p.Path,
+		Size:  p.Size,
+		Parts: parts,
+	}
+}
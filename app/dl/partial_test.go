@@ -0,0 +1,33 @@
+package dl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialRoundTrip(t *testing.T) {
+	p := newPartial("downloads/a.mp4.tmp", 3<<20)
+	assert.True(t, p.empty())
+
+	p.complete(0)
+	p.complete(2 << 20)
+	assert.False(t, p.empty())
+
+	b, err := json.Marshal(map[int]*partial{7: p.snapshot()})
+	require.NoError(t, err)
+
+	restored := make(map[int]*partial)
+	require.NoError(t, json.Unmarshal(b, &restored))
+	require.Contains(t, restored, 7)
+
+	r := restored[7]
+	r.init()
+	assert.Equal(t, p.Path, r.Path)
+	assert.Equal(t, p.Size, r.Size)
+	assert.True(t, r.completed(0))
+	assert.False(t, r.completed(1<<20))
+	assert.True(t, r.completed(2<<20))
+}
@@ -2,24 +2,18 @@ package dl
 
 import (
 	"context"
-	stdErrors "errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/go-faster/errors"
 	pw "github.com/jedib0t/go-pretty/v6/progress"
 
 	"github.com/iyear/tdl/core/downloader"
-	"github.com/iyear/tdl/core/util/fsutil"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
@@ -27,22 +21,33 @@ type progress struct {
 	pw       pw.Writer
 	trackers *sync.Map // map[ID]*pw.Tracker
 	opts     Options
+	manifest *manifest
+	report   *report.Reporter
 
 	it *iter
 }
 
-func newProgress(p pw.Writer, it *iter, opts Options) *progress {
+func newProgress(p pw.Writer, it *iter, opts Options, r *report.Reporter) *progress {
 	return &progress{
 		pw:       p,
 		trackers: &sync.Map{},
 		opts:     opts,
+		manifest: newManifest(),
+		report:   r,
 		it:       it,
 	}
 }
 
 func (p *progress) OnAdd(elem downloader.Elem) {
+	e := elem.(*iterElem)
+
 	tracker := prog.AppendTracker(p.pw, utils.Byte.FormatBinaryBytes, p.processMessage(elem), elem.File().Size())
-	p.trackers.Store(elem.(*iterElem).id, tracker)
+	p.trackers.Store(e.id, tracker)
+
+	p.report.Start(e.id, report.Entry{
+		From:    &report.Peer{ID: e.from.ID(), Name: e.from.VisibleName()},
+		Message: e.fromMsg.ID,
+	})
 }
 
 func (p *progress) OnDownload(elem downloader.Elem, state downloader.ProgressState) {
@@ -65,62 +70,112 @@ func (p *progress) OnDone(elem downloader.Elem, err error) {
 	}
 	t := tracker.(*pw.Tracker)
 
-	// Optional: ensure any buffered data is flushed to disk before closing/renaming.
-	// Ignore error here; Close() will surface issues too.
-	_ = e.to.Sync()
+	path, err := p.done(e, err)
+	p.report.Done(e.id, err, func(entry *report.Entry) {
+		entry.Path = path
+		entry.Bytes = t.Value()
+	})
 
-	if err := e.to.Close(); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "close file"))
-		return
+	if err != nil && !errors.Is(err, context.Canceled) { // don't report user cancel
+		p.fail(t, elem, err)
 	}
+}
 
+// done commits the file to sink, and returns its final path
+func (p *progress) done(e *iterElem, err error) (string, error) {
 	if err != nil {
-		if !errors.Is(err, context.Canceled) { // don't report user cancel
-			p.fail(t, elem, errors.Wrap(err, "progress"))
+		// written parts can't be trusted, download the whole file again in the next run
+		if errors.Is(err, downloader.ErrVerify) {
+			e.partial.reset()
 		}
-		_ = os.Remove(e.to.Name()) // just try to remove temp file, ignore error
-		return
+		// keep partially downloaded temp file, so it can be resumed in the next run
+		if derr := e.to.Discard(p.it.resumable() && !e.partial.empty()); derr != nil {
+			// parts may not be on disk, don't resume from them
+			e.partial.reset()
+			return "", derr
+		}
+		return "", errors.Wrap(err, "progress")
+	}
+
+	var modTime time.Time
+	if e.file.Date > 0 { // set file modification time to message date if available
+		modTime = time.Unix(e.file.Date, 0)
+	}
+
+	path, err := e.to.Commit(context.TODO(), modTime)
+	if err != nil {
+		return "", errors.Wrap(err, "commit file")
 	}
 
 	p.it.Finish(e.logicalPos)
 
-	if err := p.donePost(e); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "post file"))
-		return
+	path, err = p.donePost(e, path)
+	if err != nil {
+		return "", errors.Wrap(err, "post file")
 	}
+
+	return path, nil
 }
 
-func (p *progress) donePost(elem *iterElem) error {
-	newfile := strings.TrimSuffix(filepath.Base(elem.to.Name()), tempExt)
+// donePost returns the final path of file, which may be another existing file if deduplicated
+func (p *progress) donePost(elem *iterElem, path string) (string, error) {
+	// file is not on local filesystem, so it can't be hashed
+	if _, ok := p.it.sink.(*dirSink); !ok {
+		return path, nil
+	}
 
-	if p.opts.RewriteExt {
-		mime, err := mimetype.DetectFile(elem.to.Name())
-		if err != nil {
-			return errors.Wrap(err, "detect mime")
+	if !p.it.dedup.enabled() && !p.opts.Verify {
+		return path, nil
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", errors.Wrap(err, "hash file")
+	}
+
+	if p.it.dedup.enabled() {
+		if path, err = p.dedupPost(elem, path, sum); err != nil {
+			return "", errors.Wrap(err, "dedup")
 		}
-		ext := mime.Extension()
-		if ext != "" && (filepath.Ext(newfile) != ext) {
-			newfile = fsutil.GetNameWithoutExt(newfile) + ext
+	}
+
+	if p.opts.Verify {
+		if err = p.manifest.record(path, sum); err != nil {
+			return "", errors.Wrap(err, "record checksum")
 		}
 	}
 
-	newpath := filepath.Join(filepath.Dir(elem.to.Name()), newfile)
+	return path, nil
+}
+
+// dedupPost checks if the same content has been downloaded before, then indexes the file.
+// The returned path is the existing file if the duplicated one is removed.
+func (p *progress) dedupPost(elem *iterElem, path, sum string) (string, error) {
+	ctx, d := context.TODO(), p.it.dedup
 
-	// Windows can temporarily lock files (Defender/AV/Indexer/Explorer preview).
-	// Retry rename to avoid failing the download at the final step.
-	if err := renameWithRetry(elem.to.Name(), newpath); err != nil {
-		return errors.Wrap(err, "rename file")
+	existing, ok, err := d.lookupHash(ctx, sum, elem.file.Size)
+	if err != nil {
+		return "", errors.Wrap(err, "lookup index")
+	}
+	if !ok {
+		return path, d.record(ctx, elem.file.InputFileLoc, sum, path)
 	}
 
-	// Set file modification time to message date if available
-	if elem.file.Date > 0 {
-		fileTime := time.Unix(elem.file.Date, 0)
-		if err := os.Chtimes(newpath, fileTime, fileTime); err != nil {
-			return errors.Wrap(err, "set file time")
+	if same, err := samePath(existing, path); err != nil || same {
+		return path, err
+	}
+
+	// the same content is downloaded under another file id, drop the duplicated one
+	if d.mode == DedupSkip {
+		if err = os.Remove(path); err != nil {
+			return "", errors.Wrap(err, "remove duplicated file")
 		}
+		path = existing
+	} else if err = d.apply(existing, path); err != nil {
+		return "", errors.Wrap(err, "link duplicated file")
 	}
 
-	return nil
+	return path, d.record(ctx, elem.file.InputFileLoc, sum, existing)
 }
 
 func (p *progress) fail(t *pw.Tracker, elem downloader.Elem, err error) {
@@ -138,65 +193,5 @@ func (p *progress) elemString(elem downloader.Elem) string {
 		e.from.VisibleName(),
 		e.from.ID(),
 		e.fromMsg.ID,
-		strings.TrimSuffix(e.to.Name(), tempExt))
-}
-
-func renameWithRetry(oldpath, newpath string) error {
-	const (
-		// On some Windows machines (heavy AV/Defender, slow disks, etc.),
-		// the temp file or destination can stay locked for quite a while
-		// after we close our own handle. A small retry window (~9s) is
-		// often not enough for large media files, which leads to
-		// "post file: rename file" errors and forces a re-download.
-		//
-		// We therefore allow a much longer retry window here on Windows
-		// (attempts * delay), while still bailing out quickly on other
-		// platforms or non-lock related errors.
-		attempts = 2000
-		delay    = 100 * time.Millisecond
-	)
-
-	var err error
-	for i := 0; i < attempts; i++ {
-		err = os.Rename(oldpath, newpath)
-		if err == nil {
-			return nil
-		}
-
-		// Only retry transient Windows locking errors.
-		if runtime.GOOS != "windows" || !isWindowsFileLockError(err) {
-			return err
-		}
-
-		time.Sleep(delay)
-	}
-	return err
-}
-
-func isWindowsFileLockError(err error) bool {
-	// Numeric errno values so this compiles cross-platform.
-	// 5  = Access is denied
-	// 32 = Sharing violation
-	// 33 = Lock violation
-	const (
-		winAccessDenied     syscall.Errno = 5
-		winSharingViolation syscall.Errno = 32
-		winLockViolation    syscall.Errno = 33
-	)
-
-	for err != nil {
-		if pe, ok := err.(*os.PathError); ok {
-			err = pe.Err
-			continue
-		}
-
-		if errno, ok := err.(syscall.Errno); ok {
-			return errno == winAccessDenied ||
-				errno == winSharingViolation ||
-				errno == winLockViolation
-		}
-
-		err = stdErrors.Unwrap(err)
-	}
-	return false
+		e.to.Name())
 }
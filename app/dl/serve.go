@@ -1,19 +1,20 @@
 package dl
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
-	"fmt"
+	"encoding/json"
 	"html/template"
+	stdmime "mime"
+	"net"
 	"net/http"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/go-faster/errors"
 	"github.com/gorilla/mux"
-	"github.com/gotd/contrib/http_io"
 	"github.com/gotd/contrib/partio"
 	"github.com/gotd/contrib/tg_io"
 	"github.com/gotd/td/telegram/peers"
@@ -26,112 +27,449 @@ import (
 	"github.com/iyear/tdl/core/tmedia"
 	"github.com/iyear/tdl/core/util/tutil"
 	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
 	"github.com/iyear/tdl/pkg/tmessage"
 )
 
 type media struct {
 	*tmedia.Media
-	MIME string
+	MIME  string
+	Thumb *tmedia.Media // nil if the media has no thumbnail
 }
 
+// item is the element of index page and listing API
+type item struct {
+	Peer    int64  `json:"peer"`
+	Message int    `json:"message"` // story id if Story is set
+	Story   bool   `json:"story,omitempty"`
+	Index   int    `json:"index"` // index of media in message, e.g. items of paid media
+	URL     string `json:"url"`
+	Thumb   string `json:"thumb"`
+	Play    string `json:"play"`
+
+	// resolved fields, only filled by listing API
+	Name  string `json:"name,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+	MIME  string `json:"mime,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	cacheSize = 1024
+	// file references expire after a while, so resolved media should be refreshed
+	cacheTTL = time.Hour
+
+	listLimit = 100 // max items of a listing page
+)
+
 //go:embed serve.go.tmpl
 var tmpl string
 
+var templates = template.Must(template.New("serve").Parse(tmpl))
+
+type server struct {
+	ctx     context.Context
+	pool    dcpool.Pool
+	manager *peers.Manager
+	takeout bool
+	inline  bool
+	cache   *mediaCache
+	auth    *auth
+}
+
 func serve(ctx context.Context,
 	kvd storage.Storage,
 	pool dcpool.Pool,
 	dialogs [][]*tmessage.Dialog,
-	port int,
-	takeout bool,
+	opts Options,
 ) error {
-	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
+	a, err := newAuth(opts)
+	if err != nil {
+		return errors.Wrap(err, "init auth")
+	}
+
+	s := &server{
+		ctx:     ctx,
+		pool:    pool,
+		manager: peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx)),
+		takeout: opts.Takeout,
+		inline:  opts.Inline,
+		cache:   newMediaCache(cacheSize, cacheTTL),
+		auth:    a,
+	}
+
+	items := make([]item, 0)
+	for _, dialog := range dialogs {
+		for _, d := range dialog {
+			peer := tutil.GetInputPeerID(d.Peer)
+			for _, m := range d.Messages {
+				items = append(items, newItem(peer, m, false, 0))
+			}
+			for _, st := range d.Stories {
+				items = append(items, newItem(peer, st, true, 0))
+			}
+		}
+	}
 
 	router := mux.NewRouter()
 
-	cache := &sync.Map{} // map[string]*media
-	router.Handle("/{peer}/{message:[0-9]+}", handler(func(w http.ResponseWriter, r *http.Request) error {
-		vars := mux.Vars(r)
-		peer := vars["peer"]
-		messageStr := vars["message"]
-
-		var item *media
-		if t, ok := cache.Load(peer + messageStr); ok {
-			item = t.(*media)
-		} else {
-			message, err := strconv.Atoi(messageStr)
-			if err != nil {
-				return errors.Wrap(err, "invalid message id")
+	file := handler(func(w http.ResponseWriter, r *http.Request) error {
+		m, err := s.resolve(mux.Vars(r))
+		if err != nil {
+			return err
+		}
+
+		disposition := "attachment"
+		if s.inline {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Disposition", stdmime.FormatMediaType(disposition, map[string]string{"filename": m.Name}))
+
+		s.serveMedia(w, r, m.Media, m.MIME)
+		return nil
+	})
+
+	thumb := handler(func(w http.ResponseWriter, r *http.Request) error {
+		m, err := s.resolve(mux.Vars(r))
+		if err != nil {
+			return err
+		}
+		if m.Thumb == nil {
+			http.NotFound(w, r)
+			return nil
+		}
+
+		// thumbnails rarely change
+		w.Header().Set("Cache-Control", "max-age=86400")
+		s.serveMedia(w, r, m.Thumb, "image/jpeg")
+		return nil
+	})
+
+	play := handler(func(w http.ResponseWriter, r *http.Request) error {
+		m, err := s.resolve(mux.Vars(r))
+		if err != nil {
+			return err
+		}
+
+		// players can't carry credentials of bearer token, so links are signed
+		src := strings.TrimSuffix(r.URL.Path, "/play")
+		return templates.ExecuteTemplate(w, "play", map[string]string{
+			"Name":   m.Name,
+			"Src":    s.auth.sign(src),
+			"Poster": s.auth.sign(src + "/thumb"),
+			"Kind":   mediaKind(m.MIME),
+		})
+	})
+
+	// media of message or story, and the optional index selects other items of paid media
+	for _, prefix := range []string{
+		"/{peer}/{message:[0-9]+}",
+		"/{peer}/{message:[0-9]+}/{index:[0-9]+}",
+		"/{peer}/s/{story:[0-9]+}",
+		"/{peer}/s/{story:[0-9]+}/{index:[0-9]+}",
+	} {
+		router.Handle(prefix, file).Methods(http.MethodGet, http.MethodHead)
+		router.Handle(prefix+"/thumb", thumb).Methods(http.MethodGet, http.MethodHead)
+		router.Handle(prefix+"/play", play).Methods(http.MethodGet)
+	}
+
+	router.Handle("/api/items", handler(func(w http.ResponseWriter, r *http.Request) error {
+		offset, limit, err := parsePage(r, len(items))
+		if err != nil {
+			return err
+		}
+
+		medias, errs := s.resolveItems(items[offset : offset+limit])
+
+		// each media of message is listed, so page may contain more items than limit
+		page := make([]item, 0, limit)
+		for _, it := range items[offset : offset+limit] {
+			if err, ok := errs[it.key()]; ok {
+				it.Error = err.Error()
+				page = append(page, s.signItem(it))
+				continue
 			}
 
-			p, err := tutil.GetInputPeer(ctx, manager, peer)
-			if err != nil {
-				return errors.Wrap(err, "resolve peer")
+			for idx, m := range medias[it.key()] {
+				u := newItem(it.Peer, it.Message, it.Story, idx)
+				u.Name, u.Size, u.MIME = m.Name, m.Size, m.MIME
+				if m.Thumb == nil {
+					u.Thumb = ""
+				}
+				page = append(page, s.signItem(u))
 			}
+		}
 
-			msg, err := tutil.GetSingleMessage(ctx, pool.Default(ctx), p.InputPeer(), message)
-			if err != nil {
-				return errors.Wrap(err, "resolve message")
+		writeJSON(w, map[string]any{
+			"total": len(items),
+			"items": page,
+		})
+		return nil
+	})).Methods(http.MethodGet)
+
+	router.Handle("/", handler(func(w http.ResponseWriter, r *http.Request) error {
+		signed := make([]item, 0, len(items))
+		for _, it := range items {
+			signed = append(signed, s.signItem(it))
+		}
+		return templates.ExecuteTemplate(w, "index", signed)
+	}))
+
+	return listenAndServe(ctx, s.auth.middleware(router), s.auth, opts)
+}
+
+// listenAndServe serves h on the bind address and port of options, with TLS if cert files are set
+func listenAndServe(ctx context.Context, h http.Handler, a *auth, opts Options) error {
+	srv := http.Server{
+		Addr:              net.JoinHostPort(opts.Bind, strconv.Itoa(opts.Port)),
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	host := opts.Bind
+	if host == "" {
+		host = "localhost"
+	}
+	tls := opts.TLSCert != "" && opts.TLSKey != ""
+	if !tls && a.enabled() {
+		color.Yellow("WARN: Credentials are sent in plain text without TLS")
+	}
+
+	if tls {
+		color.Green("(Beta) Serving on https://%s", net.JoinHostPort(host, strconv.Itoa(opts.Port)))
+		return srv.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+	}
+
+	color.Green("(Beta) Serving on http://%s", net.JoinHostPort(host, strconv.Itoa(opts.Port)))
+	return srv.ListenAndServe()
+}
+
+// signItem signs links of media and thumbnail, so they can be opened without credentials
+func (s *server) signItem(it item) item {
+	it.URL = s.auth.sign(it.URL)
+	if it.Thumb != "" {
+		it.Thumb = s.auth.sign(it.Thumb)
+	}
+	return it
+}
+
+// newItem returns the item of index-th media of message or story
+func newItem(peer int64, id int, story bool, index int) item {
+	path := "/" + mediaKey(strconv.FormatInt(peer, 10), id, story)
+	if index > 0 {
+		path += "/" + strconv.Itoa(index)
+	}
+
+	return item{
+		Peer:    peer,
+		Message: id,
+		Story:   story,
+		Index:   index,
+		URL:     path,
+		Thumb:   path + "/thumb",
+		Play:    path + "/play",
+	}
+}
+
+// key returns the cache key of message or story of item
+func (it item) key() string {
+	return mediaKey(strconv.FormatInt(it.Peer, 10), it.Message, it.Story)
+}
+
+// mediaKey is the cache key of media of message or story, which is also the path prefix of its links
+func mediaKey(peer string, id int, story bool) string {
+	if story {
+		return peer + "/s/" + strconv.Itoa(id)
+	}
+	return peer + "/" + strconv.Itoa(id)
+}
+
+// resolveItems resolves media of items with cache, and uncached messages are fetched in batches.
+// Both results are keyed by item key.
+func (s *server) resolveItems(items []item) (map[string][]*media, map[string]error) {
+	type source struct {
+		peer  int64
+		story bool
+	}
+
+	medias, errs := make(map[string][]*media), make(map[string]error)
+
+	missing := make(map[source][]int) // uncached message or story ids of each peer
+	for _, it := range items {
+		key := it.key()
+		if m, ok := s.cache.get(key); ok {
+			medias[key] = m
+			continue
+		}
+		src := source{peer: it.Peer, story: it.Story}
+		missing[src] = append(missing[src], it.Message)
+	}
+
+	for src, ids := range missing {
+		peer := strconv.FormatInt(src.peer, 10)
+
+		msgs, err := s.fetchMessages(peer, ids, src.story)
+		if err != nil {
+			for _, id := range ids {
+				errs[mediaKey(peer, id, src.story)] = err
 			}
+			continue
+		}
 
-			item, err = convItem(msg)
+		for _, id := range ids {
+			key := mediaKey(peer, id, src.story)
+
+			msg, ok := msgs[id]
+			if !ok {
+				errs[key] = errors.Errorf("resolve message: %s may be deleted or expired", key)
+				continue
+			}
+
+			m, err := convItems(msg)
 			if err != nil {
-				return errors.Wrap(err, "convItem")
+				errs[key] = errors.Wrap(err, "convItems")
+				continue
 			}
 
-			cache.Store(peer+messageStr, item)
+			s.cache.set(key, m)
+			medias[key] = m
 		}
+	}
 
-		api := pool.Client(ctx, item.DC)
-		if takeout {
-			api = pool.Takeout(ctx, item.DC)
-		}
+	return medias, errs
+}
 
-		u := partio.NewStreamer(
-			tg_io.NewDownloader(api).ChunkSource(item.Size, item.InputFileLoc),
-			int64(viper.GetInt(consts.FlagPartSize)))
+// fetchMessages fetches messages or stories of the peer. Deleted or expired ones are not included in the result.
+func (s *server) fetchMessages(peer string, ids []int, story bool) (map[int]*tg.Message, error) {
+	p, err := tutil.GetInputPeer(s.ctx, s.manager, peer)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve peer")
+	}
 
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, item.Name))
+	api := s.pool.Default(s.ctx)
+	if story {
+		stories, err := tutil.GetStories(s.ctx, api, p.InputPeer(), ids)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolve stories")
+		}
+		return stories, nil
+	}
 
-		http_io.NewHandler(u, item.Size).
-			WithContentType(item.MIME).
-			WithLog(logctx.From(ctx).Named("serve")).
-			ServeHTTP(w, r)
-		return nil
-	}))
+	msgs, err := tutil.GetMessages(s.ctx, api, p.InputPeer(), ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve messages")
+	}
 
-	items := make([]string, 0)
-	for _, dialog := range dialogs {
-		for _, d := range dialog {
-			for _, m := range d.Messages {
-				items = append(items, fmt.Sprintf("%d/%d", tutil.GetInputPeerID(d.Peer), m))
-			}
+	// forwarded stories only carry the story id
+	for id, msg := range msgs {
+		ok, err := tutil.FillStory(s.ctx, api, s.manager, msg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve story of message %d", id)
+		}
+		if !ok {
+			delete(msgs, id)
 		}
 	}
+	return msgs, nil
+}
 
-	list := bytes.NewBuffer(nil)
-	err := template.Must(template.New("serve.go.tmpl").Parse(tmpl)).Execute(list, items)
+// resolve returns media of the message or story in route vars,
+// which is cached to avoid resolving for each range request
+func (s *server) resolve(vars map[string]string) (*media, error) {
+	idStr, story := vars["message"], false
+	if v, ok := vars["story"]; ok {
+		idStr, story = v, true
+	}
+
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return errors.Wrap(err, "execute template")
+		return nil, errors.Wrap(err, "invalid message id")
 	}
 
-	router.Handle("/", handler(func(w http.ResponseWriter, r *http.Request) error {
-		_, err := fmt.Fprint(w, list.String())
-		return err
-	}))
+	index := 0
+	if v, ok := vars["index"]; ok {
+		if index, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Wrap(err, "invalid media index")
+		}
+	}
+
+	key := mediaKey(vars["peer"], id, story)
+	medias, ok := s.cache.get(key)
+	if !ok {
+		msgs, err := s.fetchMessages(vars["peer"], []int{id}, story)
+		if err != nil {
+			return nil, err
+		}
 
-	s := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: router,
+		msg, ok := msgs[id]
+		if !ok {
+			return nil, errors.Errorf("resolve message: %s may be deleted or expired", key)
+		}
+
+		if medias, err = convItems(msg); err != nil {
+			return nil, errors.Wrap(err, "convItems")
+		}
+		s.cache.set(key, medias)
 	}
 
-	go func() {
-		<-ctx.Done()
-		_ = s.Shutdown(ctx)
-	}()
+	if index >= len(medias) {
+		return nil, errors.Errorf("media index %d out of range, %s has %d media", index, key, len(medias))
+	}
+	return medias[index], nil
+}
+
+func (s *server) serveMedia(w http.ResponseWriter, r *http.Request, m *tmedia.Media, mime string) {
+	api := s.pool.Client(s.ctx, m.DC)
+	if s.takeout {
+		api = s.pool.Takeout(s.ctx, m.DC)
+	}
+
+	u := partio.NewStreamer(
+		tg_io.NewDownloader(api).ChunkSource(m.Size, m.InputFileLoc),
+		int64(viper.GetInt(consts.FlagPartSize)))
+
+	serveContent(metrics.CountWriter(w, metrics.KindServe), r, u, m.Size, mime, logctx.From(s.ctx).Named("serve"))
+}
 
-	color.Green("(Beta) Serving on http://localhost:%d", port)
+// parsePage parses 'offset' and 'limit' query of listing API, and clamps them into [0, total]
+func parsePage(r *http.Request, total int) (offset, limit int, _ error) {
+	offset, limit = 0, listLimit
 
-	return s.ListenAndServe()
+	var err error
+	q := r.URL.Query()
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errors.Errorf("invalid offset: %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, errors.Errorf("invalid limit: %q", v)
+		}
+	}
+
+	offset = min(offset, total)
+	limit = min(limit, listLimit, total-offset)
+	return offset, limit, nil
+}
+
+// mediaKind returns the HTML5 element to play the MIME type
+func mediaKind(mime string) string {
+	switch {
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	case strings.HasPrefix(mime, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mime, "image/"):
+		return "image"
+	default:
+		return ""
+	}
 }
 
 func handler(h func(w http.ResponseWriter, r *http.Request) error) http.Handler {
@@ -142,26 +480,53 @@ func handler(h func(w http.ResponseWriter, r *http.Request) error) http.Handler
 	})
 }
 
-func convItem(msg *tg.Message) (*media, error) {
-	md, ok := tmedia.GetMedia(msg)
-	if !ok {
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// convItems converts all media of message, which may be unwrapped from story, paid media or web page
+func convItems(msg *tg.Message) ([]*media, error) {
+	units := tmedia.Flatten(msg.Media)
+
+	medias := make([]*media, 0, len(units))
+	for _, u := range units {
+		if m, ok := convMedia(u); ok {
+			medias = append(medias, m)
+		}
+	}
+	if len(medias) == 0 {
 		return nil, errors.New("message is not a media")
 	}
 
+	return medias, nil
+}
+
+// convMedia converts a flattened photo or document
+func convMedia(u tg.MessageMediaClass) (*media, bool) {
+	md, ok := tmedia.ExtractMedia(u)
+	if !ok {
+		return nil, false
+	}
+
 	mime := ""
-	switch m := msg.Media.(type) {
+	var thumb *tmedia.Media
+	switch m := u.(type) {
 	case *tg.MessageMediaDocument:
 		doc, ok := m.Document.AsNotEmpty()
 		if !ok {
-			return nil, errors.New("document is empty")
+			return nil, false
 		}
 		mime = doc.MimeType
+		thumb, _ = tmedia.GetDocumentThumb(doc)
 	case *tg.MessageMediaPhoto:
 		mime = "image/jpeg"
+		thumb, _ = tmedia.GetPhotoThumb(m)
 	}
 
 	return &media{
 		Media: md,
 		MIME:  mime,
-	}, nil
+		Thumb: thumb,
+	}, true
 }
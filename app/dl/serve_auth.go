@@ -0,0 +1,127 @@
+package dl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// auth protects the server with basic auth or bearer token. Links of media are signed with expiration,
+// so they can be opened by players and download managers without credentials.
+type auth struct {
+	user, password string
+	token          string
+
+	key    []byte // HMAC key of signed links
+	expire time.Duration
+	now    func() time.Time
+}
+
+func newAuth(opts Options) (*auth, error) {
+	a := &auth{
+		token:  opts.Token,
+		expire: opts.LinkExpire,
+		now:    time.Now,
+	}
+
+	if opts.Auth != "" {
+		user, password, ok := strings.Cut(opts.Auth, ":")
+		if !ok || user == "" {
+			return nil, errors.New("basic auth should be in 'user:password' format")
+		}
+		a.user, a.password = user, password
+	}
+
+	if opts.SignKey != "" {
+		a.key = []byte(opts.SignKey)
+	} else {
+		// links are valid until the server exits
+		a.key = make([]byte, 32)
+		if _, err := rand.Read(a.key); err != nil {
+			return nil, errors.Wrap(err, "generate sign key")
+		}
+	}
+
+	return a, nil
+}
+
+func (a *auth) enabled() bool {
+	return a.user != "" || a.token != ""
+}
+
+// sign returns path with expiration and signature query, or path itself if auth is disabled
+func (a *auth) sign(path string) string {
+	if !a.enabled() {
+		return path
+	}
+
+	expires := strconv.FormatInt(a.now().Add(a.expire).Unix(), 10)
+	return path + "?" + url.Values{
+		"expires": []string{expires},
+		"sig":     []string{a.signature(path, expires)},
+	}.Encode()
+}
+
+func (a *auth) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *auth) verifySigned(r *http.Request) bool {
+	q := r.URL.Query()
+	expires, sig := q.Get("expires"), q.Get("sig")
+	if expires == "" || sig == "" {
+		return false
+	}
+
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || a.now().Unix() > t {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(a.signature(r.URL.Path, expires)))
+}
+
+func (a *auth) verify(r *http.Request) bool {
+	if a.user != "" {
+		if user, password, ok := r.BasicAuth(); ok && equal(user, a.user) && equal(password, a.password) {
+			return true
+		}
+	}
+
+	if a.token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equal(token, a.token) {
+			return true
+		}
+	}
+
+	return a.verifySigned(r)
+}
+
+func (a *auth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.enabled() || a.verify(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.user != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tdl", charset="UTF-8"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,79 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/contrib/http_range"
+	"go.uber.org/zap"
+)
+
+// streamer streams content from the offset to the end, e.g. partio.Streamer
+type streamer interface {
+	StreamAt(ctx context.Context, skip int64, w io.Writer) error
+}
+
+var errRangeEnd = errors.New("range end")
+
+// limitWriter writes at most n bytes, then returns errRangeEnd
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= l.n {
+		n, err := l.w.Write(p)
+		l.n -= int64(n)
+		return n, err
+	}
+
+	n, err := l.w.Write(p[:l.n])
+	l.n -= int64(n)
+	if err == nil {
+		err = errRangeEnd
+	}
+	return n, err
+}
+
+// serveContent serves content with single range requests. Unlike http_io.Handler,
+// it stops at the end of range instead of streaming to the end of file,
+// which is required by browsers probing and seeking media, e.g. 'Range: bytes=0-1'.
+func serveContent(w http.ResponseWriter, r *http.Request, s streamer, size int64, mime string, log *zap.Logger) {
+	ranges, err := http_range.ParseRange(r.Header.Get("Range"), size)
+	if errors.Is(err, http_range.ErrNoOverlap) || len(ranges) > 1 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, offset, length := http.StatusOK, int64(0), size
+	if len(ranges) == 1 {
+		code, offset, length = http.StatusPartialContent, ranges[0].Start, ranges[0].Length
+		w.Header().Set("Content-Range", ranges[0].ContentRange(size))
+	}
+
+	if mime != "" {
+		w.Header().Set("Content-Type", mime)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(code)
+
+	if r.Method == http.MethodHead || length == 0 {
+		return
+	}
+
+	err = s.StreamAt(r.Context(), offset, &limitWriter{w: w, n: length})
+	if err != nil && !errors.Is(err, errRangeEnd) && !errors.Is(err, context.Canceled) {
+		log.Error("Failed to stream", zap.Int64("offset", offset), zap.Error(err))
+	}
+}
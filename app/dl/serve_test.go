@@ -0,0 +1,219 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// bytesStreamer streams content in small chunks like partio.Streamer
+type bytesStreamer []byte
+
+func (b bytesStreamer) StreamAt(_ context.Context, skip int64, w io.Writer) error {
+	for i := skip; i < int64(len(b)); i += 4 {
+		if _, err := w.Write(b[i:min(i+4, int64(len(b)))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestServeContent(t *testing.T) {
+	content := bytesStreamer("0123456789abcdef")
+
+	tests := []struct {
+		name    string
+		method  string
+		rng     string
+		code    int
+		body    string
+		headers map[string]string
+	}{
+		{name: "full", method: http.MethodGet, code: http.StatusOK, body: "0123456789abcdef",
+			headers: map[string]string{"Accept-Ranges": "bytes", "Content-Length": "16", "Content-Type": "video/mp4"}},
+		{name: "probe", method: http.MethodGet, rng: "bytes=0-1", code: http.StatusPartialContent, body: "01",
+			headers: map[string]string{"Content-Range": "bytes 0-1/16", "Content-Length": "2"}},
+		{name: "middle", method: http.MethodGet, rng: "bytes=5-9", code: http.StatusPartialContent, body: "56789",
+			headers: map[string]string{"Content-Range": "bytes 5-9/16"}},
+		{name: "open", method: http.MethodGet, rng: "bytes=10-", code: http.StatusPartialContent, body: "abcdef"},
+		{name: "suffix", method: http.MethodGet, rng: "bytes=-3", code: http.StatusPartialContent, body: "def"},
+		{name: "head", method: http.MethodHead, rng: "bytes=0-1", code: http.StatusPartialContent, body: "",
+			headers: map[string]string{"Content-Length": "2"}},
+		{name: "unsatisfiable", method: http.MethodGet, rng: "bytes=20-", code: http.StatusRequestedRangeNotSatisfiable,
+			headers: map[string]string{"Content-Range": "bytes */16"}},
+		{name: "multiple", method: http.MethodGet, rng: "bytes=0-1,3-4", code: http.StatusRequestedRangeNotSatisfiable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			w := httptest.NewRecorder()
+
+			serveContent(w, r, content, int64(len(content)), "video/mp4", zap.NewNop())
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code < http.StatusBadRequest {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+			for k, v := range tt.headers {
+				assert.Equal(t, v, w.Header().Get(k), k)
+			}
+		})
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &limitWriter{w: buf, n: 5}
+
+	n, err := w.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = w.Write([]byte("defg"))
+	assert.ErrorIs(t, err, errRangeEnd)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "abcde", buf.String())
+}
+
+func TestMediaCache(t *testing.T) {
+	c := newMediaCache(2, time.Hour)
+	a, b, d := []*media{{MIME: "a"}}, []*media{{MIME: "b"}}, []*media{{MIME: "d"}}
+
+	c.set("a", a)
+	c.set("b", b)
+
+	// a is the most recently used, so b is evicted
+	m, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, a, m)
+	c.set("d", d)
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	_, ok = c.get("d")
+	assert.True(t, ok)
+
+	expired := newMediaCache(2, 0)
+	expired.set("a", a)
+	time.Sleep(time.Millisecond)
+	_, ok = expired.get("a")
+	assert.False(t, ok)
+}
+
+func TestResolveItemsCached(t *testing.T) {
+	s := &server{cache: newMediaCache(cacheSize, cacheTTL)}
+	a, b := []*media{{MIME: "a"}, {MIME: "a1"}}, []*media{{MIME: "b"}}
+	s.cache.set(mediaKey("1", 10, false), a)
+	s.cache.set(mediaKey("2", 20, true), b)
+
+	// cached items are not fetched again, otherwise nil pool panics
+	medias, errs := s.resolveItems([]item{{Peer: 1, Message: 10}, {Peer: 2, Message: 20, Story: true}})
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string][]*media{"1/10": a, "2/s/20": b}, medias)
+}
+
+func TestNewItem(t *testing.T) {
+	tests := []struct {
+		story bool
+		index int
+		url   string
+	}{
+		{story: false, index: 0, url: "/1/10"},
+		{story: false, index: 2, url: "/1/10/2"},
+		{story: true, index: 0, url: "/1/s/10"},
+		{story: true, index: 1, url: "/1/s/10/1"},
+	}
+
+	for _, tt := range tests {
+		it := newItem(1, 10, tt.story, tt.index)
+		assert.Equal(t, tt.url, it.URL)
+		assert.Equal(t, tt.url+"/thumb", it.Thumb)
+		assert.Equal(t, tt.url+"/play", it.Play)
+		assert.Equal(t, tt.index, it.Index)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query         string
+		offset, limit int
+		err           bool
+	}{
+		{query: "", offset: 0, limit: 100},
+		{query: "offset=150", offset: 150, limit: 50},
+		{query: "offset=10&limit=5", offset: 10, limit: 5},
+		{query: "offset=300", offset: 200, limit: 0},
+		{query: "limit=1000", offset: 0, limit: 100},
+		{query: "limit=0", err: true},
+		{query: "offset=-1", err: true},
+	}
+
+	for _, tt := range tests {
+		offset, limit, err := parsePage(httptest.NewRequest(http.MethodGet, "/api/items?"+tt.query, nil), 200)
+		if tt.err {
+			assert.Error(t, err, tt.query)
+			continue
+		}
+		assert.NoError(t, err, tt.query)
+		assert.Equal(t, tt.offset, offset, tt.query)
+		assert.Equal(t, tt.limit, limit, tt.query)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a, err := newAuth(Options{Auth: "user:pass", Token: "token", SignKey: "key", LinkExpire: time.Hour})
+	require.NoError(t, err)
+	a.now = func() time.Time { return now }
+
+	h := a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(target string, f func(r *http.Request)) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if f != nil {
+			f(r)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("/1/2", nil))
+	assert.Equal(t, http.StatusOK, do("/1/2", func(r *http.Request) { r.SetBasicAuth("user", "pass") }))
+	assert.Equal(t, http.StatusUnauthorized, do("/1/2", func(r *http.Request) { r.SetBasicAuth("user", "wrong") }))
+	assert.Equal(t, http.StatusOK, do("/1/2", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }))
+	assert.Equal(t, http.StatusUnauthorized, do("/1/2", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }))
+
+	signed := a.sign("/1/2")
+	assert.Equal(t, http.StatusOK, do(signed, nil))
+	// signature is bound to the path
+	assert.Equal(t, http.StatusUnauthorized, do(strings.Replace(signed, "/1/2", "/1/3", 1), nil))
+
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, http.StatusUnauthorized, do(signed, nil))
+
+	_, err = newAuth(Options{Auth: "user"})
+	assert.Error(t, err)
+
+	// links are not signed without auth
+	a, err = newAuth(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "/1/2", a.sign("/1/2"))
+
+	h = a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusOK, do("/1/2", nil))
+}
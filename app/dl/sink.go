@@ -0,0 +1,356 @@
+package dl
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/go-faster/errors"
+	"go.uber.org/multierr"
+
+	"github.com/iyear/tdl/core/util/fsutil"
+	"github.com/iyear/tdl/pkg/prog"
+)
+
+// sink is the destination of downloaded files
+type sink interface {
+	// Create returns a new file of the relative name rendered by template
+	Create(name string, size int64) (sinkFile, error)
+	// String describes the destination to user
+	String() string
+	io.Closer
+}
+
+// resumableSink keeps partially written files across runs
+type resumableSink interface {
+	sink
+	// Reopen opens the partially written file of the relative name
+	Reopen(name string) (sinkFile, error)
+}
+
+type sinkFile interface {
+	io.WriterAt
+	// Name returns the final destination of file
+	Name() string
+	// Commit completes the written file with modification time, and returns its final path
+	Commit(ctx context.Context, modTime time.Time) (string, error)
+	// Discard drops the written file. The content is kept for resuming if keep is true and sink is resumable.
+	Discard(keep bool) error
+}
+
+// newSink parses the sink option:
+//
+//	""           files under --dir
+//	"-"/"stdout" concatenated files to stdout
+//	"tar:PATH"   tar archive, "-" means stdout
+//	"zip:PATH"   zip archive, "-" means stdout
+//	"s3://..."   S3-compatible bucket, see newS3Sink
+func newSink(opts Options) (sink, error) {
+	if opts.Sink == "" {
+		return &dirSink{dir: opts.Dir, rewriteExt: opts.RewriteExt}, nil
+	}
+
+	// streaming sinks can't look back at written files
+	if opts.SkipSame || opts.Dedup != DedupNone {
+		return nil, errors.New("skip-same and dedup are only available when downloading to directory")
+	}
+	// checksums are recorded in SHA256SUMS next to downloaded files
+	if opts.Verify {
+		return nil, errors.New("verify is only available when downloading to directory")
+	}
+
+	var (
+		p   putter
+		err error
+	)
+	switch kind, path, _ := strings.Cut(opts.Sink, ":"); {
+	case opts.Sink == "-" || opts.Sink == "stdout":
+		p = newStdoutPutter()
+	case kind == "tar":
+		p, err = newTarPutter(path)
+	case kind == "zip":
+		p, err = newZipPutter(path)
+	case kind == "s3":
+		p, err = newS3Sink(opts.Sink)
+	default:
+		return nil, errors.Errorf("unknown sink: %q", opts.Sink)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolSink{dir: opts.Dir, rewriteExt: opts.RewriteExt, putter: p}, nil
+}
+
+// SinkStdout reports whether the sink option writes to stdout
+func SinkStdout(s string) bool {
+	return s == "-" || s == "stdout" || s == "tar:-" || s == "zip:-"
+}
+
+// redirectOutput moves progress and messages to stderr if files are piped to stdout
+func redirectOutput(opts Options) {
+	if !SinkStdout(opts.Sink) {
+		return
+	}
+
+	color.Output = os.Stderr
+	prog.SetOutput(os.Stderr)
+}
+
+// dirSink writes files under the directory. Files are written to temp files first, then renamed when completed.
+type dirSink struct {
+	dir        string
+	rewriteExt bool
+}
+
+func (s *dirSink) Create(name string, size int64) (sinkFile, error) {
+	path := s.tempPath(name)
+
+	// #113. If path contains dirs, create it. So now we support nested dirs.
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create file")
+	}
+	return &dirFile{File: f, rewriteExt: s.rewriteExt}, nil
+}
+
+func (s *dirSink) Reopen(name string) (sinkFile, error) {
+	f, err := os.OpenFile(s.tempPath(name), os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{File: f, rewriteExt: s.rewriteExt}, nil
+}
+
+func (s *dirSink) tempPath(name string) string {
+	return filepath.Join(s.dir, name+tempExt)
+}
+
+func (s *dirSink) String() string { return fmt.Sprintf("'%s' dir", s.dir) }
+
+func (s *dirSink) Close() error { return nil }
+
+type dirFile struct {
+	*os.File
+	rewriteExt bool
+}
+
+func (f *dirFile) Name() string {
+	return strings.TrimSuffix(f.File.Name(), tempExt)
+}
+
+func (f *dirFile) close() error {
+	// Optional: ensure any buffered data is flushed to disk before closing/renaming.
+	// Ignore error here; Close() will surface issues too.
+	_ = f.Sync()
+
+	if err := f.File.Close(); err != nil {
+		return errors.Wrap(err, "close file")
+	}
+	return nil
+}
+
+func (f *dirFile) Commit(_ context.Context, modTime time.Time) (string, error) {
+	if err := f.close(); err != nil {
+		return "", err
+	}
+
+	newfile := filepath.Base(f.Name())
+	if f.rewriteExt {
+		mime, err := mimetype.DetectFile(f.File.Name())
+		if err != nil {
+			return "", errors.Wrap(err, "detect mime")
+		}
+		newfile = rewriteExt(newfile, mime)
+	}
+
+	newpath := filepath.Join(filepath.Dir(f.File.Name()), newfile)
+
+	// Windows can temporarily lock files (Defender/AV/Indexer/Explorer preview).
+	// Retry rename to avoid failing the download at the final step.
+	if err := renameWithRetry(f.File.Name(), newpath); err != nil {
+		return "", errors.Wrap(err, "rename file")
+	}
+
+	// Set file modification time to message date if available
+	if !modTime.IsZero() {
+		if err := os.Chtimes(newpath, modTime, modTime); err != nil {
+			return "", errors.Wrap(err, "set file time")
+		}
+	}
+
+	return newpath, nil
+}
+
+func (f *dirFile) Discard(keep bool) error {
+	// completed parts will be persisted as resume state, so they must reach the disk first
+	if keep {
+		if err := f.Sync(); err != nil {
+			_ = f.File.Close()
+			_ = os.Remove(f.File.Name())
+			return errors.Wrap(err, "sync file")
+		}
+	}
+
+	if err := f.close(); err != nil {
+		return err
+	}
+
+	if !keep {
+		_ = os.Remove(f.File.Name()) // just try to remove temp file, ignore error
+	}
+	return nil
+}
+
+// putter stores completed files, which are read from the beginning to the end
+type putter interface {
+	put(ctx context.Context, name string, r io.ReaderAt, size int64, modTime time.Time) error
+	// display returns the destination of name
+	display(name string) string
+	io.Closer
+}
+
+// spoolSink downloads files into temp files under the directory, because parts are written
+// out of order. Then completed files are put into the destination, and temp files are removed.
+type spoolSink struct {
+	dir        string
+	rewriteExt bool
+	putter     putter
+}
+
+func (s *spoolSink) Create(name string, size int64) (sinkFile, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+
+	f, err := os.CreateTemp(s.dir, "spool-*"+tempExt)
+	if err != nil {
+		return nil, errors.Wrap(err, "create spool file")
+	}
+
+	return &spoolFile{File: f, name: name, size: size, sink: s}, nil
+}
+
+func (s *spoolSink) String() string { return s.putter.display("") }
+
+func (s *spoolSink) Close() error { return s.putter.Close() }
+
+type spoolFile struct {
+	*os.File
+	name string
+	size int64
+	sink *spoolSink
+}
+
+func (f *spoolFile) Name() string { return f.sink.putter.display(f.name) }
+
+func (f *spoolFile) Commit(ctx context.Context, modTime time.Time) (_ string, rerr error) {
+	defer func() { multierr.AppendInto(&rerr, f.Discard(false)) }()
+
+	name := f.name
+	if f.sink.rewriteExt {
+		mime, err := mimetype.DetectReader(io.NewSectionReader(f.File, 0, f.size))
+		if err != nil {
+			return "", errors.Wrap(err, "detect mime")
+		}
+		name = filepath.Join(filepath.Dir(name), rewriteExt(filepath.Base(name), mime))
+	}
+
+	if err := f.sink.putter.put(ctx, filepath.ToSlash(name), f.File, f.size, modTime); err != nil {
+		return "", errors.Wrap(err, "put file")
+	}
+
+	return f.sink.putter.display(name), nil
+}
+
+func (f *spoolFile) Discard(bool) error {
+	if err := f.File.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return errors.Wrap(err, "close spool file")
+	}
+	if err := os.Remove(f.File.Name()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove spool file")
+	}
+	return nil
+}
+
+// rewriteExt replaces the extension of name with the one of detected MIME
+func rewriteExt(name string, mime *mimetype.MIME) string {
+	ext := mime.Extension()
+	if ext != "" && (filepath.Ext(name) != ext) {
+		return fsutil.GetNameWithoutExt(name) + ext
+	}
+	return name
+}
+
+func renameWithRetry(oldpath, newpath string) error {
+	const (
+		// On some Windows machines (heavy AV/Defender, slow disks, etc.),
+		// the temp file or destination can stay locked for quite a while
+		// after we close our own handle. A small retry window (~9s) is
+		// often not enough for large media files, which leads to
+		// "post file: rename file" errors and forces a re-download.
+		//
+		// We therefore allow a much longer retry window here on Windows
+		// (attempts * delay), while still bailing out quickly on other
+		// platforms or non-lock related errors.
+		attempts = 2000
+		delay    = 100 * time.Millisecond
+	)
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = os.Rename(oldpath, newpath)
+		if err == nil {
+			return nil
+		}
+
+		// Only retry transient Windows locking errors.
+		if runtime.GOOS != "windows" || !isWindowsFileLockError(err) {
+			return err
+		}
+
+		time.Sleep(delay)
+	}
+	return err
+}
+
+func isWindowsFileLockError(err error) bool {
+	// Numeric errno values so this compiles cross-platform.
+	// 5  = Access is denied
+	// 32 = Sharing violation
+	// 33 = Lock violation
+	const (
+		winAccessDenied     syscall.Errno = 5
+		winSharingViolation syscall.Errno = 32
+		winLockViolation    syscall.Errno = 33
+	)
+
+	for err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+			continue
+		}
+
+		if errno, ok := err.(syscall.Errno); ok {
+			return errno == winAccessDenied ||
+				errno == winSharingViolation ||
+				errno == winLockViolation
+		}
+
+		err = stdErrors.Unwrap(err)
+	}
+	return false
+}
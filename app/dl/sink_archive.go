@@ -0,0 +1,139 @@
+package dl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// streamPutter writes files one by one into the output, e.g. stdout or archive
+type streamPutter struct {
+	mu   *sync.Mutex
+	name string // name of output
+	out  io.Writer
+	// closer closes the output, nil if the output is stdout
+	closer io.Closer
+
+	// header is called before writing each file, and footer is called before closing output
+	header func(name string, size int64, modTime time.Time) (io.Writer, error)
+	footer func() error
+}
+
+func (s *streamPutter) put(_ context.Context, name string, r io.ReaderAt, size int64, modTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.out
+	if s.header != nil {
+		var err error
+		if w, err = s.header(name, size, modTime); err != nil {
+			return errors.Wrap(err, "write header")
+		}
+	}
+
+	_, err := io.Copy(w, io.NewSectionReader(r, 0, size))
+	return err
+}
+
+func (s *streamPutter) display(name string) string {
+	if name == "" {
+		return s.name
+	}
+	return fmt.Sprintf("%s:%s", s.name, name)
+}
+
+func (s *streamPutter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.footer != nil {
+		if err := s.footer(); err != nil {
+			return errors.Wrap(err, "write footer")
+		}
+	}
+
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+func newStdoutPutter() *streamPutter {
+	return &streamPutter{
+		mu:   &sync.Mutex{},
+		name: "stdout",
+		out:  os.Stdout,
+	}
+}
+
+// openOutput opens the archive file, or stdout if path is "-"
+func openOutput(path string) (*streamPutter, error) {
+	if path == "-" {
+		return newStdoutPutter(), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create archive")
+	}
+
+	return &streamPutter{
+		mu:     &sync.Mutex{},
+		name:   path,
+		out:    f,
+		closer: f,
+	}, nil
+}
+
+func newTarPutter(path string) (*streamPutter, error) {
+	s, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := tar.NewWriter(s.out)
+	s.header = func(name string, size int64, modTime time.Time) (io.Writer, error) {
+		return w, w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o644,
+			ModTime:  modTime,
+			Format:   tar.FormatPAX, // long and non-ASCII names
+		})
+	}
+	s.footer = w.Close
+
+	return s, nil
+}
+
+func newZipPutter(path string) (*streamPutter, error) {
+	s, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := zip.NewWriter(s.out)
+	s.header = func(name string, size int64, modTime time.Time) (io.Writer, error) {
+		return w.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store, // media is already compressed
+			Modified: modTime,
+		})
+	}
+	s.footer = w.Close
+
+	return s, nil
+}
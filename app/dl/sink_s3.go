@@ -0,0 +1,345 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// s3PartSize is the size of each part of multipart upload. S3 requires at least 5 MiB except the last part,
+// and at most 10000 parts, which is enough for 4 GiB files of Telegram.
+const s3PartSize = 16 * 1024 * 1024
+
+// s3Sink uploads files to S3-compatible bucket by multipart upload, and signs requests with AWS Signature V4
+type s3Sink struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	pathStyle bool
+
+	accessKey, secretKey, sessionToken string
+
+	partSize int64
+	now      func() time.Time
+}
+
+// newS3Sink parses 's3://bucket/prefix?endpoint=URL&region=REGION&path-style=BOOL'.
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN env.
+// Requests are sent to AWS S3 in virtual-hosted style by default, and custom endpoint like MinIO uses path style.
+func newS3Sink(s string) (*s3Sink, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse s3 url")
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, errors.Errorf("s3 sink should be in 's3://bucket/prefix' format: %q", s)
+	}
+
+	q := u.Query()
+	region := firstNonEmpty(q.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+
+	endpoint := firstNonEmpty(q.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	if v := q.Get("path-style"); v != "" {
+		if pathStyle, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.Wrap(err, "parse path-style")
+		}
+	}
+
+	ep, err := url.Parse(endpoint)
+	if err != nil || ep.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint: %q", endpoint)
+	}
+
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env are required by s3 sink")
+	}
+
+	return &s3Sink{
+		client:       http.DefaultClient,
+		endpoint:     ep,
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       region,
+		pathStyle:    pathStyle,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		partSize:     s3PartSize,
+		now:          time.Now,
+	}, nil
+}
+
+func (s *s3Sink) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3Sink) display(name string) string {
+	return "s3://" + path.Join(s.bucket, s.key(name))
+}
+
+func (s *s3Sink) Close() error { return nil }
+
+func (s *s3Sink) put(ctx context.Context, name string, r io.ReaderAt, size int64, modTime time.Time) (rerr error) {
+	key := s.key(name)
+
+	header := http.Header{}
+	if !modTime.IsZero() {
+		header.Set("X-Amz-Meta-Mtime", strconv.FormatInt(modTime.Unix(), 10))
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, &initiated); err != nil {
+		return errors.Wrap(err, "create multipart upload")
+	}
+
+	uploadID := initiated.UploadID
+	defer func() {
+		if rerr == nil {
+			return
+		}
+		// abort with a fresh context, because ctx may be canceled
+		if err := s.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, nil); err != nil {
+			rerr = errors.Wrapf(rerr, "abort multipart upload: %v", err)
+		}
+	}()
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	completed := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+
+	// empty file is uploaded as one empty part
+	for offset, number := int64(0), 1; offset < size || number == 1; offset, number = offset+s.partSize, number+1 {
+		n := min(s.partSize, size-offset)
+
+		resp := http.Header{}
+		if err := s.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}, nil, io.NewSectionReader(r, offset, n), &resp); err != nil {
+			return errors.Wrapf(err, "upload part %d", number)
+		}
+
+		completed.Parts = append(completed.Parts, part{PartNumber: number, ETag: resp.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(completed)
+	if err != nil {
+		return errors.Wrap(err, "marshal parts")
+	}
+
+	if err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, bytes.NewReader(body), nil); err != nil {
+		return errors.Wrap(err, "complete multipart upload")
+	}
+	return nil
+}
+
+// s3Error is the error response of S3
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// do sends the signed request. Response XML is decoded into v, or headers are copied if v is *http.Header.
+func (s *s3Sink) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadSeeker, v any) error {
+	u := *s.endpoint
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path, u.RawPath = path.Join(u.Path, p), s3Escape(path.Join(u.Path, p))
+	u.RawQuery = s3Query(query)
+
+	var (
+		reader io.Reader
+		length int64
+	)
+	if body != nil {
+		n, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return errors.Wrap(err, "seek body")
+		}
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "seek body")
+		}
+		if n > 0 { // empty body should be sent with zero Content-Length instead of chunked
+			reader, length = body, n
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.ContentLength = length
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+
+	// CompleteMultipartUpload may return error with 200 status
+	var e s3Error
+	if isErr := len(b) > 0 && xml.Unmarshal(b, &e) == nil; resp.StatusCode/100 != 2 || isErr {
+		if e.Code == "" {
+			return errors.Errorf("unexpected status: %s", resp.Status)
+		}
+		return errors.Errorf("%s: %s", e.Code, e.Message)
+	}
+
+	switch v := v.(type) {
+	case nil:
+	case *http.Header:
+		*v = resp.Header
+	default:
+		if err = xml.Unmarshal(b, v); err != nil {
+			return errors.Wrap(err, "decode response")
+		}
+	}
+	return nil
+}
+
+// sign adds AWS Signature V4 to request. Payload is not signed, which is allowed by S3.
+func (s *s3Sink) sign(req *http.Request) {
+	const (
+		algorithm = "AWS4-HMAC-SHA256"
+		payload   = "UNSIGNED-PAYLOAD"
+	)
+
+	now := s.now().UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// host and x-amz-* headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, k := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region, "s3", "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{algorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, v := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes all characters except unreserved ones and '/', as required by Signature V4
+func s3Escape(s string) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query encodes query sorted by key, with escaped values as required by Signature V4
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			pairs = append(pairs, strings.ReplaceAll(s3Escape(k)+"="+s3Escape(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
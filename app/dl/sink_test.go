@@ -0,0 +1,256 @@
+package dl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// png header, detected as image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+func writeSinkFile(t *testing.T, s sink, name string, content []byte) sinkFile {
+	f, err := s.Create(name, int64(len(content)))
+	require.NoError(t, err)
+
+	// parts are written out of order
+	half := len(content) / 2
+	_, err = f.WriteAt(content[half:], int64(half))
+	require.NoError(t, err)
+	_, err = f.WriteAt(content[:half], 0)
+	require.NoError(t, err)
+
+	return f
+}
+
+func TestDirSink(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSink(Options{Dir: dir, RewriteExt: true})
+	require.NoError(t, err)
+
+	modTime := time.Unix(1700000000, 0)
+	f := writeSinkFile(t, s, "a/1.jpg", pngHeader)
+	assert.Equal(t, filepath.Join(dir, "a", "1.jpg"), f.Name())
+
+	path, err := f.Commit(context.Background(), modTime)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "a", "1.png"), path)
+
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, stat.ModTime().Equal(modTime))
+
+	// partially written file is kept for resuming
+	f = writeSinkFile(t, s, "2.mp4", []byte("0123"))
+	require.NoError(t, f.Discard(true))
+	f, err = s.(resumableSink).Reopen("2.mp4")
+	require.NoError(t, err)
+	require.NoError(t, f.Discard(false))
+	assert.NoFileExists(t, filepath.Join(dir, "2.mp4"+tempExt))
+}
+
+func TestArchiveSink(t *testing.T) {
+	files := map[string][]byte{"1/a.jpg": pngHeader, "b.txt": []byte("hello, world")}
+
+	read := map[string]func(t *testing.T, path string) map[string][]byte{
+		"tar": func(t *testing.T, path string) map[string][]byte {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			defer f.Close()
+
+			r, got := tar.NewReader(f), make(map[string][]byte)
+			for {
+				h, err := r.Next()
+				if err == io.EOF {
+					return got
+				}
+				require.NoError(t, err)
+				got[h.Name], err = io.ReadAll(r)
+				require.NoError(t, err)
+			}
+		},
+		"zip": func(t *testing.T, path string) map[string][]byte {
+			r, err := zip.OpenReader(path)
+			require.NoError(t, err)
+			defer r.Close()
+
+			got := make(map[string][]byte)
+			for _, f := range r.File {
+				rc, err := f.Open()
+				require.NoError(t, err)
+				got[f.Name], err = io.ReadAll(rc)
+				require.NoError(t, err)
+				require.NoError(t, rc.Close())
+			}
+			return got
+		},
+	}
+
+	for kind, read := range read {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "out."+kind)
+
+			s, err := newSink(Options{Dir: dir, Sink: kind + ":" + archive, RewriteExt: true})
+			require.NoError(t, err)
+
+			for name, content := range files {
+				f := writeSinkFile(t, s, name, content)
+				_, err = f.Commit(context.Background(), time.Now())
+				require.NoError(t, err)
+			}
+
+			// failed file is not written
+			f := writeSinkFile(t, s, "c.txt", []byte("broken"))
+			require.NoError(t, f.Discard(true))
+			require.NoError(t, s.Close())
+
+			assert.Equal(t, map[string][]byte{
+				"1/a.png": pngHeader,
+				"b.txt":   []byte("hello, world"),
+			}, read(t, archive))
+
+			// spool files are removed
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	_, err := newSink(Options{Sink: "unknown:x"})
+	assert.Error(t, err)
+
+	_, err = newSink(Options{Sink: "tar:-", Dedup: DedupSkip})
+	assert.Error(t, err)
+
+	_, err = newSink(Options{Sink: "-", SkipSame: true})
+	assert.Error(t, err)
+
+	_, err = newSink(Options{Sink: "zip:a.zip", Verify: true})
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err = newSink(Options{Sink: "s3://bucket/prefix"})
+	assert.Error(t, err)
+
+	assert.True(t, SinkStdout("tar:-"))
+	assert.False(t, SinkStdout("tar:out.tar"))
+}
+
+// fakeS3 is an in-memory stand-in of S3 multipart upload API
+type fakeS3 struct {
+	mu      sync.Mutex
+	uploads map[string]map[int][]byte
+	objects map[string][]byte
+	aborted int
+	failAt  int // part number which fails
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	q, key := r.URL.Query(), r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads))
+		f.uploads[id] = make(map[int][]byte)
+		_, _ = w.Write([]byte("<InitiateMultipartUploadResult><UploadId>" + id + "</UploadId></InitiateMultipartUploadResult>"))
+	case r.Method == http.MethodPut:
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == f.failAt {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("<Error><Code>InternalError</Code><Message>failed</Message></Error>"))
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		f.uploads[q.Get("uploadId")][n] = b
+		w.Header().Set("ETag", `"`+strconv.Itoa(n)+`"`)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sort.Slice(complete.Parts, func(i, j int) bool { return complete.Parts[i].PartNumber < complete.Parts[j].PartNumber })
+
+		buf := &bytes.Buffer{}
+		for _, p := range complete.Parts {
+			buf.Write(f.uploads[q.Get("uploadId")][p.PartNumber])
+		}
+		f.objects[key] = buf.Bytes()
+		_, _ = w.Write([]byte("<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"))
+	case r.Method == http.MethodDelete:
+		f.aborted++
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	fake := &fakeS3{uploads: map[string]map[int][]byte{}, objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	s, err := newS3Sink("s3://bucket/prefix/?endpoint=" + srv.URL)
+	require.NoError(t, err)
+	assert.True(t, s.pathStyle)
+	s.partSize = 4
+
+	content := []byte("0123456789")
+	r := bytes.NewReader(content)
+	require.NoError(t, s.put(context.Background(), "a b/1+1.mp4", r, int64(len(content)), time.Now()))
+	assert.Equal(t, content, fake.objects["/bucket/prefix/a b/1+1.mp4"])
+	assert.Equal(t, "s3://bucket/prefix/a b/1+1.mp4", s.display("a b/1+1.mp4"))
+
+	require.NoError(t, s.put(context.Background(), "empty", bytes.NewReader(nil), 0, time.Time{}))
+	assert.Contains(t, fake.objects, "/bucket/prefix/empty")
+	assert.Empty(t, fake.objects["/bucket/prefix/empty"])
+
+	// failed upload is aborted
+	fake.failAt = 2
+	err = s.put(context.Background(), "failed", r, int64(len(content)), time.Now())
+	assert.ErrorContains(t, err, "InternalError")
+	assert.Equal(t, 1, fake.aborted)
+	assert.NotContains(t, fake.objects, "/bucket/prefix/failed")
+}
+
+func TestS3Escape(t *testing.T) {
+	assert.Equal(t, "/a%20b/1%2B1~_-.mp4", s3Escape("/a b/1+1~_-.mp4"))
+	assert.Equal(t, "partNumber=1&uploadId=a%2Fb", s3Query(map[string][]string{"uploadId": {"a/b"}, "partNumber": {"1"}}))
+	assert.Equal(t, "uploads=", s3Query(map[string][]string{"uploads": {""}}))
+}
@@ -0,0 +1,232 @@
+package dl
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/utils"
+)
+
+type fileTemplate struct {
+	DialogID     int64
+	MessageID    int
+	MessageDate  int64
+	FileName     string
+	FileCaption  string
+	FileSize     string
+	DownloadDate int64
+
+	ChatTitle    string
+	ChatUsername string
+	TopicID      int // 0 if chat is not a forum
+	SenderID     int64
+	MIME         string
+	MediaType    string // photo, video, round, audio, voice, gif, sticker or document
+	GroupedID    int64  // album id, 0 if message is not in album
+	GroupIndex   int    // index of message in album, only available with --group
+	MediaIndex   int    // index of media in message, like items of paid media
+	Duration     int    // seconds of video and audio
+	Width        int
+	Height       int
+	Size         int64 // size in bytes
+
+	ctx      context.Context
+	resolver *tplResolver
+	from     peers.Peer
+	msg      *tg.Message
+}
+
+func newFileTemplate(ctx context.Context, r *tplResolver, from peers.Peer, msg *tg.Message, item *tmedia.Media) *fileTemplate {
+	t := &fileTemplate{
+		DialogID:     from.ID(),
+		MessageID:    msg.ID,
+		MessageDate:  int64(msg.Date),
+		FileName:     item.Name,
+		FileCaption:  msg.Message,
+		FileSize:     utils.Byte.FormatBinaryBytes(item.Size),
+		DownloadDate: time.Now().Unix(),
+
+		ChatTitle: from.VisibleName(),
+		TopicID:   topicID(from, msg),
+		SenderID:  tutil.GetPeerID(msg.FromID),
+		MIME:      item.MIME,
+		MediaType: item.Kind,
+		Duration:  int(math.Round(item.Duration)),
+		Width:     item.Width,
+		Height:    item.Height,
+		Size:      item.Size,
+
+		ctx:      ctx,
+		resolver: r,
+		from:     from,
+		msg:      msg,
+	}
+	t.ChatUsername, _ = from.Username()
+	t.GroupedID, _ = msg.GetGroupedID()
+
+	// messages of channels and private chats may have no sender
+	if _, ok := msg.GetFromID(); !ok && !msg.Out {
+		t.SenderID = from.ID()
+	}
+
+	return t
+}
+
+// Topic returns title of the forum topic, which is resolved only if template uses it
+func (t *fileTemplate) Topic() string {
+	if t.resolver == nil || t.TopicID == 0 {
+		return ""
+	}
+	return t.resolver.topic(t.ctx, t.from, t.TopicID)
+}
+
+// SenderName returns visible name of the sender, which is resolved only if template uses it
+func (t *fileTemplate) SenderName() string {
+	if t.resolver == nil {
+		return ""
+	}
+	return t.resolver.sender(t.ctx, t.from, t.msg)
+}
+
+// topicID returns id of forum topic that the message belongs to
+func topicID(from peers.Peer, msg *tg.Message) int {
+	if h, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok && h.ForumTopic {
+		if top, ok := h.GetReplyToTopID(); ok {
+			return top
+		}
+		return h.ReplyToMsgID
+	}
+
+	// messages of 'General' topic have no reply header
+	if ch, ok := from.(peers.Channel); ok && ch.Raw().Forum {
+		return 1
+	}
+
+	return 0
+}
+
+type topicKey struct {
+	chat  int64
+	topic int
+}
+
+// tplResolver resolves names of topics and senders for file templates, and caches them
+type tplResolver struct {
+	manager *peers.Manager
+
+	mu      *sync.Mutex
+	topics  map[topicKey]string
+	senders map[int64]string
+}
+
+func newTplResolver(manager *peers.Manager) *tplResolver {
+	return &tplResolver{
+		manager: manager,
+		mu:      &sync.Mutex{},
+		topics:  make(map[topicKey]string),
+		senders: make(map[int64]string),
+	}
+}
+
+func (r *tplResolver) topic(ctx context.Context, from peers.Peer, id int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := topicKey{chat: from.ID(), topic: id}
+	if title, ok := r.topics[key]; ok {
+		return title
+	}
+
+	title, err := r.resolveTopic(ctx, from, id)
+	if err != nil {
+		logctx.From(ctx).Debug("Resolve topic title",
+			zap.Int64("dialog_id", from.ID()),
+			zap.Int("topic_id", id),
+			zap.Error(err))
+	}
+
+	// empty title is also cached to avoid requesting again
+	r.topics[key] = title
+	return title
+}
+
+func (r *tplResolver) resolveTopic(ctx context.Context, from peers.Peer, id int) (string, error) {
+	ch, ok := from.(peers.Channel)
+	if !ok {
+		return "", errors.New("not a channel")
+	}
+
+	topics, err := r.manager.API().ChannelsGetForumTopicsByID(ctx, &tg.ChannelsGetForumTopicsByIDRequest{
+		Channel: ch.InputChannel(),
+		Topics:  []int{id},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "get forum topics")
+	}
+
+	for _, t := range topics.Topics {
+		if t, ok := t.(*tg.ForumTopic); ok && t.ID == id {
+			return t.Title, nil
+		}
+	}
+
+	return "", errors.New("topic not found")
+}
+
+func (r *tplResolver) sender(ctx context.Context, from peers.Peer, msg *tg.Message) string {
+	fromID, ok := msg.GetFromID()
+	if !ok {
+		if !msg.Out {
+			return from.VisibleName() // channel post or incoming private message
+		}
+
+		self, err := r.manager.Self(ctx)
+		if err != nil {
+			return ""
+		}
+		return self.VisibleName()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := tutil.GetPeerID(fromID)
+	if name, ok := r.senders[id]; ok {
+		return name
+	}
+
+	var (
+		p   peers.Peer
+		err error
+	)
+	switch fromID.(type) {
+	case *tg.PeerUser:
+		p, err = r.manager.ResolveUserID(ctx, id)
+	case *tg.PeerChat:
+		p, err = r.manager.ResolveChatID(ctx, id)
+	case *tg.PeerChannel:
+		p, err = r.manager.ResolveChannelID(ctx, id)
+	}
+
+	name := ""
+	if err == nil && p != nil {
+		name = p.VisibleName()
+	} else {
+		logctx.From(ctx).Debug("Resolve sender",
+			zap.Int64("sender_id", id),
+			zap.Error(err))
+	}
+
+	r.senders[id] = name
+	return name
+}
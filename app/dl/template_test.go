@@ -0,0 +1,80 @@
+package dl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/iyear/tdl/core/tmedia"
+)
+
+func TestTopicID(t *testing.T) {
+	m := peers.Options{}.Build(nil)
+	forum := m.Channel(&tg.Channel{ID: 1, Title: "forum", Forum: true, Megagroup: true})
+	group := m.Channel(&tg.Channel{ID: 2, Title: "group", Megagroup: true})
+	user := m.User(&tg.User{ID: 3, FirstName: "user"})
+
+	tests := []struct {
+		name string
+		from peers.Peer
+		msg  *tg.Message
+		want int
+	}{
+		{name: "general topic", from: forum, msg: &tg.Message{ID: 10}, want: 1},
+		{name: "topic message", from: forum, msg: &tg.Message{ID: 10, ReplyTo: &tg.MessageReplyHeader{ForumTopic: true, ReplyToMsgID: 5}}, want: 5},
+		{name: "topic reply", from: forum, msg: &tg.Message{ID: 10, ReplyTo: func() *tg.MessageReplyHeader {
+			h := &tg.MessageReplyHeader{ForumTopic: true, ReplyToMsgID: 8}
+			h.SetReplyToTopID(5)
+			return h
+		}()}, want: 5},
+		{name: "group reply", from: group, msg: &tg.Message{ID: 10, ReplyTo: &tg.MessageReplyHeader{ReplyToMsgID: 8}}, want: 0},
+		{name: "user", from: user, msg: &tg.Message{ID: 10}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, topicID(tt.from, tt.msg))
+		})
+	}
+}
+
+func TestNewFileTemplate(t *testing.T) {
+	m := peers.Options{}.Build(nil)
+	raw := &tg.Channel{ID: 1, Title: "channel", Broadcast: true}
+	raw.SetUsername("tdl")
+	from := m.Channel(raw)
+
+	msg := &tg.Message{ID: 10, Date: 1700000000, Message: "caption"}
+	msg.SetGroupedID(100)
+	item := &tmedia.Media{
+		Name:     "video.mp4",
+		Size:     2048,
+		MIME:     "video/mp4",
+		Kind:     tmedia.KindVideo,
+		Duration: 59.6,
+		Width:    1920,
+		Height:   1080,
+	}
+
+	tpl := newFileTemplate(context.Background(), nil, from, msg, item)
+	assert.Equal(t, int64(1), tpl.DialogID)
+	assert.Equal(t, "channel", tpl.ChatTitle)
+	assert.Equal(t, "tdl", tpl.ChatUsername)
+	assert.Equal(t, 0, tpl.TopicID)
+	assert.Equal(t, int64(1), tpl.SenderID) // channel post is sent by channel itself
+	assert.Equal(t, "video/mp4", tpl.MIME)
+	assert.Equal(t, "video", tpl.MediaType)
+	assert.Equal(t, int64(100), tpl.GroupedID)
+	assert.Equal(t, 60, tpl.Duration)
+	assert.Equal(t, 1920, tpl.Width)
+	assert.Equal(t, 1080, tpl.Height)
+	assert.Equal(t, int64(2048), tpl.Size)
+	assert.Equal(t, "2.00 KB", tpl.FileSize)
+
+	// lazy fields are empty without resolver
+	assert.Equal(t, "", tpl.Topic())
+	assert.Equal(t, "", tpl.SenderName())
+}
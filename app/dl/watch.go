@@ -0,0 +1,257 @@
+package dl
+
+import (
+	"context"
+
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/spf13/viper"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/iyear/tdl/app/internal/tctx"
+	"github.com/iyear/tdl/core/downloader"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/bandwidth"
+	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
+	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
+	"github.com/iyear/tdl/pkg/utils"
+)
+
+// Watcher follows chats and downloads new media as they arrive.
+type Watcher struct {
+	dispatcher tg.UpdateDispatcher
+	gaps       *updates.Manager
+	messages   *messageQueue
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{
+		dispatcher: tg.NewUpdateDispatcher(),
+		gaps:       nil,
+		messages:   newMessageQueue(watchQueueSize),
+	}
+}
+
+// Handler builds the update handler which must be passed to the client.
+// Update state is persisted in kvd, so messages arrived between two runs are also received.
+func (w *Watcher) Handler(ctx context.Context, kvd storage.Storage) telegram.UpdateHandler {
+	w.gaps = updates.New(updates.Config{
+		Handler:      w.dispatcher,
+		Storage:      storage.NewState(kvd),
+		AccessHasher: storage.NewAccessHasher(kvd),
+		Logger:       logctx.From(ctx).Named("updates"),
+	})
+
+	return w.gaps
+}
+
+func (w *Watcher) Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
+	// only output available fields
+	if opts.Filter == "-" {
+		return printFilterFields()
+	}
+
+	if w.gaps == nil {
+		return errors.New("update handler is not registered")
+	}
+
+	redirectOutput(opts)
+
+	pool := tctx.NewPool(ctx, c)
+	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+
+	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
+
+	chats := make(map[int64]peers.Peer)
+	for _, chat := range opts.Watch {
+		p, err := tutil.GetInputPeer(ctx, manager, chat)
+		if err != nil {
+			return errors.Wrapf(err, "resolve chat %q", chat)
+		}
+		chats[p.ID()] = p
+	}
+
+	w.dispatcher.OnNewMessage(func(ctx context.Context, _ tg.Entities, u *tg.UpdateNewMessage) error {
+		return w.handle(ctx, chats, u.Message)
+	})
+	w.dispatcher.OnNewChannelMessage(func(ctx context.Context, _ tg.Entities, u *tg.UpdateNewChannelMessage) error {
+		return w.handle(ctx, chats, u.Message)
+	})
+
+	base, err := buildIter(pool, manager, kvd, nil, opts, viper.GetDuration(consts.FlagDelay))
+	if err != nil {
+		return err
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(base.sink))
+	base.watch = true
+	it := &watchIter{
+		iter:     base,
+		chats:    chats,
+		messages: w.messages,
+		albums:   make(map[int64]int64),
+	}
+
+	r, err := report.New("dl", opts.Report)
+	if err != nil {
+		return errors.Wrap(err, "create report")
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(r))
+	base.report = r
+
+	dlProgress := prog.New(utils.Byte.FormatBinaryBytes)
+	prog.EnablePS(ctx, dlProgress)
+
+	options := downloader.Options{
+		Pool:     pool,
+		Threads:  viper.GetInt(consts.FlagThreads),
+		Iter:     it,
+		Progress: metrics.Download(newProgress(dlProgress, base, opts, r)),
+		Verify:   opts.Verify,
+		Limiter:  bandwidth.From(ctx),
+	}
+	limit := viper.GetInt(consts.FlagLimit)
+
+	logctx.From(ctx).Info("Start watch",
+		zap.Strings("chats", opts.Watch),
+		zap.String("dir", opts.Dir),
+		zap.Int("threads", options.Threads),
+		zap.Int("limit", limit))
+
+	go dlProgress.Render()
+	defer prog.Wait(ctx, dlProgress)
+
+	wg, wgctx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		self, err := c.Self(wgctx)
+		if err != nil {
+			return errors.Wrap(err, "get self")
+		}
+
+		return w.gaps.Run(wgctx, c.API(), self.ID, updates.AuthOptions{
+			IsBot: self.Bot,
+			OnStart: func(ctx context.Context) {
+				dlProgress.Log(color.GreenString("Watching %d chat(s), new files will be downloaded to %s", len(chats), base.sink))
+			},
+		})
+	})
+	wg.Go(func() error {
+		return downloader.New(options).Download(wgctx, limit)
+	})
+
+	return wg.Wait()
+}
+
+func (w *Watcher) handle(ctx context.Context, chats map[int64]peers.Peer, msg tg.MessageClass) error {
+	m, ok := msg.(*tg.Message)
+	if !ok {
+		return nil
+	}
+
+	if _, ok = chats[tutil.GetPeerID(m.PeerID)]; !ok {
+		return nil
+	}
+
+	return w.messages.push(ctx, m)
+}
+
+// watchQueueSize is the max number of received messages waiting for download
+const watchQueueSize = 1000
+
+// messageQueue is a bounded queue of received messages. The update handler waits when it's full,
+// and pending updates are kept by the update manager instead of being lost.
+type messageQueue struct {
+	messages chan *tg.Message
+}
+
+func newMessageQueue(size int) *messageQueue {
+	return &messageQueue{messages: make(chan *tg.Message, size)}
+}
+
+// push waits for free space until ctx is done
+func (q *messageQueue) push(ctx context.Context, m *tg.Message) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case q.messages <- m:
+		return nil
+	}
+}
+
+// pop waits for the next message until ctx is done
+func (q *messageQueue) pop(ctx context.Context) (*tg.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m := <-q.messages:
+		return m, nil
+	}
+}
+
+// watchIter feeds messages received from updates into downloader
+type watchIter struct {
+	*iter
+
+	chats    map[int64]peers.Peer
+	messages *messageQueue
+	albums   map[int64]int64 // last processed album of each chat, messages of album arrive one by one
+}
+
+func (w *watchIter) Next(ctx context.Context) bool {
+	if len(w.elem) > 0 {
+		return true
+	}
+
+	for {
+		msg, err := w.messages.pop(ctx)
+		if err != nil {
+			w.err = err
+			return false
+		}
+
+		ret, skip := w.processMessage(ctx, msg)
+		if skip {
+			continue
+		}
+
+		return ret
+	}
+}
+
+func (w *watchIter) processMessage(ctx context.Context, msg *tg.Message) (bool, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return false, false
+	}
+
+	from := w.chats[tutil.GetPeerID(msg.PeerID)]
+	logctx.From(ctx).Debug("New message",
+		zap.Int64("dialog_id", from.ID()),
+		zap.Int("message_id", msg.ID))
+
+	if groupedID, ok := msg.GetGroupedID(); ok && w.opts.Group {
+		// the whole album is downloaded with its first received message
+		if w.albums[from.ID()] == groupedID {
+			return false, true
+		}
+		w.albums[from.ID()] = groupedID
+
+		return w.processGrouped(ctx, msg, from, w.logicalPos)
+	}
+
+	pos := w.logicalPos
+	w.logicalPos++
+
+	return w.processSingle(ctx, msg, from, pos, 0)
+}
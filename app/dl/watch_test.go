@@ -0,0 +1,55 @@
+package dl
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageQueue(t *testing.T) {
+	q := newMessageQueue(10)
+	ctx := context.Background()
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, q.push(ctx, &tg.Message{ID: i}))
+	}
+
+	// push waits when the queue is full
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.push(timeout, &tg.Message{ID: 11}), context.DeadlineExceeded)
+
+	for i := 1; i <= 10; i++ {
+		m, err := q.pop(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, i, m.ID)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := q.pop(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWatchIterAlbum(t *testing.T) {
+	from := peers.Options{}.Build(nil).Channel(&tg.Channel{ID: 1})
+	it := &watchIter{
+		iter:   &iter{mu: &sync.Mutex{}, opts: Options{Group: true}},
+		chats:  map[int64]peers.Peer{1: from},
+		albums: map[int64]int64{1: 100},
+	}
+
+	// other messages of the processed album are skipped without resolving the group again
+	msg := &tg.Message{ID: 2, PeerID: &tg.PeerChannel{ChannelID: 1}}
+	msg.SetGroupedID(100)
+	ret, skip := it.processMessage(context.Background(), msg)
+	assert.False(t, ret)
+	assert.True(t, skip)
+	assert.Zero(t, it.logicalPos)
+}
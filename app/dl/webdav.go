@@ -0,0 +1,517 @@
+package dl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/flytam/filenamify"
+	"github.com/go-faster/errors"
+	"github.com/gotd/contrib/partio"
+	"github.com/gotd/contrib/tg_io"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
+	"github.com/iyear/tdl/pkg/tplfunc"
+)
+
+const (
+	// directory listings are refreshed after davTTL, so new messages and expired file references are handled
+	davTTL = 10 * time.Minute
+	// a listing is shared by all waiting requests, so it's not canceled by any of them but limited by timeout
+	davFetchTimeout = 5 * time.Minute
+	davMaxFiles     = 1000  // only the newest files are listed
+	davMaxScan      = 20000 // max messages scanned for files of a directory
+)
+
+// serveWebDAV exposes chats as a read-only WebDAV filesystem: /<chat>/[<topic>/]<file>
+func serveWebDAV(ctx context.Context, kvd storage.Storage, pool dcpool.Pool, opts Options) error {
+	a, err := newAuth(opts)
+	if err != nil {
+		return errors.Wrap(err, "init auth")
+	}
+
+	tpl, err := template.New("dl").
+		Funcs(tplfunc.FuncMap(tplfunc.All...)).
+		Parse(opts.Template)
+	if err != nil {
+		return errors.Wrap(err, "parse template")
+	}
+
+	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
+
+	chats := make([]davChat, 0, len(opts.WebDAV))
+	for _, chat := range opts.WebDAV {
+		p, err := tutil.GetInputPeer(ctx, manager, chat)
+		if err != nil {
+			return errors.Wrapf(err, "resolve chat %q", chat)
+		}
+		chats = append(chats, davChat{name: chat, peer: p})
+	}
+
+	log := logctx.From(ctx).Named("webdav")
+	h := &webdav.Handler{
+		FileSystem: newDavFS(pool, tpl, newTplResolver(manager), chats, opts.Takeout),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Debug("Request failed", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Error(err))
+			}
+		},
+	}
+
+	return listenAndServe(ctx, a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(metrics.CountWriter(w, metrics.KindServe), r)
+	})), a, opts)
+}
+
+type davChat struct {
+	name string // the chat specified by user
+	peer peers.Peer
+}
+
+// davFS is a read-only webdav.FileSystem. Directories are listed lazily by iterating messages when accessed.
+type davFS struct {
+	pool     dcpool.Pool
+	tpl      *template.Template
+	resolver *tplResolver
+	chats    []davChat
+	takeout  bool
+	partSize int64
+
+	mu    *sync.Mutex
+	dirs  map[string]*davDir // cached listings by path
+	group *singleflight.Group
+	now   func() time.Time
+}
+
+type davDir struct {
+	created time.Time
+	entries []*davEntry
+	index   map[string]*davEntry
+}
+
+type davEntry struct {
+	name    string
+	dir     bool
+	modTime time.Time
+	chat    *davChat
+	topic   int    // topic id of topic directory
+	media   *media // media of file
+}
+
+func newDavFS(pool dcpool.Pool, tpl *template.Template, resolver *tplResolver, chats []davChat, takeout bool) *davFS {
+	return &davFS{
+		pool:     pool,
+		tpl:      tpl,
+		resolver: resolver,
+		chats:    chats,
+		takeout:  takeout,
+		partSize: int64(viper.GetInt(consts.FlagPartSize)),
+		mu:       &sync.Mutex{},
+		dirs:     make(map[string]*davDir),
+		group:    &singleflight.Group{},
+		now:      time.Now,
+	}
+}
+
+func (fs *davFS) Mkdir(context.Context, string, os.FileMode) error { return os.ErrPermission }
+func (fs *davFS) RemoveAll(context.Context, string) error          { return os.ErrPermission }
+func (fs *davFS) Rename(context.Context, string, string) error     { return os.ErrPermission }
+
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	e, err := fs.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.dir {
+		return &davDirFile{ctx: ctx, fs: fs, parts: splitPath(name), entry: e}, nil
+	}
+
+	api := fs.pool.Client(ctx, e.media.DC)
+	if fs.takeout {
+		api = fs.pool.Takeout(ctx, e.media.DC)
+	}
+
+	return &davFile{
+		chunkReader: newChunkReader(ctx, tg_io.NewDownloader(api).ChunkSource(e.media.Size, e.media.InputFileLoc), e.media.Size, fs.partSize),
+		entry:       e,
+	}, nil
+}
+
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, err := fs.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return davInfo{e}, nil
+}
+
+func (fs *davFS) stat(ctx context.Context, name string) (*davEntry, error) {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return &davEntry{name: "/", dir: true}, nil
+	}
+
+	dir, err := fs.list(ctx, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := dir.index[parts[len(parts)-1]]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return e, nil
+}
+
+// list returns entries of the directory, which is fetched at most once at the same time
+func (fs *davFS) list(ctx context.Context, parts []string) (*davDir, error) {
+	key := strings.Join(parts, "/")
+
+	fs.mu.Lock()
+	dir, ok := fs.dirs[key]
+	fs.mu.Unlock()
+	if ok && fs.now().Sub(dir.created) < davTTL {
+		return dir, nil
+	}
+
+	v, err, _ := fs.group.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), davFetchTimeout)
+		defer cancel()
+
+		entries, err := fs.fetch(ctx, parts)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := &davDir{created: fs.now(), entries: entries, index: make(map[string]*davEntry, len(entries))}
+		for _, e := range entries {
+			dir.index[e.name] = e
+		}
+
+		fs.mu.Lock()
+		fs.dirs[key] = dir
+		fs.mu.Unlock()
+
+		return dir, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*davDir), nil
+}
+
+func (fs *davFS) fetch(ctx context.Context, parts []string) ([]*davEntry, error) {
+	switch len(parts) {
+	case 0: // chats
+		entries := make([]*davEntry, 0, len(fs.chats))
+		for i := range fs.chats {
+			entries = append(entries, &davEntry{name: fs.chats[i].name, dir: true, chat: &fs.chats[i]})
+		}
+		return entries, nil
+	case 1: // topics of forum, or files of chat
+		e, err := fs.stat(ctx, parts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if ch, ok := e.chat.peer.(peers.Channel); ok && ch.Raw().Forum {
+			return fs.fetchTopics(ctx, e.chat, ch)
+		}
+		return fs.fetchFiles(ctx, e.chat, 0)
+	case 2: // files of topic
+		e, err := fs.stat(ctx, strings.Join(parts, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if !e.dir {
+			return nil, os.ErrNotExist
+		}
+		return fs.fetchFiles(ctx, e.chat, e.topic)
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+func (fs *davFS) fetchTopics(ctx context.Context, chat *davChat, ch peers.Channel) ([]*davEntry, error) {
+	topics, err := tutil.GetForumTopics(ctx, fs.pool.Default(ctx), ch.InputChannel())
+	if err != nil {
+		return nil, errors.Wrap(err, "get forum topics")
+	}
+
+	entries := make([]*davEntry, 0, len(topics))
+	for _, t := range topics {
+		title, err := filenamify.FilenamifyV2(t.Title)
+		if err != nil {
+			title = ""
+		}
+
+		entries = append(entries, &davEntry{
+			name:    strings.TrimSuffix(fmt.Sprintf("%d-%s", t.ID, title), "-"),
+			dir:     true,
+			modTime: time.Unix(int64(t.Date), 0),
+			chat:    chat,
+			topic:   t.ID,
+		})
+	}
+
+	return entries, nil
+}
+
+// fetchFiles iterates media messages of chat or topic from the newest, and names them by download template.
+// At most davMaxFiles files are listed, and at most davMaxScan messages are scanned.
+func (fs *davFS) fetchFiles(ctx context.Context, chat *davChat, topic int) ([]*davEntry, error) {
+	api := fs.pool.Default(ctx)
+
+	var q messages.Query = query.NewQuery(api).Messages().GetHistory(chat.peer.InputPeer())
+	if topic != 0 {
+		q = query.NewQuery(api).Messages().GetReplies(chat.peer.InputPeer()).MsgID(topic)
+	}
+
+	entries, names := make([]*davEntry, 0), make(map[string]struct{})
+
+	iter, scanned := messages.NewIterator(q, 100), 0
+	for len(entries) < davMaxFiles && scanned < davMaxScan && iter.Next(ctx) {
+		scanned++
+
+		msg, ok := iter.Value().Msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+
+		medias, err := convItems(msg)
+		if err != nil { // not a media
+			continue
+		}
+
+		for idx, m := range medias {
+			t := newFileTemplate(ctx, fs.resolver, chat.peer, msg, m.Media)
+			t.MediaIndex = idx
+			t.DownloadDate = fs.now().Unix()
+
+			name := bytes.Buffer{}
+			if err = fs.tpl.Execute(&name, t); err != nil {
+				return nil, errors.Wrap(err, "execute template")
+			}
+
+			// files are flattened in the directory, and older ones are suffixed if names conflict
+			n := strings.ReplaceAll(name.String(), "/", "_")
+			if n == "" {
+				continue
+			}
+			n = uniqueName(names, n)
+
+			entries = append(entries, &davEntry{
+				name:    n,
+				modTime: time.Unix(int64(msg.Date), 0),
+				chat:    chat,
+				media:   m,
+			})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate messages")
+	}
+
+	return entries, nil
+}
+
+// uniqueName returns name, or name with ' (N)' suffix before extension if it's used, then marks it as used
+func uniqueName(names map[string]struct{}, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	n := name
+	for i := 1; ; i++ {
+		if _, ok := names[n]; !ok {
+			break
+		}
+		n = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+
+	names[n] = struct{}{}
+	return n
+}
+
+func splitPath(name string) []string {
+	parts := make([]string, 0)
+	for _, p := range strings.Split(name, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// davInfo implements os.FileInfo and webdav.ContentTyper
+type davInfo struct {
+	*davEntry
+}
+
+func (i davInfo) Name() string       { return i.name }
+func (i davInfo) ModTime() time.Time { return i.modTime }
+func (i davInfo) IsDir() bool        { return i.dir }
+func (i davInfo) Sys() any           { return nil }
+
+func (i davInfo) Size() int64 {
+	if i.dir {
+		return 0
+	}
+	return i.media.Size
+}
+
+func (i davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (i davInfo) ContentType(context.Context) (string, error) {
+	if i.dir || i.media.MIME == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.media.MIME, nil
+}
+
+type davDirFile struct {
+	ctx   context.Context
+	fs    *davFS
+	parts []string
+	entry *davEntry
+	pos   int // position of Readdir
+}
+
+func (f *davDirFile) Close() error                   { return nil }
+func (f *davDirFile) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (f *davDirFile) Seek(int64, int) (int64, error) { return 0, os.ErrInvalid }
+func (f *davDirFile) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (f *davDirFile) Stat() (os.FileInfo, error)     { return davInfo{f.entry}, nil }
+func (f *davDirFile) Readdir(count int) ([]os.FileInfo, error) {
+	dir, err := f.fs.list(f.ctx, f.parts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := dir.entries[min(f.pos, len(dir.entries)):]
+	if count > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(count, len(entries))]
+	}
+	f.pos += len(entries)
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, davInfo{e})
+	}
+	return infos, nil
+}
+
+type davFile struct {
+	*chunkReader
+	entry *davEntry
+}
+
+func (f *davFile) Close() error                       { return nil }
+func (f *davFile) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+func (f *davFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *davFile) Stat() (os.FileInfo, error)         { return davInfo{f.entry}, nil }
+
+// chunkReader reads file by aligned chunks of partio.ChunkSource, and the last chunk is buffered for sequential reads
+type chunkReader struct {
+	ctx   context.Context
+	src   partio.ChunkSource
+	size  int64
+	chunk int64
+
+	off    int64
+	buf    []byte
+	bufOff int64
+	bufLen int64 // -1 means nothing buffered
+}
+
+func newChunkReader(ctx context.Context, src partio.ChunkSource, size, chunk int64) *chunkReader {
+	return &chunkReader{
+		ctx:    ctx,
+		src:    src,
+		size:   size,
+		chunk:  chunk,
+		bufLen: -1,
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+
+	start := r.off - r.off%r.chunk
+	if r.bufLen < 0 || r.bufOff != start {
+		if r.buf == nil {
+			r.buf = make([]byte, r.chunk)
+		}
+
+		n, err := r.src.Chunk(r.ctx, start, r.buf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			r.bufLen = -1
+			return 0, err
+		}
+		r.bufOff, r.bufLen = start, n
+	}
+
+	i := r.off - start
+	if i >= r.bufLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := copy(p, r.buf[i:r.bufLen])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position: " + strconv.FormatInt(offset, 10))
+	}
+	r.off = offset
+	return offset, nil
+}
@@ -0,0 +1,109 @@
+package dl
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bytesChunkSource serves chunks of content like tg_io.ChunkSource
+type bytesChunkSource struct {
+	b     []byte
+	calls int
+}
+
+func (s *bytesChunkSource) Chunk(_ context.Context, offset int64, b []byte) (int64, error) {
+	s.calls++
+	if offset >= int64(len(s.b)) {
+		return 0, io.EOF
+	}
+	return int64(copy(b, s.b[offset:])), nil
+}
+
+func TestChunkReader(t *testing.T) {
+	src := &bytesChunkSource{b: []byte("0123456789abcdef")}
+	r := newChunkReader(context.Background(), src, int64(len(src.b)), 4)
+
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(b))
+	assert.Equal(t, 4, src.calls)
+
+	off, err := r.Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), off)
+
+	// unaligned reads are served from the buffered chunk
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "56", string(buf))
+	calls := src.calls
+	_, err = io.ReadFull(r, buf[:1])
+	require.NoError(t, err)
+	assert.Equal(t, "7", string(buf[:1]))
+	assert.Equal(t, calls, src.calls)
+
+	off, err = r.Seek(-3, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), off)
+	b, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "def", string(b))
+
+	_, err = r.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+}
+
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{}, splitPath("/"))
+	assert.Equal(t, []string{"chat", "1-topic", "file.mp4"}, splitPath("/chat//1-topic/file.mp4/"))
+}
+
+func TestUniqueName(t *testing.T) {
+	names := make(map[string]struct{})
+
+	assert.Equal(t, "a.mp4", uniqueName(names, "a.mp4"))
+	assert.Equal(t, "a (1).mp4", uniqueName(names, "a.mp4"))
+	assert.Equal(t, "a (2).mp4", uniqueName(names, "a.mp4"))
+	assert.Equal(t, "b", uniqueName(names, "b"))
+	assert.Equal(t, "b (1)", uniqueName(names, "b"))
+}
+
+func TestDavFSRoot(t *testing.T) {
+	fs := newDavFS(nil, nil, nil, []davChat{{name: "a"}, {name: "b"}}, false)
+	ctx := context.Background()
+
+	info, err := fs.Stat(ctx, "/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	f, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	require.NoError(t, err)
+
+	infos, err := f.Readdir(1)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "a", infos[0].Name())
+
+	infos, err = f.Readdir(1)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "b", infos[0].Name())
+
+	_, err = f.Readdir(1)
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = fs.Stat(ctx, "/c")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// read-only
+	_, err = fs.OpenFile(ctx, "/a/file", os.O_CREATE|os.O_WRONLY, 0o644)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.ErrorIs(t, fs.Mkdir(ctx, "/d", 0o755), os.ErrPermission)
+	assert.ErrorIs(t, fs.RemoveAll(ctx, "/a"), os.ErrPermission)
+}
@@ -17,13 +17,14 @@ import (
 	"go.uber.org/multierr"
 
 	"github.com/iyear/tdl/app/internal/tctx"
-	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/forwarder"
 	"github.com/iyear/tdl/core/storage"
-	"github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/bandwidth"
 	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
 	"github.com/iyear/tdl/pkg/texpr"
 	"github.com/iyear/tdl/pkg/tmessage"
 )
@@ -37,6 +38,7 @@ type Options struct {
 	DryRun bool
 	Single bool
 	Desc   bool
+	Report string
 }
 
 func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
@@ -54,9 +56,7 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	ctx = tctx.WithKV(ctx, kvd)
 
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
+	pool := tctx.NewPool(ctx, c)
 	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
 
 	ctx = tctx.WithPool(ctx, pool)
@@ -78,6 +78,12 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		return errors.Wrap(err, "resolve edit")
 	}
 
+	r, err := report.New("forward", opts.Report)
+	if err != nil {
+		return errors.Wrap(err, "create report")
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(r))
+
 	fwProgress := prog.New(pw.FormatNumber)
 	fwProgress.SetNumTrackersExpected(totalMessages(dialogs))
 	prog.EnablePS(ctx, fwProgress)
@@ -96,8 +102,9 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 			grouped: !opts.Single,
 			delay:   viper.GetDuration(consts.FlagDelay),
 		}),
-		Progress: newProgress(fwProgress),
+		Progress: metrics.Forward(newProgress(fwProgress, r)),
 		Threads:  viper.GetInt(consts.FlagThreads),
+		Limiter:  bandwidth.From(ctx),
 	})
 
 	go fwProgress.Render()
@@ -116,7 +123,7 @@ func collectDialogs(ctx context.Context, input []string, desc bool) ([]*tmessage
 		)
 
 		switch {
-		case strings.HasPrefix(p, "http"):
+		case strings.HasPrefix(p, "http"), strings.HasPrefix(p, "tg:"):
 			d, err = tmessage.Parse(tmessage.FromURL(ctx, tctx.Pool(ctx), tctx.KV(ctx), []string{p}))
 			if err != nil {
 				return nil, errors.Wrap(err, "parse from url")
@@ -136,7 +143,12 @@ func collectDialogs(ctx context.Context, input []string, desc bool) ([]*tmessage
 			}
 		}
 
-		dialogs = append(dialogs, d...)
+		for _, dd := range d {
+			// iterator requires at least one message or story of each dialog
+			if dd.Len() > 0 {
+				dialogs = append(dialogs, dd)
+			}
+		}
 	}
 
 	return dialogs, nil
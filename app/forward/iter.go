@@ -36,9 +36,10 @@ type iterOptions struct {
 type iter struct {
 	opts iterOptions
 
-	i, j int
-	elem forwarder.Elem
-	err  error
+	i, j     int
+	prefetch *tmessage.Prefetcher
+	elem     forwarder.Elem
+	err      error
 }
 
 type env struct {
@@ -83,6 +84,16 @@ func newIter(opts iterOptions) *iter {
 }
 
 func (i *iter) Next(ctx context.Context) bool {
+	if i.next(ctx) {
+		return true
+	}
+
+	// stop prefetching once iteration is finished or failed
+	i.prefetch.Close()
+	return false
+}
+
+func (i *iter) next(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
 		i.err = ctx.Err()
@@ -100,22 +111,24 @@ func (i *iter) Next(ctx context.Context) bool {
 		time.Sleep(i.opts.delay)
 	}
 
-	p, m := i.opts.dialogs[i.i].Peer, i.opts.dialogs[i.i].Messages[i.j]
-
-	if i.j++; i.j >= len(i.opts.dialogs[i.i].Messages) {
+	if i.j++; i.j >= i.opts.dialogs[i.i].Len() {
 		i.i++
 		i.j = 0
 	}
 
-	from, err := i.opts.manager.FromInputPeer(ctx, p)
+	if i.prefetch == nil {
+		i.prefetch = tmessage.NewPrefetcher(ctx, i.opts.dialogs, i.opts.manager.FromInputPeer,
+			i.fetchMessages, i.fetchStories, tmessage.PrefetchAhead)
+	}
+	fetched, err := i.prefetch.Next(ctx)
 	if err != nil {
-		i.err = errors.Wrap(err, "get from peer")
+		i.err = errors.Wrap(err, "get message")
 		return false
 	}
 
-	msg, err := tutil.GetSingleMessage(ctx, i.opts.pool.Default(ctx), from.InputPeer(), m)
-	if err != nil {
-		i.err = errors.Wrapf(err, "get message: %d", m)
+	from, msg := fetched.From, fetched.Message
+	if msg == nil {
+		i.err = errors.Wrapf(tutil.ErrMessageDeleted, "get message: %d/%d", from.ID(), fetched.ID)
 		return false
 	}
 
@@ -153,6 +166,10 @@ func (i *iter) Next(ctx context.Context) bool {
 	}
 
 	var modeOverride forwarder.Mode = -1 // default value is invalid
+	// stories are wrapped as messages, which can't be forwarded by id
+	if fetched.Story {
+		modeOverride = forwarder.ModeClone
+	}
 	// edit message
 	if i.opts.edit != nil {
 		result, err = texpr.Run(i.opts.edit, exprEnv(from, msg))
@@ -199,6 +216,14 @@ func (i *iter) Next(ctx context.Context) bool {
 	return true
 }
 
+func (i *iter) fetchMessages(ctx context.Context, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	return tutil.GetMessages(ctx, i.opts.pool.Default(ctx), peer, ids)
+}
+
+func (i *iter) fetchStories(ctx context.Context, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	return tutil.GetStories(ctx, i.opts.pool.Default(ctx), peer, ids)
+}
+
 func (i *iter) resolvePeer(ctx context.Context, peer string) (peers.Peer, error) {
 	if peer == "" { // self
 		return i.opts.manager.Self(ctx)
@@ -10,6 +10,7 @@ import (
 
 	"github.com/iyear/tdl/core/forwarder"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
@@ -17,6 +18,8 @@ type progress struct {
 	pw       pw.Writer
 	trackers map[tuple]*pw.Tracker // TODO(iyear): concurrent map
 	elemName map[int64]string
+	cloned   map[tuple]int64 // transferred bytes of cloned media
+	report   *report.Reporter
 }
 
 type tuple struct {
@@ -25,17 +28,25 @@ type tuple struct {
 	to   int64
 }
 
-func newProgress(p pw.Writer) *progress {
+func newProgress(p pw.Writer, r *report.Reporter) *progress {
 	return &progress{
 		pw:       p,
 		trackers: make(map[tuple]*pw.Tracker),
 		elemName: make(map[int64]string),
+		cloned:   make(map[tuple]int64),
+		report:   r,
 	}
 }
 
 func (p *progress) OnAdd(elem forwarder.Elem) {
 	tracker := prog.AppendTracker(p.pw, pw.FormatNumber, p.processMessage(elem, false), 1)
 	p.trackers[p.tuple(elem)] = tracker
+
+	p.report.Start(p.tuple(elem), report.Entry{
+		From:    &report.Peer{ID: elem.From().ID(), Name: elem.From().VisibleName()},
+		Message: elem.Msg().ID,
+		To:      &report.Peer{ID: elem.To().ID(), Name: elem.To().VisibleName()},
+	})
 }
 
 func (p *progress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
@@ -49,6 +60,8 @@ func (p *progress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
 	tracker.UpdateMessage(p.processMessage(elem, true))
 	tracker.UpdateTotal(state.Total)
 	tracker.SetValue(state.Done)
+
+	p.cloned[p.tuple(elem)] = state.Done
 }
 
 func (p *progress) OnDone(elem forwarder.Elem, err error) {
@@ -57,6 +70,11 @@ func (p *progress) OnDone(elem forwarder.Elem, err error) {
 		return
 	}
 
+	p.report.Done(p.tuple(elem), err, func(entry *report.Entry) {
+		entry.Bytes = p.cloned[p.tuple(elem)]
+	})
+	delete(p.cloned, p.tuple(elem))
+
 	if err != nil {
 		p.pw.Log(color.RedString("%s error: %s", p.metaString(elem), err.Error()))
 		tracker.MarkAsErrored()
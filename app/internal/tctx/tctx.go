@@ -3,8 +3,13 @@ package tctx
 import (
 	"context"
 
+	"github.com/gotd/td/telegram"
+	"github.com/spf13/viper"
+
 	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tclient"
+	"github.com/iyear/tdl/pkg/consts"
 )
 
 type kvKey struct{}
@@ -26,3 +31,28 @@ func Pool(ctx context.Context) dcpool.Pool {
 func WithPool(ctx context.Context, pool dcpool.Pool) context.Context {
 	return context.WithValue(ctx, poolKey{}, pool)
 }
+
+type sharedPoolKey struct{}
+
+// WithSharedPool sets the pool owned by a long-running process like daemon, which is reused by NewPool.
+func WithSharedPool(ctx context.Context, pool dcpool.Pool) context.Context {
+	return context.WithValue(ctx, sharedPoolKey{}, pool)
+}
+
+// NewPool returns the shared pool if set, otherwise creates a new pool of c with global flags.
+// Closing the shared pool is a no-op, it's closed by its owner.
+func NewPool(ctx context.Context, c *telegram.Client) dcpool.Pool {
+	if pool, ok := ctx.Value(sharedPoolKey{}).(dcpool.Pool); ok {
+		return sharedPool{Pool: pool}
+	}
+
+	return dcpool.NewPool(c,
+		int64(viper.GetInt(consts.FlagPoolSize)),
+		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
+}
+
+type sharedPool struct {
+	dcpool.Pool
+}
+
+func (sharedPool) Close() error { return nil }
@@ -11,12 +11,14 @@ import (
 
 	"github.com/iyear/tdl/core/uploader"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
 type progress struct {
 	pw       pw.Writer
 	trackers *sync.Map // map[tuple]*pw.Tracker
+	report   *report.Reporter
 }
 
 type tuple struct {
@@ -24,16 +26,23 @@ type tuple struct {
 	to   int64
 }
 
-func newProgress(p pw.Writer) *progress {
+func newProgress(p pw.Writer, r *report.Reporter) *progress {
 	return &progress{
 		pw:       p,
 		trackers: &sync.Map{},
+		report:   r,
 	}
 }
 
 func (p *progress) OnAdd(elem uploader.Elem) {
 	tracker := prog.AppendTracker(p.pw, utils.Byte.FormatBinaryBytes, p.processMessage(elem), elem.File().Size())
 	p.trackers.Store(p.tuple(elem), tracker)
+
+	e := elem.(*iterElem)
+	p.report.Start(p.tuple(elem), report.Entry{
+		File: e.file.File.Name(),
+		To:   &report.Peer{ID: e.to.ID(), Name: e.to.VisibleName()},
+	})
 }
 
 func (p *progress) OnUpload(elem uploader.Elem, state uploader.ProgressState) {
@@ -53,24 +62,33 @@ func (p *progress) OnDone(elem uploader.Elem, err error) {
 		return
 	}
 	t := tracker.(*pw.Tracker)
-	e := elem.(*iterElem)
 
+	err = p.done(elem.(*iterElem), err)
+	p.report.Done(p.tuple(elem), err, func(entry *report.Entry) {
+		entry.Bytes = t.Value()
+	})
+
+	if err != nil {
+		p.fail(t, elem, err)
+	}
+}
+
+func (p *progress) done(e *iterElem, err error) error {
 	if err := p.closeFile(e); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "close file"))
-		return
+		return errors.Wrap(err, "close file")
 	}
 
 	if err != nil {
-		p.fail(t, elem, errors.Wrap(err, "progress"))
-		return
+		return errors.Wrap(err, "progress")
 	}
 
 	if e.remove {
 		if err := os.Remove(e.file.File.Name()); err != nil {
-			p.fail(t, elem, errors.Wrap(err, "remove file"))
-			return
+			return errors.Wrap(err, "remove file")
 		}
 	}
+
+	return nil
 }
 
 func (p *progress) closeFile(e *iterElem) error {
@@ -19,14 +19,16 @@ import (
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
-	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/app/internal/tctx"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
-	"github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/uploader"
 	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/bandwidth"
 	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/metrics"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/report"
 	"github.com/iyear/tdl/pkg/texpr"
 	"github.com/iyear/tdl/pkg/utils"
 )
@@ -41,6 +43,7 @@ type Options struct {
 	Remove   bool
 	Photo    bool
 	Caption  string
+	Report   string
 }
 
 type Env struct {
@@ -71,9 +74,7 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	color.Blue("Files count: %d", len(files))
 
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
+	pool := tctx.NewPool(ctx, c)
 	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
 
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
@@ -88,6 +89,12 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		return errors.Wrap(err, "get caption")
 	}
 
+	r, err := report.New("up", opts.Report)
+	if err != nil {
+		return errors.Wrap(err, "create report")
+	}
+	defer multierr.AppendInvoke(&rerr, multierr.Close(r))
+
 	upProgress := prog.New(utils.Byte.FormatBinaryBytes)
 	upProgress.SetNumTrackersExpected(len(files))
 	prog.EnablePS(ctx, upProgress)
@@ -96,7 +103,8 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		Client:   pool.Default(ctx),
 		Threads:  viper.GetInt(consts.FlagThreads),
 		Iter:     newIter(files, to, caption, opts.Chat, opts.Thread, opts.Photo, opts.Remove, viper.GetDuration(consts.FlagDelay), manager),
-		Progress: newProgress(upProgress),
+		Progress: metrics.Upload(newProgress(upProgress, r)),
+		Limiter:  bandwidth.From(ctx),
 	}
 
 	up := uploader.New(options)
@@ -13,6 +13,7 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/iyear/tdl/app/chat"
+	"github.com/iyear/tdl/app/daemon"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 )
@@ -26,7 +27,7 @@ func NewChat() *cobra.Command {
 		GroupID: groupTools.ID,
 	}
 
-	cmd.AddCommand(NewChatList(), NewChatExport(), NewChatUsers())
+	cmd.AddCommand(NewChatList(), NewChatExport(), NewChatUsers(), NewChatSearch())
 
 	return cmd
 }
@@ -51,7 +52,10 @@ func NewChatList() *cobra.Command {
 }
 
 func NewChatExport() *cobra.Command {
-	var opts chat.ExportOptions
+	var (
+		opts chat.ExportOptions
+		addr string
+	)
 
 	cmd := &cobra.Command{
 		Use:   "export",
@@ -83,6 +87,31 @@ func NewChatExport() *cobra.Command {
 				return fmt.Errorf("unknown export type: %s", opts.Type)
 			}
 
+			if !opts.Backup && (opts.WithMedia || opts.HTML) {
+				return fmt.Errorf("--with-media and --html are only available with --backup")
+			}
+			if opts.Backup && opts.Format != chat.ExportFormatJson {
+				return fmt.Errorf("--backup is only available with json format")
+			}
+			if opts.Multiple() && opts.Thread != 0 {
+				return fmt.Errorf("--topic and --reply are only available when exporting single chat")
+			}
+			// output is a directory in backup mode or when exporting multiple chats
+			if !cmd.Flags().Changed("output") {
+				switch {
+				case opts.Backup:
+					opts.Output = "tdl-backup"
+				case opts.Multiple():
+					opts.Output = "tdl-export"
+				default:
+					opts.Output = "tdl-export" + opts.Format.Ext()
+				}
+			}
+
+			if addr != "" {
+				return submit(cmd.Context(), addr, daemon.JobTypeExport, opts)
+			}
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return chat.Export(logctx.Named(ctx, "export"), c, kvd, opts)
 			}, limiter)
@@ -96,7 +125,8 @@ func NewChatExport() *cobra.Command {
 	)
 
 	cmd.Flags().VarP(&opts.Type, _type, "T", fmt.Sprintf("export type: [%s]", strings.Join(chat.ExportTypeNames(), ", ")))
-	cmd.Flags().StringVarP(&opts.Chat, _chat, "c", "", "chat id or domain. If not specified, 'Saved Messages' will be used")
+	cmd.Flags().StringSliceVarP(&opts.Chats, _chat, "c", []string{}, "chat id or domain, can be specified multiple times. If not specified, 'Saved Messages' will be used")
+	cmd.Flags().StringVar(&opts.ChatFilter, "chat-filter", "", "export dialogs matching the filter expression like 'chat ls'. Specify '-' to see available fields")
 
 	// topic id and message id is the same field in tg.MessagesGetRepliesRequest
 	cmd.Flags().IntVar(&opts.Thread, "topic", 0, "specify topic id")
@@ -104,10 +134,19 @@ func NewChatExport() *cobra.Command {
 
 	cmd.Flags().IntSliceVarP(&opts.Input, input, "i", []int{}, "input data, depends on export type")
 	cmd.Flags().StringVarP(&opts.Filter, "filter", "f", "true", "filter messages by expression, defaults to match all messages. Specify '-' to see available fields")
-	cmd.Flags().StringVarP(&opts.Output, "output", "o", "tdl-export.json", "output JSON file path")
+	cmd.Flags().VarP(&opts.Format, "format", "F", fmt.Sprintf("output format: [%s]", strings.Join(chat.ExportFormatNames(), ", ")))
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "tdl-export.json", "output file path, or output directory in backup mode (defaults to 'tdl-backup') or when exporting multiple chats (defaults to 'tdl-export')")
 	cmd.Flags().BoolVar(&opts.WithContent, "with-content", false, "export with message content")
 	cmd.Flags().BoolVar(&opts.Raw, "raw", false, "export raw message struct of Telegram MTProto API, useful for debugging")
 	cmd.Flags().BoolVar(&opts.All, "all", false, "export all messages including non-media messages, but still affected by filter and type flag")
+	cmd.Flags().BoolVar(&opts.Backup, "backup", false, "export Telegram Desktop compatible result.json with text, entities, replies, forwards, reactions and service messages for backup")
+	cmd.Flags().BoolVar(&opts.WithMedia, "with-media", false, "download media alongside in backup mode")
+	cmd.Flags().BoolVar(&opts.HTML, "html", false, "render messages.html in backup mode")
+	cmd.Flags().StringVar(&opts.Search, "search", "", "search messages by keyword on server side, which is faster than local filter on large chats")
+	cmd.Flags().Var(&opts.MediaType, "media-type", fmt.Sprintf("search messages by media type on server side: [%s]", strings.Join(chat.MediaTypeNames(), ", ")))
+	cmd.Flags().StringVar(&opts.FromUser, "from-user", "", "search messages sent by the user id or domain on server side")
+	cmd.Flags().BoolVar(&opts.Incremental, "incremental", false, "export only messages newer than the last incremental export of the chat/topic, and append them to existing output")
+	addRemoteFlag(cmd, &addr)
 
 	// completion and validation
 	_ = cmd.RegisterFlagCompletionFunc(input, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -138,14 +177,46 @@ func NewChatUsers() *cobra.Command {
 		Use:   "users",
 		Short: "export users from (protected) channels",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("output") {
+				opts.Output = "tdl-users" + opts.Format.Ext()
+			}
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return chat.Users(logctx.Named(ctx, "users"), c, kvd, opts)
 			}, limiter)
 		},
 	}
 
-	cmd.Flags().StringVarP(&opts.Output, "output", "o", "tdl-users.json", "output JSON file path")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "tdl-users.json", "output file path")
+	cmd.Flags().VarP(&opts.Format, "format", "F", fmt.Sprintf("output format: [%s]", strings.Join(chat.ExportFormatNames(), ", ")))
 	cmd.Flags().StringVarP(&opts.Chat, "chat", "c", "", "domain id (channels, supergroups, etc.)")
 	cmd.Flags().BoolVar(&opts.Raw, "raw", false, "export raw message struct of Telegram MTProto API, useful for debugging")
 	return cmd
 }
+
+func NewChatSearch() *cobra.Command {
+	var opts chat.SearchOptions
+
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "search messages across all chats for download",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
+				return chat.Search(logctx.Named(ctx, "search"), c, kvd, opts)
+			}, limiter)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Query, "query", "q", "", "search keyword")
+	cmd.Flags().Var(&opts.MediaType, "media-type", fmt.Sprintf("search messages by media type: [%s]", strings.Join(chat.MediaTypeNames(), ", ")))
+	cmd.Flags().Var(&opts.Scope, "scope", fmt.Sprintf("search in types of chats: [%s]", strings.Join(chat.SearchScopeNames(), ", ")))
+	cmd.Flags().IntVar(&opts.Limit, "limit", 0, "max number of exported messages, 0 means unlimited")
+	cmd.Flags().StringVarP(&opts.Filter, "filter", "f", "true", "filter messages by expression, defaults to match all messages. Specify '-' to see available fields")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "tdl-search", "output directory, results of each chat are exported into one file")
+	cmd.Flags().VarP(&opts.Format, "format", "F", fmt.Sprintf("output format: [%s]", strings.Join(chat.ExportFormatNames(), ", ")))
+	cmd.Flags().BoolVar(&opts.WithContent, "with-content", false, "export with message content")
+	cmd.Flags().BoolVar(&opts.Raw, "raw", false, "export raw message struct of Telegram MTProto API, useful for debugging")
+	cmd.Flags().BoolVar(&opts.All, "all", false, "export all messages including non-media messages, but still affected by filter")
+
+	return cmd
+}
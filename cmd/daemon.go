@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/fatih/color"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/spf13/cobra"
+
+	"github.com/iyear/tdl/app/daemon"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+)
+
+func NewDaemon() *cobra.Command {
+	var opts daemon.Options
+
+	cmd := &cobra.Command{
+		Use:     "daemon",
+		Short:   "Run jobs submitted by commands with --remote flag in one persistent session",
+		GroupID: groupTools.ID,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
+				return daemon.Run(logctx.Named(ctx, "daemon"), c, kvd, opts)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Listen, "listen", daemon.DefaultAddr, "address of HTTP API, format: host:port or unix:///path/to/socket")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "bearer token of HTTP API, which is required to listen on non-loopback address. A random token is generated if empty")
+
+	return cmd
+}
+
+const remote = "remote"
+
+// addRemoteFlag adds --remote flag to commands which can be submitted to daemon
+func addRemoteFlag(cmd *cobra.Command, addr *string) {
+	cmd.Flags().StringVar(addr, remote, "", "submit the command as a job to 'tdl daemon' listening on the address instead of running it. Defaults to daemon's default address if no value is given")
+	cmd.Flags().Lookup(remote).NoOptDefVal = daemon.DefaultAddr
+}
+
+func submit(ctx context.Context, addr string, typ daemon.JobType, opts any) error {
+	job, err := daemon.Submit(ctx, addr, typ, opts)
+	if err != nil {
+		return errors.Wrap(err, "submit job")
+	}
+
+	color.Green("Job %d is submitted to daemon", job.ID)
+	return nil
+}
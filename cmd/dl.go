@@ -3,19 +3,27 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gotd/td/telegram"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/iyear/tdl/app/daemon"
 	"github.com/iyear/tdl/app/dl"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/pkg/tmessage"
 )
 
 func NewDownload() *cobra.Command {
-	var opts dl.Options
+	var (
+		opts                   dl.Options
+		addr                   string
+		msgRange, since, until string
+	)
 
 	cmd := &cobra.Command{
 		Use:     "download",
@@ -23,12 +31,41 @@ func NewDownload() *cobra.Command {
 		Short:   "Download anything from Telegram (protected) chat",
 		GroupID: groupTools.ID,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(opts.URLs) == 0 && len(opts.Files) == 0 {
-				return fmt.Errorf("no urls or files provided")
+			if len(opts.URLs) == 0 && len(opts.Files) == 0 && opts.Chat.Chat == "" && len(opts.Watch) == 0 && len(opts.WebDAV) == 0 && opts.Filter != "-" {
+				return fmt.Errorf("no urls, files, chat, watched or WebDAV chats provided")
+			}
+
+			if opts.Chat.Chat == "" && (opts.Chat.Topic != 0 || msgRange != "" || since != "" || until != "") {
+				return fmt.Errorf("topic, range, since and until flags require chat flag")
+			}
+
+			var err error
+			if opts.Chat.Range, err = tmessage.ParseRange(msgRange); err != nil {
+				return err
+			}
+			if opts.Chat.Since, err = tmessage.ParseDate(since); err != nil {
+				return err
+			}
+			if opts.Chat.Until, err = tmessage.ParseUntil(until); err != nil {
+				return err
+			}
+			if !opts.Chat.Until.IsZero() && opts.Chat.Since.After(opts.Chat.Until) {
+				return fmt.Errorf("since date is after until date")
 			}
 
 			opts.Template = viper.GetString(consts.FlagDlTemplate)
 
+			if addr != "" {
+				return submit(cmd.Context(), addr, daemon.JobTypeDownload, opts)
+			}
+
+			if len(opts.Watch) > 0 {
+				w := dl.NewWatcher()
+				return tRunUpdates(cmd.Context(), w.Handler, func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
+					return w.Run(logctx.Named(ctx, "watch"), c, kvd, opts)
+				})
+			}
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return dl.Run(logctx.Named(ctx, "dl"), c, kvd, opts)
 			})
@@ -42,20 +79,43 @@ func NewDownload() *cobra.Command {
 		exclude   = "exclude"
 		_continue = "continue"
 		restart   = "restart"
+		url       = "url"
+		chat      = "chat"
+		topic     = "topic"
+		_range    = "range"
+		_since    = "since"
+		_until    = "until"
+		serve     = "serve"
+		watch     = "watch"
+		webdav    = "webdav"
+		tlsCert   = "tls-cert"
+		tlsKey    = "tls-key"
 	)
 
-	cmd.Flags().StringSliceVarP(&opts.URLs, "url", "u", []string{}, "telegram message links")
+	cmd.Flags().StringSliceVarP(&opts.URLs, url, "u", []string{}, "telegram message links")
 	cmd.Flags().StringSliceVarP(&opts.Files, file, "f", []string{}, "official client exported files")
 
+	// download from chat directly
+	cmd.Flags().StringVarP(&opts.Chat.Chat, chat, "c", "", "download media of the chat (id or domain) directly, without exported files")
+	cmd.Flags().IntVar(&opts.Chat.Topic, topic, 0, "download media of the topic or replies of the message in the chat")
+	cmd.Flags().StringVar(&msgRange, _range, "", "message id range of the chat, both inclusive. Example: 100-5000, 100-, -5000")
+	cmd.Flags().StringVar(&since, _since, "", "download media sent at or after the date. Format: timestamp, RFC3339, 'YYYY-MM-DD[ HH:MM[:SS]]' in local time")
+	cmd.Flags().StringVar(&until, _until, "", "download media sent at or before the date, same format as --since")
+
 	cmd.Flags().String(consts.FlagDlTemplate, `{{ .DialogID }}_{{ .MessageID }}_{{ filenamify .FileName }}`, "download file name template")
 
 	cmd.Flags().StringSliceVarP(&opts.Include, include, "i", []string{}, "include the specified file extensions, and only judge by file name, not file MIME. Example: -i mp4,mp3")
 	cmd.Flags().StringSliceVarP(&opts.Exclude, exclude, "e", []string{}, "exclude the specified file extensions, and only judge by file name, not file MIME. Example: -e png,jpg")
+	cmd.Flags().StringVar(&opts.Filter, "filter", "true", "filter messages by expression, defaults to match all messages. Specify '-' to see available fields")
 
 	cmd.Flags().StringVarP(&opts.Dir, dir, "d", "downloads", "specify the download directory. If the directory does not exist, it will be created automatically")
+	cmd.Flags().StringVar(&opts.Sink, "sink", "", "write files into another destination instead of --dir, which is used for temp files: '-' (stdout), 'tar:FILE', 'zip:FILE' ('-' as stdout) or 's3://bucket/prefix?endpoint=URL&region=REGION'")
 	cmd.Flags().BoolVar(&opts.RewriteExt, "rewrite-ext", false, "rewrite file extension according to file header MIME")
 	// do not match extension, because some files' extension is corrected by --rewrite-ext flag
 	cmd.Flags().BoolVar(&opts.SkipSame, "skip-same", false, "skip files with the same name(without extension) and size")
+	cmd.Flags().Var(&opts.Dedup, "dedup", fmt.Sprintf("action for media downloaded before, detected by Telegram file id and content SHA-256 across chats and runs: [%s]", strings.Join(dl.DedupNames(), ", ")))
+	cmd.Flags().BoolVar(&opts.Verify, "verify", false, "verify size and part hashes of downloaded files, then record them in SHA256SUMS of each directory")
+	cmd.Flags().StringVar(&opts.Report, "report", "", "write a report of every element to the file, JSON format by default and JSON Lines if the file ends with '.jsonl'")
 
 	cmd.Flags().BoolVar(&opts.Desc, "desc", false, "download files from the newest to the oldest ones (may affect resume download)")
 	cmd.Flags().BoolVar(&opts.Takeout, "takeout", false, "takeout sessions let you export data from your account with lower flood wait limits.")
@@ -66,8 +126,22 @@ func NewDownload() *cobra.Command {
 	cmd.Flags().BoolVar(&opts.Restart, restart, false, "restart the last download directly")
 
 	// serve flags
-	cmd.Flags().BoolVar(&opts.Serve, "serve", false, "serve the media files as a http server instead of downloading them with built-in downloader")
+	cmd.Flags().BoolVar(&opts.Serve, serve, false, "serve the media files as a http server instead of downloading them with built-in downloader")
+	cmd.Flags().StringVar(&opts.Bind, "bind", "", "http server bind address, empty means all interfaces. Set to 127.0.0.1 to serve locally only")
 	cmd.Flags().IntVar(&opts.Port, "port", 8080, "http server port")
+	cmd.Flags().BoolVar(&opts.Inline, "inline", false, "serve media inline, so browsers play them instead of downloading")
+	cmd.Flags().StringVar(&opts.Auth, "auth", "", "protect http server with basic auth, format: user:password")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "protect http server with bearer token")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", "", "key to sign media links when auth is enabled, so they can be opened without credentials. Random key is used if empty, and links are invalid after restart")
+	cmd.Flags().DurationVar(&opts.LinkExpire, "link-expire", 24*time.Hour, "expiration of signed media links")
+	cmd.Flags().StringVar(&opts.TLSCert, tlsCert, "", "TLS certificate file of http server")
+	cmd.Flags().StringVar(&opts.TLSKey, tlsKey, "", "TLS private key file of http server")
+	cmd.Flags().StringSliceVar(&opts.WebDAV, webdav, []string{}, "serve chats (id or domain) as a read-only WebDAV filesystem, files are named by download template")
+
+	// watch flags
+	cmd.Flags().StringSliceVar(&opts.Watch, watch, []string{}, "watch chats (id or domain) and download new media as they arrive, until interrupted")
+
+	addRemoteFlag(cmd, &addr)
 
 	_ = viper.BindPFlag(consts.FlagDlTemplate, cmd.Flags().Lookup(consts.FlagDlTemplate))
 
@@ -76,6 +150,20 @@ func NewDownload() *cobra.Command {
 	_ = cmd.MarkFlagDirname(dir)
 	cmd.MarkFlagsMutuallyExclusive(include, exclude)
 	cmd.MarkFlagsMutuallyExclusive(_continue, restart)
+	cmd.MarkFlagsMutuallyExclusive(watch, url)
+	cmd.MarkFlagsMutuallyExclusive(watch, chat)
+	cmd.MarkFlagsMutuallyExclusive(webdav, chat)
+	cmd.MarkFlagsMutuallyExclusive(chat, url)
+	cmd.MarkFlagsMutuallyExclusive(chat, file)
+	cmd.MarkFlagsMutuallyExclusive(watch, file)
+	cmd.MarkFlagsMutuallyExclusive(watch, serve)
+	cmd.MarkFlagsMutuallyExclusive(watch, remote)
+	cmd.MarkFlagsMutuallyExclusive(serve, remote)
+	cmd.MarkFlagsMutuallyExclusive(webdav, url)
+	cmd.MarkFlagsMutuallyExclusive(webdav, file)
+	cmd.MarkFlagsMutuallyExclusive(webdav, watch)
+	cmd.MarkFlagsMutuallyExclusive(webdav, remote)
+	cmd.MarkFlagsRequiredTogether(tlsCert, tlsKey)
 
 	return cmd
 }
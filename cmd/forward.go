@@ -8,6 +8,7 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/spf13/cobra"
 
+	"github.com/iyear/tdl/app/daemon"
 	"github.com/iyear/tdl/app/forward"
 	"github.com/iyear/tdl/core/forwarder"
 	"github.com/iyear/tdl/core/logctx"
@@ -15,13 +16,20 @@ import (
 )
 
 func NewForward() *cobra.Command {
-	var opts forward.Options
+	var (
+		opts forward.Options
+		addr string
+	)
 
 	cmd := &cobra.Command{
 		Use:     "forward",
 		Short:   "Forward messages with automatic fallback and message routing",
 		GroupID: groupTools.ID,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if addr != "" {
+				return submit(cmd.Context(), addr, daemon.JobTypeForward, opts)
+			}
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return forward.Run(logctx.Named(ctx, "forward"), c, kvd, opts)
 			})
@@ -36,6 +44,8 @@ func NewForward() *cobra.Command {
 	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "do not actually send messages, just show how they would be sent")
 	cmd.Flags().BoolVar(&opts.Single, "single", false, "do not automatically detect and forward grouped messages")
 	cmd.Flags().BoolVar(&opts.Desc, "desc", false, "forward messages in reverse order for each input peer")
+	cmd.Flags().StringVar(&opts.Report, "report", "", "write a report of every element to the file, JSON format by default and JSON Lines if the file ends with '.jsonl'")
+	addRemoteFlag(cmd, &addr)
 
 	return cmd
 }
@@ -19,14 +19,18 @@ import (
 	"golang.org/x/net/proxy"
 
 	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/middlewares/stats"
 	"github.com/iyear/tdl/core/storage"
 	tclientcore "github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/util/fsutil"
 	"github.com/iyear/tdl/core/util/logutil"
 	"github.com/iyear/tdl/core/util/netutil"
+	"github.com/iyear/tdl/pkg/bandwidth"
 	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/extensions"
 	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/metrics"
+	"github.com/iyear/tdl/pkg/prog"
 	"github.com/iyear/tdl/pkg/tclient"
 )
 
@@ -99,6 +103,26 @@ func New() *cobra.Command {
 
 			cmd.SetContext(kv.With(cmd.Context(), stg))
 
+			schedule, err := bandwidth.ParseSchedule(viper.GetString(consts.FlagRate), viper.GetStringSlice(consts.FlagRateSchedule))
+			if err != nil {
+				return errors.Wrap(err, "parse rate")
+			}
+			cmd.SetContext(bandwidth.With(cmd.Context(), schedule.Limiter(cmd.Context())))
+
+			mode, err := prog.ParseMode(viper.GetString(consts.FlagProgress))
+			if err != nil {
+				return errors.Wrap(err, "parse progress mode")
+			}
+			prog.SetMode(mode)
+
+			if addr := viper.GetString(consts.FlagMetricsAddr); addr != "" {
+				if err = metrics.Serve(cmd.Context(), addr); err != nil {
+					return errors.Wrap(err, "serve metrics")
+				}
+				// observe requests of clients created afterwards
+				cmd.SetContext(stats.With(cmd.Context(), metrics.Observer()))
+			}
+
 			// extension manager client proxy
 			var dialer proxy.ContextDialer = proxy.Direct
 			if p := viper.GetString(consts.FlagProxy); p != "" {
@@ -139,7 +163,7 @@ func New() *cobra.Command {
 
 	cmd.AddCommand(NewVersion(), NewLogin(), NewDownload(), NewForward(),
 		NewChat(), NewUpload(), NewBackup(), NewRecover(), NewMigrate(),
-		NewGen(), NewExtension(em))
+		NewGen(), NewDaemon(), NewExtension(em))
 
 	// append extension command to root
 	exts, _ := em.List(context.Background(), false)
@@ -163,6 +187,10 @@ func New() *cobra.Command {
 	cmd.PersistentFlags().IntP(consts.FlagLimit, "l", 2, "max number of concurrent tasks")
 	cmd.PersistentFlags().Int(consts.FlagPoolSize, 8, "specify the size of the DC pool, zero means infinity")
 	cmd.PersistentFlags().Duration(consts.FlagDelay, 0, "delay between each task, zero means no delay")
+	cmd.PersistentFlags().String(consts.FlagRate, "", "max transfer rate shared by all tasks, e.g. 10MiB/s, empty or zero means unlimited")
+	cmd.PersistentFlags().StringSlice(consts.FlagRateSchedule, nil, "time-of-day rates overriding the default rate, format: HH:MM-HH:MM=RATE, e.g. 09:00-18:00=1MiB/s")
+	cmd.PersistentFlags().String(consts.FlagProgress, prog.ModeAuto.String(), fmt.Sprintf("progress render mode, 'auto' renders to terminal only if stdout is a TTY: [%s]", strings.Join(prog.ModeNames(), ", ")))
+	cmd.PersistentFlags().String(consts.FlagMetricsAddr, "", "expose Prometheus metrics on http://ADDR/metrics, e.g. localhost:9090. Empty means disabled")
 
 	cmd.PersistentFlags().String(consts.FlagNTP, "", "ntp server host, if not set, use system time")
 	cmd.PersistentFlags().Duration(consts.FlagReconnectTimeout, 5*time.Minute, "Telegram client reconnection backoff timeout, infinite if set to 0") // #158
@@ -230,11 +258,25 @@ func tOptions(ctx context.Context) (tclient.Options, error) {
 }
 
 func tRun(ctx context.Context, f func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error, middlewares ...telegram.Middleware) error {
+	return tRunUpdates(ctx, nil, f, middlewares...)
+}
+
+// tRunUpdates is the same as tRun, but updates received by the client are passed to the handler built by h.
+// The handler is built with the storage of current namespace, so update state can be persisted.
+func tRunUpdates(ctx context.Context,
+	h func(ctx context.Context, kvd storage.Storage) telegram.UpdateHandler,
+	f func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error,
+	middlewares ...telegram.Middleware,
+) error {
 	o, err := tOptions(ctx)
 	if err != nil {
 		return errors.Wrap(err, "build telegram options")
 	}
 
+	if h != nil {
+		o.UpdateHandler = h(ctx, o.KV)
+	}
+
 	client, err := tclient.New(ctx, o, false, middlewares...)
 	if err != nil {
 		return errors.Wrap(err, "create client")
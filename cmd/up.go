@@ -7,13 +7,17 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/spf13/cobra"
 
+	"github.com/iyear/tdl/app/daemon"
 	"github.com/iyear/tdl/app/up"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 )
 
 func NewUpload() *cobra.Command {
-	var opts up.Options
+	var (
+		opts up.Options
+		addr string
+	)
 
 	cmd := &cobra.Command{
 		Use:     "upload",
@@ -21,13 +25,18 @@ func NewUpload() *cobra.Command {
 		Short:   "Upload anything to Telegram",
 		GroupID: groupTools.ID,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Thread != 0 && opts.Chat == "" {
+				return errors.New("error flags: --chat should be set when --topic is set")
+			}
+			if opts.Chat != "" && opts.To != "" {
+				return errors.New("conflicting flags: --chat and --to cannot be set at the same time")
+			}
+
+			if addr != "" {
+				return submit(cmd.Context(), addr, daemon.JobTypeUpload, opts)
+			}
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
-				if opts.Thread != 0 && opts.Chat == "" {
-					return errors.New("error flags: --chat should be set when --topic is set")
-				}
-				if opts.Chat != "" && opts.To != "" {
-					return errors.New("conflicting flags: --chat and --to cannot be set at the same time")
-				}
 				return up.Run(logctx.Named(ctx, "up"), c, kvd, opts)
 			})
 		},
@@ -48,6 +57,9 @@ func NewUpload() *cobra.Command {
 	cmd.Flags().BoolVar(&opts.Remove, "rm", false, "remove the uploaded files after uploading")
 	cmd.Flags().BoolVar(&opts.Photo, "photo", false, "upload the image as a photo instead of a file")
 	cmd.Flags().StringVar(&opts.Caption, "caption", `"<code>"+FileName+"</code> - <code>"+MIME+"</code>"`, "caption for the uploaded media")
+	cmd.Flags().StringVar(&opts.Report, "report", "", "write a report of every element to the file, JSON format by default and JSON Lines if the file ends with '.jsonl'")
+
+	addRemoteFlag(cmd, &addr)
 
 	// completion and validation
 	_ = cmd.MarkFlagRequired(path)
@@ -0,0 +1,20 @@
+package dcpool
+
+import (
+	"context"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+
+	"github.com/iyear/tdl/core/middlewares/stats"
+)
+
+// dcInvoker attaches DC to the context of every request
+type dcInvoker struct {
+	dc   int
+	next tg.Invoker
+}
+
+func (d dcInvoker) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	return d.next.Invoke(stats.WithDC(ctx, d.dc), input, output)
+}
@@ -89,7 +89,7 @@ func (p *pool) invoker(ctx context.Context, dc int) tg.Invoker {
 	}
 
 	p.closes[dc] = invoker.Close
-	p.invokers[dc] = chainMiddlewares(invoker, p.middlewares...)
+	p.invokers[dc] = dcInvoker{dc: dc, next: chainMiddlewares(invoker, p.middlewares...)}
 
 	return p.invokers[dc]
 }
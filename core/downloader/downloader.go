@@ -5,8 +5,10 @@ import (
 
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/logctx"
@@ -25,6 +27,10 @@ type Options struct {
 	Threads  int
 	Iter     Iter
 	Progress Progress
+	// Verify checks the written size and the part hashes provided by Telegram after each download
+	Verify bool
+	// Limiter limits the download bandwidth in bytes per second, shared by all elements. Nil means unlimited.
+	Limiter *rate.Limiter
 }
 
 func New(opts Options) *Downloader {
@@ -40,11 +46,14 @@ func (d *Downloader) Download(ctx context.Context, limit int) error {
 	for d.opts.Iter.Next(wgctx) {
 		elem := d.opts.Iter.Value()
 
-		wg.Go(func() (rerr error) {
+		wg.Go(func() error {
 			d.opts.Progress.OnAdd(elem)
-			defer func() { d.opts.Progress.OnDone(elem, rerr) }()
 
-			if err := d.download(wgctx, elem); err != nil {
+			err := d.download(wgctx, elem)
+			// report the error of element to progress, so broken files won't be treated as done
+			d.opts.Progress.OnDone(elem, err)
+
+			if err != nil {
 				// canceled by user, so we directly return error to stop all
 				if errors.Is(err, context.Canceled) {
 					return errors.Wrap(err, "download")
@@ -63,11 +72,13 @@ func (d *Downloader) Download(ctx context.Context, limit int) error {
 		})
 	}
 
-	if err := d.opts.Iter.Err(); err != nil {
-		return errors.Wrap(err, "iter")
+	// wait for running downloads even if iter fails, so their state is settled before returning
+	err := wg.Wait()
+	if iterErr := d.opts.Iter.Err(); iterErr != nil {
+		return errors.Wrap(iterErr, "iter")
 	}
 
-	return wg.Wait()
+	return err
 }
 
 func (d *Downloader) download(ctx context.Context, elem Elem) error {
@@ -85,13 +96,49 @@ func (d *Downloader) download(ctx context.Context, elem Elem) error {
 		client = d.opts.Pool.Takeout(ctx, elem.File().DC())
 	}
 
+	threads := tutil.BestThreads(elem.File().Size(), d.opts.Threads)
+	// bandwidth is taken before each part is requested
+	rpc := NewLimitedClient(client, d.opts.Limiter, elem.File().Size())
+
+	// some parts are already written, only download the missing ones
+	if r, ok := elem.(Resumable); ok {
+		if completed := completedSize(r, elem.File().Size()); completed > 0 {
+			logctx.From(ctx).Debug("Resume download elem",
+				zap.Int64("completed", completed))
+
+			w := newWriteAt(elem, d.opts.Progress, MaxPartSize, completed)
+			err := downloadParts(ctx, rpc, elem, r, threads, w)
+			if err == nil {
+				return d.verify(ctx, client, elem, w)
+			}
+			if !errors.Is(err, errCDNRedirect) {
+				return errors.Wrap(err, "download parts")
+			}
+
+			// parts can't be requested one by one from CDN, so download the whole file again
+			logctx.From(ctx).Debug("Resume is not supported by CDN, download from the beginning")
+		}
+	}
+
+	w := newWriteAt(elem, d.opts.Progress, MaxPartSize, 0)
 	_, err := downloader.NewDownloader().WithPartSize(MaxPartSize).
-		Download(client, elem.File().Location()).
-		WithThreads(tutil.BestThreads(elem.File().Size(), d.opts.Threads)).
-		Parallel(ctx, newWriteAt(elem, d.opts.Progress, MaxPartSize))
+		Download(rpc, elem.File().Location()).
+		WithThreads(threads).
+		Parallel(ctx, w)
 	if err != nil {
 		return errors.Wrap(err, "download")
 	}
 
+	return d.verify(ctx, client, elem, w)
+}
+
+func (d *Downloader) verify(ctx context.Context, client *tg.Client, elem Elem, w *writeAt) error {
+	if !d.opts.Verify {
+		return nil
+	}
+
+	if err := verify(ctx, client, elem, w.downloaded.Load()); err != nil {
+		return errors.Wrap(err, "verify")
+	}
 	return nil
 }
@@ -25,3 +25,13 @@ type File interface {
 	Size() int64
 	DC() int
 }
+
+// Resumable is an optional interface of Elem to support byte-level resume.
+// Parts reported as completed are skipped, and every written part is reported back,
+// so that partially downloaded files can be continued from where they were interrupted.
+type Resumable interface {
+	// Completed reports whether the part at offset has already been written to To()
+	Completed(offset int64) bool
+	// Complete marks the part at offset as written
+	Complete(offset int64)
+}
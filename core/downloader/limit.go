@@ -0,0 +1,40 @@
+package downloader
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"golang.org/x/time/rate"
+
+	"github.com/iyear/tdl/core/util/rateutil"
+)
+
+// LimitedClient waits for bandwidth before requesting each part, so bytes are
+// consumed from limiter before they are transferred instead of after they arrive.
+// Nil limiter never blocks.
+type LimitedClient struct {
+	*tg.Client
+	limiter *rate.Limiter
+	size    int64 // file size, so the last part only takes the bytes it really has
+}
+
+func NewLimitedClient(client *tg.Client, limiter *rate.Limiter, size int64) *LimitedClient {
+	return &LimitedClient{
+		Client:  client,
+		limiter: limiter,
+		size:    size,
+	}
+}
+
+func (c *LimitedClient) UploadGetFile(ctx context.Context, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	n := int64(req.Limit)
+	if remain := c.size - req.Offset; remain < n {
+		n = max(remain, 0)
+	}
+
+	if err := rateutil.WaitN(ctx, c.limiter, int(n)); err != nil {
+		return nil, err
+	}
+
+	return c.Client.UploadGetFile(ctx, req)
+}
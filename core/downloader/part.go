@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"golang.org/x/sync/errgroup"
+)
+
+// errCDNRedirect is returned if the file is served by CDN, which can only be downloaded by downloader of gotd
+var errCDNRedirect = errors.New("file is redirected to CDN")
+
+// downloadParts downloads only the parts which are not completed yet.
+//
+// The parts are requested directly with upload.getFile, because the downloader of gotd
+// always starts from the beginning of the file.
+func downloadParts(ctx context.Context, client *LimitedClient, elem Elem, r Resumable, threads int, w *writeAt) error {
+	size := elem.File().Size()
+	offsets := make(chan int64)
+
+	wg, wgctx := errgroup.WithContext(ctx)
+
+	wg.Go(func() error {
+		defer close(offsets)
+
+		for offset := int64(0); offset < size; offset += MaxPartSize {
+			if r.Completed(offset) {
+				continue
+			}
+
+			select {
+			case <-wgctx.Done():
+				return wgctx.Err()
+			case offsets <- offset:
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < threads; i++ {
+		wg.Go(func() error {
+			for offset := range offsets {
+				if err := downloadPart(wgctx, client, elem.File().Location(), offset, w); err != nil {
+					return errors.Wrapf(err, "part %d", offset/MaxPartSize)
+				}
+			}
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+func downloadPart(ctx context.Context, client *LimitedClient, loc tg.InputFileLocationClass, offset int64, w *writeAt) error {
+	// offset is always aligned to MaxPartSize, so we don't need precise mode
+	f, err := client.UploadGetFile(ctx, &tg.UploadGetFileRequest{
+		Location: loc,
+		Offset:   offset,
+		Limit:    MaxPartSize,
+	})
+	if err != nil {
+		return errors.Wrap(err, "get file")
+	}
+
+	var file *tg.UploadFile
+	switch f := f.(type) {
+	case *tg.UploadFile:
+		file = f
+	case *tg.UploadFileCDNRedirect:
+		return errCDNRedirect
+	default:
+		return errors.Errorf("unexpected type %T", f)
+	}
+
+	_, err = w.WriteAt(file.Bytes, offset)
+	return err
+}
+
+// completedSize returns the total size of completed parts
+func completedSize(r Resumable, size int64) int64 {
+	completed := int64(0)
+	for offset := int64(0); offset < size; offset += MaxPartSize {
+		if r.Completed(offset) {
+			completed += min(MaxPartSize, size-offset)
+		}
+	}
+	return completed
+}
@@ -29,12 +29,12 @@ type writeAt struct {
 	downloaded *atomic.Int64
 }
 
-func newWriteAt(elem Elem, progress Progress, partSize int) *writeAt {
+func newWriteAt(elem Elem, progress Progress, partSize int, downloaded int64) *writeAt {
 	return &writeAt{
 		elem:       elem,
 		progress:   progress,
 		partSize:   partSize,
-		downloaded: atomic.NewInt64(0),
+		downloaded: atomic.NewInt64(downloaded),
 	}
 }
 
@@ -44,6 +44,10 @@ func (w *writeAt) WriteAt(p []byte, off int64) (int, error) {
 		return 0, err
 	}
 
+	if r, ok := w.elem.(Resumable); ok {
+		r.Complete(off)
+	}
+
 	// some small files may finish too fast, terminal history may not be overwritten
 	// this is just a simple way to avoid the problem
 	if at < w.partSize { //  last part(every file only exec once)
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+)
+
+// hashesUnavailable are errors of upload.getFileHashes that mean the location has no hashes,
+// other errors like FLOOD_WAIT must not be treated as passed
+var hashesUnavailable = []string{"LOCATION_INVALID", "CDN_METHOD_INVALID"}
+
+// ErrVerify is returned when the downloaded file doesn't match the size or hashes provided by Telegram
+var ErrVerify = errors.New("verification failed")
+
+// verify checks the written size, then checks every part with upload.getFileHashes if To() is readable.
+func verify(ctx context.Context, client *tg.Client, elem Elem, written int64) error {
+	size := elem.File().Size()
+	if written != size {
+		return errors.Wrapf(ErrVerify, "written %d bytes, expected %d", written, size)
+	}
+
+	r, ok := elem.To().(io.ReaderAt)
+	if !ok {
+		return nil
+	}
+
+	buf := make([]byte, 0, MaxPartSize)
+	for offset := int64(0); offset < size; {
+		hashes, err := client.UploadGetFileHashes(ctx, &tg.UploadGetFileHashesRequest{
+			Location: elem.File().Location(),
+			Offset:   offset,
+		})
+		if err != nil {
+			// hashes are not available for all locations, e.g. photos
+			if tgerr.Is(err, hashesUnavailable...) {
+				unverified(ctx, elem, offset, err.Error())
+				return nil
+			}
+			return errors.Wrap(err, "get file hashes")
+		}
+		if len(hashes) == 0 {
+			unverified(ctx, elem, offset, "no hashes")
+			return nil
+		}
+
+		start := offset
+		for _, h := range hashes {
+			n := min(int64(h.Limit), size-h.Offset)
+			if n <= 0 {
+				continue
+			}
+			if int64(cap(buf)) < n {
+				buf = make([]byte, 0, n)
+			}
+
+			b := buf[:n]
+			if _, err = r.ReadAt(b, h.Offset); err != nil {
+				return errors.Wrapf(err, "read part at %d", h.Offset)
+			}
+			if sum := sha256.Sum256(b); !bytes.Equal(sum[:], h.Hash) {
+				return errors.Wrapf(ErrVerify, "hash mismatch at offset %d", h.Offset)
+			}
+
+			if next := h.Offset + int64(h.Limit); next > offset {
+				offset = next
+			}
+		}
+		// no progress is made, stop instead of requesting the same hashes again
+		if offset == start {
+			unverified(ctx, elem, offset, "hashes don't cover the rest of file")
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// unverified warns that parts from offset are not checked by hashes, only the size is checked
+func unverified(ctx context.Context, elem Elem, offset int64, reason string) {
+	logctx.From(ctx).Warn("Part hashes are unavailable, only size is verified",
+		zap.Any("location", elem.File().Location()),
+		zap.Int64("offset", offset),
+		zap.String("reason", reason))
+}
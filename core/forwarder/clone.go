@@ -15,6 +15,7 @@ import (
 	tdownloader "github.com/iyear/tdl/core/downloader"
 	"github.com/iyear/tdl/core/tmedia"
 	tuploader "github.com/iyear/tdl/core/uploader"
+	"github.com/iyear/tdl/core/util/rateutil"
 	"github.com/iyear/tdl/core/util/tutil"
 )
 
@@ -50,7 +51,8 @@ func (f *Forwarder) cloneMedia(ctx context.Context, opts cloneOptions, dryRun bo
 
 	_, err = downloader.NewDownloader().
 		WithPartSize(tdownloader.MaxPartSize).
-		Download(f.opts.Pool.Client(ctx, opts.media.DC), opts.media.InputFileLoc).
+		Download(tdownloader.NewLimitedClient(f.opts.Pool.Client(ctx, opts.media.DC), f.opts.Limiter, opts.media.Size),
+			opts.media.InputFileLoc).
 		WithThreads(threads).
 		Parallel(ctx, writeAt{
 			f:    temp,
@@ -66,7 +68,8 @@ func (f *Forwarder) cloneMedia(ctx context.Context, opts cloneOptions, dryRun bo
 		return nil, errors.Wrap(err, "seek")
 	}
 
-	upload := uploader.NewUpload(opts.media.Name, temp, opts.media.Size)
+	upload := uploader.NewUpload(opts.media.Name,
+		rateutil.NewReader(ctx, temp, f.opts.Limiter), opts.media.Size)
 	file, err = uploader.NewUploader(f.opts.Pool.Default(ctx)).
 		WithPartSize(tuploader.MaxPartSize).
 		WithThreads(threads).
@@ -11,6 +11,7 @@ import (
 	"github.com/gotd/td/tg"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
 	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/logctx"
@@ -29,6 +30,8 @@ type Options struct {
 	Threads  int
 	Iter     Iter
 	Progress Progress
+	// Limiter limits the bandwidth of cloning media in bytes per second. Nil means unlimited.
+	Limiter *rate.Limiter
 }
 
 type Forwarder struct {
@@ -135,34 +138,15 @@ func (f *Forwarder) forwardMessage(ctx context.Context, elem Elem, grouped ...*t
 		return nil
 	}
 
-	convForwardedMedia := func(msg *tg.Message) (tg.InputMediaClass, error) {
-		if _, hasMedia := msg.GetMedia(); !hasMedia {
-			// media can't be forwarded via simple copy(it depends on the server ids)
-			// if it's not a media message, just break and send text copy
-			return nil, errors.Errorf("message %d is not a media message", msg.ID)
-		}
-
-		// if it's a media message, but it's not protected, convert it to InputMediaClass
-		// or if it's protected, but it doesn't contain photo or document,
-
-		// we should clone photo and document via re-upload, it will be banned if we forward it directly.
-		// but other media can be forwarded directly via copy
-		if (!protectedDialog(elem.From()) && !protectedMessage(msg)) || !photoOrDocument(msg.Media) {
-			media, ok := tmedia.ConvInputMedia(msg.Media)
-			if !ok {
-				return nil, errors.Errorf("can't convert message %d to input class directly", msg.ID)
-			}
-			return media, nil
-		}
-
-		media, ok := tmedia.GetMedia(msg)
+	cloneUnit := func(msg *tg.Message, unit tg.MessageMediaClass) (tg.InputMediaClass, error) {
+		media, ok := tmedia.ExtractMedia(unit)
 		if !ok {
 			log.Warn("Can't get media from message",
 				zap.Int64("peer", elem.From().ID()),
 				zap.Int("message", msg.ID))
 
 			// unsupported re-upload media
-			return nil, errors.Errorf("unsupported media %T", msg.Media)
+			return nil, errors.Errorf("unsupported media %T", unit)
 		}
 
 		mediaFile, err := f.cloneMedia(ctx, cloneOptions{
@@ -181,7 +165,7 @@ func (f *Forwarder) forwardMessage(ctx context.Context, elem Elem, grouped ...*t
 
 		var inputMedia tg.InputMediaClass
 		// now we only have to process cloned photo or document
-		switch m := msg.Media.(type) {
+		switch m := unit.(type) {
 		case *tg.MessageMediaPhoto:
 			photo := &tg.InputMediaUploadedPhoto{
 				Spoiler:    m.Spoiler,
@@ -225,7 +209,7 @@ func (f *Forwarder) forwardMessage(ctx context.Context, elem Elem, grouped ...*t
 
 			inputMedia = document
 		default:
-			return nil, errors.Errorf("unsupported media %T", msg.Media)
+			return nil, errors.Errorf("unsupported media %T", unit)
 		}
 
 		// note that they must be separately uploaded using messages uploadMedia first,
@@ -246,6 +230,82 @@ func (f *Forwarder) forwardMessage(ctx context.Context, elem Elem, grouped ...*t
 		return inputMedia, nil
 	}
 
+	// convForwardedMedia returns several media if it's a container like paid media
+	convForwardedMedia := func(msg *tg.Message) ([]tg.InputMediaClass, error) {
+		if _, hasMedia := msg.GetMedia(); !hasMedia {
+			// media can't be forwarded via simple copy(it depends on the server ids)
+			// if it's not a media message, just break and send text copy
+			return nil, errors.Errorf("message %d is not a media message", msg.ID)
+		}
+
+		// if it's a media message, but it's not protected, convert it to InputMediaClass
+		// or if it's protected, but it doesn't contain photo or document,
+
+		// we should clone photo and document via re-upload, it will be banned if we forward it directly.
+		// but other media can be forwarded directly via copy
+		if (!protectedDialog(elem.From()) && !protectedMessage(msg)) || !cloneable(msg.Media) {
+			media, ok := tmedia.ConvInputMedia(msg.Media)
+			if ok {
+				return []tg.InputMediaClass{media}, nil
+			}
+
+			// containers like story and paid media can't be sent directly, so their photos and documents are cloned
+			if !cloneable(msg.Media) || photoOrDocument(msg.Media) {
+				return nil, errors.Errorf("can't convert message %d to input class directly", msg.ID)
+			}
+		}
+
+		units := tmedia.Flatten(msg.Media)
+		medias := make([]tg.InputMediaClass, 0, len(units))
+		for _, unit := range units {
+			media, err := cloneUnit(msg, unit)
+			if err != nil {
+				return nil, err
+			}
+			medias = append(medias, media)
+		}
+
+		return medias, nil
+	}
+
+	sendMultiMedia := func(media []tg.InputSingleMedia) error {
+		req := &tg.MessagesSendMultiMediaRequest{
+			Silent:                 elem.AsSilent(),
+			Background:             false,
+			ClearDraft:             false,
+			Noforwards:             false,
+			UpdateStickersetsOrder: false,
+			Peer:                   elem.To().InputPeer(),
+			ReplyTo:                getReplyTo(elem.Thread()),
+			MultiMedia:             media,
+			ScheduleDate:           0,
+			SendAs:                 nil,
+		}
+		req.SetFlags()
+		if _, err := f.forwardClient(ctx, elem).MessagesSendMultiMedia(ctx, req); err != nil {
+			return errors.Wrap(err, "send multi media")
+		}
+		return nil
+	}
+
+	// singleMedia converts media of message to album items, and only the first one has caption
+	singleMedia := func(msg *tg.Message, medias []tg.InputMediaClass) []tg.InputSingleMedia {
+		res := make([]tg.InputSingleMedia, 0, len(medias))
+		for i, m := range medias {
+			single := tg.InputSingleMedia{
+				Media:    m,
+				RandomID: f.rand.Int63(),
+			}
+			if i == 0 {
+				single.Message, single.Entities = msg.Message, msg.Entities
+			}
+			single.SetFlags()
+
+			res = append(res, single)
+		}
+		return res
+	}
+
 	switch elem.Mode() {
 	case ModeDirect:
 		// it can be forwarded via API
@@ -308,45 +368,26 @@ func (f *Forwarder) forwardMessage(ctx context.Context, elem Elem, grouped ...*t
 					continue
 				}
 
-				single := tg.InputSingleMedia{
-					Media:    m,
-					RandomID: f.rand.Int63(),
-					Message:  gm.Message,
-					Entities: gm.Entities,
-				}
-				single.SetFlags()
-
-				media = append(media, single)
+				media = append(media, singleMedia(gm, m)...)
 			}
 
 			if len(media) > 0 {
-				req := &tg.MessagesSendMultiMediaRequest{
-					Silent:                 elem.AsSilent(),
-					Background:             false,
-					ClearDraft:             false,
-					Noforwards:             false,
-					UpdateStickersetsOrder: false,
-					Peer:                   elem.To().InputPeer(),
-					ReplyTo:                getReplyTo(elem.Thread()),
-					MultiMedia:             media,
-					ScheduleDate:           0,
-					SendAs:                 nil,
-				}
-				req.SetFlags()
-				if _, err := f.forwardClient(ctx, elem).MessagesSendMultiMedia(ctx, req); err != nil {
-					return errors.Wrap(err, "send multi media")
-				}
-				return nil
+				return sendMultiMedia(media)
 			}
 
 			return forwardTextOnly(elem.Msg())
 		}
 
-		media, err := convForwardedMedia(elem.Msg())
+		medias, err := convForwardedMedia(elem.Msg())
 		if err != nil {
 			log.Debug("Can't convert forwarded media", zap.Error(err))
 			return forwardTextOnly(elem.Msg())
 		}
+		// items of paid media are sent as album
+		if len(medias) > 1 {
+			return sendMultiMedia(singleMedia(elem.Msg(), medias))
+		}
+		media := medias[0]
 		// send text copy with forwarded media
 		req := &tg.MessagesSendMediaRequest{
 			Silent:                 elem.AsSilent(),
@@ -429,6 +470,17 @@ func protectedMessage(msg *tg.Message) bool {
 	return msg.GetNoforwards()
 }
 
+// cloneable reports whether photos and documents of media can be cloned via re-upload.
+// Web page is excluded, because its preview is generated again from the message text.
+func cloneable(media tg.MessageMediaClass) bool {
+	switch media.(type) {
+	case *tg.MessageMediaPhoto, *tg.MessageMediaDocument, *tg.MessageMediaStory, *tg.MessageMediaPaidMedia:
+		return len(tmedia.Flatten(media)) > 0
+	default:
+		return false
+	}
+}
+
 func photoOrDocument(media tg.MessageMediaClass) bool {
 	switch media.(type) {
 	case *tg.MessageMediaPhoto, *tg.MessageMediaDocument:
@@ -442,22 +494,25 @@ func mediaSizeSum(msg *tg.Message, grouped ...*tg.Message) (int64, error) {
 	if len(grouped) > 0 {
 		total := int64(0)
 		for _, gm := range grouped {
-			m, ok := tmedia.GetMedia(gm)
-			if !ok {
+			medias := tmedia.GetMedias(gm)
+			if len(medias) == 0 {
 				return 0, errors.Errorf("can't get media from message %d", gm.ID)
 			}
-			total += m.Size
+			for _, m := range medias {
+				total += m.Size
+			}
 		}
 
 		return total, nil
 	}
 
-	m, ok := tmedia.GetMedia(msg)
-	if !ok { // maybe it's a text only message
-		return 0, nil
+	// maybe it's a text only message
+	total := int64(0)
+	for _, m := range tmedia.GetMedias(msg) {
+		total += m.Size
 	}
 
-	return m.Size, nil
+	return total, nil
 }
 
 func getReplyTo(thread int) tg.InputReplyToClass {
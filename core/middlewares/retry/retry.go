@@ -49,6 +49,11 @@ func (r retry) Handle(next tg.Invoker) telegram.InvokeFunc {
 	}
 }
 
+// Retryable reports whether err is an internal server error which is always retried
+func Retryable(err error) bool {
+	return tgerr.Is(err, internalErrors...)
+}
+
 // New returns middleware that retries request if it fails with one of provided errors.
 func New(max int, errors ...string) telegram.Middleware {
 	return retry{
@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+)
+
+// Observer receives the result of every RPC attempt, including the ones retried by outer middlewares
+type Observer interface {
+	Observe(ctx context.Context, r Request)
+}
+
+type Request struct {
+	DC       int // zero if the request is not invoked by dcpool
+	Method   string
+	Duration time.Duration
+	Err      error
+}
+
+type (
+	ctxKey struct{}
+	dcKey  struct{}
+)
+
+// With returns a context carrying the observer, which is picked up by New
+func With(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, ctxKey{}, o)
+}
+
+// From returns the observer in ctx, nil if not set
+func From(ctx context.Context) Observer {
+	o, _ := ctx.Value(ctxKey{}).(Observer)
+	return o
+}
+
+// WithDC returns a context carrying the DC which invokes the request, so requests of different DCs can be told apart
+func WithDC(ctx context.Context, dc int) context.Context {
+	return context.WithValue(ctx, dcKey{}, dc)
+}
+
+// DC returns the DC carried by ctx
+func DC(ctx context.Context) (int, bool) {
+	dc, ok := ctx.Value(dcKey{}).(int)
+	return dc, ok
+}
+
+// New returns middleware that reports every request to the observer carried by ctx.
+// It should be the innermost middleware to observe every attempt. If no observer is set, requests are passed through.
+func New(ctx context.Context) telegram.Middleware {
+	o := From(ctx)
+
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		if o == nil {
+			return next.Invoke
+		}
+
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+
+			r := Request{
+				Method:   "unknown",
+				Duration: time.Since(start),
+				Err:      err,
+			}
+			if dc, ok := DC(ctx); ok {
+				r.DC = dc
+			}
+			if t, ok := input.(interface{ TypeName() string }); ok {
+				r.Method = t.TypeName()
+			}
+
+			o.Observe(ctx, r)
+			return err
+		}
+	})
+}
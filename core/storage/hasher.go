@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gotd/td/telegram/updates"
+
+	"github.com/iyear/tdl/core/storage/keygen"
+)
+
+type AccessHasher struct {
+	kv Storage
+}
+
+func NewAccessHasher(kv Storage) updates.ChannelAccessHasher {
+	return &AccessHasher{kv: kv}
+}
+
+func (a *AccessHasher) SetChannelAccessHash(ctx context.Context, userID, channelID, accessHash int64) error {
+	return a.kv.Set(ctx, a.key(userID, channelID), []byte(strconv.FormatInt(accessHash, 10)))
+}
+
+func (a *AccessHasher) GetChannelAccessHash(ctx context.Context, userID, channelID int64) (int64, bool, error) {
+	data, err := a.kv.Get(ctx, a.key(userID, channelID))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	hash, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return hash, true, nil
+}
+
+func (a *AccessHasher) key(userID, channelID int64) string {
+	return keygen.New("hash", strconv.FormatInt(userID, 10), strconv.FormatInt(channelID, 10))
+}
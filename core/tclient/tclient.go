@@ -18,6 +18,7 @@ import (
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/middlewares/recovery"
 	"github.com/iyear/tdl/core/middlewares/retry"
+	"github.com/iyear/tdl/core/middlewares/stats"
 	"github.com/iyear/tdl/core/util/netutil"
 	"github.com/iyear/tdl/core/util/tutil"
 )
@@ -41,7 +42,7 @@ type Options struct {
 }
 
 // New creates new telegram client with given options.
-// Default middlewares(retry, recovery, flood wait) always added.
+// Default middlewares(retry, recovery, flood wait, stats) always added.
 func New(ctx context.Context, o Options) (*telegram.Client, error) {
 	// process clock
 	tclock := tdclock.System
@@ -92,6 +93,7 @@ func NewDefaultMiddlewares(ctx context.Context, timeout time.Duration) []telegra
 		recovery.New(ctx, newBackoff(timeout)),
 		retry.New(5),
 		floodwait.NewSimpleWaiter(),
+		stats.New(ctx),
 	}
 }
 
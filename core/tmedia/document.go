@@ -13,7 +13,7 @@ func GetDocumentInfo(doc *tg.MessageMediaDocument) (*Media, bool) {
 		return nil, false
 	}
 
-	return &Media{
+	m := &Media{
 		InputFileLoc: &tg.InputDocumentFileLocation{
 			ID:            d.ID,
 			AccessHash:    d.AccessHash,
@@ -23,7 +23,46 @@ func GetDocumentInfo(doc *tg.MessageMediaDocument) (*Media, bool) {
 		Size: d.Size,
 		DC:   d.DCID,
 		Date: int64(d.Date),
-	}, true
+		MIME: d.MimeType,
+		Kind: KindDocument,
+	}
+	fillDocumentAttributes(m, d.Attributes)
+
+	return m, true
+}
+
+// fillDocumentAttributes sets kind, duration and resolution of media by document attributes
+func fillDocumentAttributes(m *Media, attrs []tg.DocumentAttributeClass) {
+	animated, sticker := false, false
+
+	for _, attr := range attrs {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeVideo:
+			m.Kind, m.Duration, m.Width, m.Height = KindVideo, a.Duration, a.W, a.H
+			if a.RoundMessage {
+				m.Kind = KindRound
+			}
+		case *tg.DocumentAttributeAudio:
+			m.Kind, m.Duration = KindAudio, float64(a.Duration)
+			if a.Voice {
+				m.Kind = KindVoice
+			}
+		case *tg.DocumentAttributeImageSize:
+			m.Width, m.Height = a.W, a.H
+		case *tg.DocumentAttributeAnimated:
+			animated = true
+		case *tg.DocumentAttributeSticker, *tg.DocumentAttributeCustomEmoji:
+			sticker = true
+		}
+	}
+
+	// animated stickers and gifs also have video attribute
+	switch {
+	case sticker:
+		m.Kind = KindSticker
+	case animated:
+		m.Kind = KindGIF
+	}
 }
 
 func GetDocumentName(doc *tg.Document) string {
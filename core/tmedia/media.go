@@ -4,38 +4,131 @@ import (
 	"github.com/gotd/td/tg"
 )
 
+// Kinds of media
+const (
+	KindPhoto    = "photo"
+	KindVideo    = "video"
+	KindRound    = "round" // round video message
+	KindAudio    = "audio"
+	KindVoice    = "voice"
+	KindGIF      = "gif"
+	KindSticker  = "sticker"
+	KindDocument = "document"
+)
+
 type Media struct {
 	InputFileLoc tg.InputFileLocationClass // mtproto file location of the media file
 	Name         string                    // file name
 	Size         int64                     // size in bytes
 	DC           int                       // which DC the media is stored
 	Date         int64                     // media creation(upload) timestamp
+	MIME         string                    // MIME type
+	Kind         string                    // one of Kind* constants
+	Duration     float64                   // duration of video and audio in seconds
+	Width        int                       // resolution of photo and video
+	Height       int
 }
 
+// ExtractMedia returns the first media of message media
 func ExtractMedia(m tg.MessageMediaClass) (*Media, bool) {
+	medias := ExtractMedias(m)
+	if len(medias) == 0 {
+		return nil, false
+	}
+	return medias[0], true
+}
+
+// ExtractMedias returns all downloadable media of message media, including items of paid media
+func ExtractMedias(m tg.MessageMediaClass) []*Media {
+	medias := make([]*Media, 0, 1)
+	for _, u := range Flatten(m) {
+		var (
+			media *Media
+			ok    bool
+		)
+		switch u := u.(type) {
+		case *tg.MessageMediaPhoto:
+			media, ok = GetPhotoInfo(u)
+		case *tg.MessageMediaDocument:
+			media, ok = GetDocumentInfo(u)
+		}
+		if ok {
+			medias = append(medias, media)
+		}
+	}
+	return medias
+}
+
+// Flatten unwraps media containers(story, paid media, invoice and web page) into photos and documents
+func Flatten(m tg.MessageMediaClass) []tg.MessageMediaClass {
 	switch m := m.(type) {
-	case *tg.MessageMediaPhoto:
-		return GetPhotoInfo(m)
-	case *tg.MessageMediaDocument:
-		return GetDocumentInfo(m)
+	case *tg.MessageMediaPhoto, *tg.MessageMediaDocument:
+		return []tg.MessageMediaClass{m}
 	case *tg.MessageMediaInvoice:
-		return GetExtendedMedia(m.ExtendedMedia)
+		return flattenExtended(m.ExtendedMedia)
+	case *tg.MessageMediaPaidMedia:
+		return flattenExtended(m.ExtendedMedia...)
+	case *tg.MessageMediaStory:
+		// story is only set if it's fetched by stories.getStoriesByID or sent with message
+		story, ok := m.GetStory()
+		if !ok {
+			return nil
+		}
+		item, ok := story.(*tg.StoryItem)
+		if !ok {
+			return nil
+		}
+		return Flatten(item.Media)
+	case *tg.MessageMediaWebPage:
+		page, ok := m.Webpage.(*tg.WebPage)
+		if !ok {
+			return nil
+		}
+
+		res := make([]tg.MessageMediaClass, 0, 2)
+		if photo, ok := page.GetPhoto(); ok {
+			res = append(res, &tg.MessageMediaPhoto{Photo: photo})
+		}
+		if doc, ok := page.GetDocument(); ok {
+			res = append(res, &tg.MessageMediaDocument{Document: doc})
+		}
+		return res
+	}
+	return nil
+}
+
+func flattenExtended(mm ...tg.MessageExtendedMediaClass) []tg.MessageMediaClass {
+	res := make([]tg.MessageMediaClass, 0, len(mm))
+	for _, m := range mm {
+		// preview of paid media can't be downloaded
+		if e, ok := m.(*tg.MessageExtendedMedia); ok {
+			res = append(res, Flatten(e.Media)...)
+		}
 	}
-	return nil, false
+	return res
 }
 
 func GetMedia(msg tg.MessageClass) (*Media, bool) {
+	medias := GetMedias(msg)
+	if len(medias) == 0 {
+		return nil, false
+	}
+	return medias[0], true
+}
+
+// GetMedias returns all downloadable media of message
+func GetMedias(msg tg.MessageClass) []*Media {
 	mm, ok := msg.(*tg.Message)
 	if !ok {
-		return nil, false
+		return nil
 	}
 
 	media, ok := mm.GetMedia()
 	if !ok {
-		return nil, false
+		return nil
 	}
 
-	return ExtractMedia(media)
+	return ExtractMedias(media)
 }
 
 func GetExtendedMedia(mm tg.MessageExtendedMediaClass) (*Media, bool) {
@@ -52,7 +145,7 @@ func GetDocumentThumb(doc *tg.Document) (*Media, bool) {
 		return nil, false
 	}
 
-	photoSize := &tg.PhotoSize{}
+	var photoSize *tg.PhotoSize
 	for _, t := range thumbs {
 		if p, ok := t.(*tg.PhotoSize); ok {
 			photoSize = p
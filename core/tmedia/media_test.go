@@ -0,0 +1,77 @@
+package tmedia
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func photo(id int64) *tg.MessageMediaPhoto {
+	return &tg.MessageMediaPhoto{Photo: &tg.Photo{
+		ID:    id,
+		Sizes: []tg.PhotoSizeClass{&tg.PhotoSize{Type: "y", W: 1280, H: 720, Size: 1024}},
+	}}
+}
+
+func document(id int64, name string) *tg.MessageMediaDocument {
+	return &tg.MessageMediaDocument{Document: &tg.Document{
+		ID:         id,
+		MimeType:   "video/mp4",
+		Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeFilename{FileName: name}},
+	}}
+}
+
+func TestExtractMedias(t *testing.T) {
+	story := &tg.MessageMediaStory{Peer: &tg.PeerUser{UserID: 1}, ID: 5}
+	story.SetStory(&tg.StoryItem{ID: 5, Media: document(4, "story.mp4")})
+
+	page := &tg.WebPage{}
+	page.SetPhoto(photo(1).Photo)
+	page.SetDocument(document(2, "a.mp4").Document)
+
+	tests := []struct {
+		name  string
+		media tg.MessageMediaClass
+		want  []string
+	}{
+		{name: "photo", media: photo(1), want: []string{"1.jpg"}},
+		{name: "document", media: document(2, "a.mp4"), want: []string{"a.mp4"}},
+		{name: "paid media", media: &tg.MessageMediaPaidMedia{ExtendedMedia: []tg.MessageExtendedMediaClass{
+			&tg.MessageExtendedMedia{Media: photo(1)},
+			&tg.MessageExtendedMediaPreview{}, // not purchased
+			&tg.MessageExtendedMedia{Media: document(2, "a.mp4")},
+		}}, want: []string{"1.jpg", "a.mp4"}},
+		{name: "paid media preview", media: &tg.MessageMediaPaidMedia{ExtendedMedia: []tg.MessageExtendedMediaClass{
+			&tg.MessageExtendedMediaPreview{},
+		}}, want: []string{}},
+		{name: "invoice", media: &tg.MessageMediaInvoice{
+			ExtendedMedia: &tg.MessageExtendedMedia{Media: document(3, "b.mp4")},
+		}, want: []string{"b.mp4"}},
+		{name: "invoice without media", media: &tg.MessageMediaInvoice{}, want: []string{}},
+		{name: "web page", media: &tg.MessageMediaWebPage{Webpage: page}, want: []string{"1.jpg", "a.mp4"}},
+		{name: "web page without media", media: &tg.MessageMediaWebPage{Webpage: &tg.WebPage{}}, want: []string{}},
+		{name: "pending web page", media: &tg.MessageMediaWebPage{Webpage: &tg.WebPagePending{}}, want: []string{}},
+		{name: "story", media: story, want: []string{"story.mp4"}},
+		// forwarded stories don't carry the story, which should be fetched by id
+		{name: "story without item", media: &tg.MessageMediaStory{Peer: &tg.PeerUser{UserID: 1}, ID: 5}, want: []string{}},
+		{name: "geo", media: &tg.MessageMediaGeo{}, want: []string{}},
+		{name: "nil", media: nil, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := len(Flatten(tt.media)); n != len(tt.want) {
+				t.Errorf("Flatten() returns %d media, want %d", n, len(tt.want))
+			}
+
+			names := make([]string, 0)
+			for _, m := range ExtractMedias(tt.media) {
+				names = append(names, m.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("ExtractMedias() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
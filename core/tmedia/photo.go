@@ -16,6 +16,15 @@ func GetPhotoInfo(photo *tg.MessageMediaPhoto) (*Media, bool) {
 	if !ok {
 		return nil, false
 	}
+
+	w, h := 0, 0
+	switch s := p.Sizes[len(p.Sizes)-1].(type) {
+	case *tg.PhotoSize:
+		w, h = s.W, s.H
+	case *tg.PhotoSizeProgressive:
+		w, h = s.W, s.H
+	}
+
 	return &Media{
 		InputFileLoc: &tg.InputPhotoFileLocation{
 			ID:            p.ID,
@@ -24,10 +33,14 @@ func GetPhotoInfo(photo *tg.MessageMediaPhoto) (*Media, bool) {
 			ThumbSize:     tp,
 		},
 		// Telegram photo is compressed, and extension is always jpg.
-		Name: strconv.FormatInt(p.ID, 10) + ".jpg", // unique name
-		Size: int64(size),
-		DC:   p.DCID,
-		Date: int64(p.Date),
+		Name:   strconv.FormatInt(p.ID, 10) + ".jpg", // unique name
+		Size:   int64(size),
+		DC:     p.DCID,
+		Date:   int64(p.Date),
+		MIME:   "image/jpeg",
+		Kind:   KindPhoto,
+		Width:  w,
+		Height: h,
 	}, true
 }
 
@@ -42,3 +55,41 @@ func GetPhotoSize(sizes []tg.PhotoSizeClass) (string, int, bool) {
 
 	return "", 0, false
 }
+
+// GetPhotoThumb returns the largest size of photo which fits in a thumbnail
+func GetPhotoThumb(photo *tg.MessageMediaPhoto) (*Media, bool) {
+	p, ok := photo.Photo.(*tg.Photo)
+	if !ok {
+		return nil, false
+	}
+
+	const maxSide = 320
+
+	var thumb *tg.PhotoSize
+	for _, size := range p.Sizes {
+		s, ok := size.(*tg.PhotoSize)
+		if !ok || max(s.W, s.H) > maxSide {
+			continue
+		}
+		if thumb == nil || s.Size > thumb.Size {
+			thumb = s
+		}
+	}
+
+	if thumb == nil {
+		return nil, false
+	}
+
+	return &Media{
+		InputFileLoc: &tg.InputPhotoFileLocation{
+			ID:            p.ID,
+			AccessHash:    p.AccessHash,
+			FileReference: p.FileReference,
+			ThumbSize:     thumb.Type,
+		},
+		Name: strconv.FormatInt(p.ID, 10) + "_thumb.jpg",
+		Size: int64(thumb.Size),
+		DC:   p.DCID,
+		Date: int64(p.Date),
+	}, true
+}
@@ -14,9 +14,11 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/iyear/tdl/core/util/fsutil"
 	"github.com/iyear/tdl/core/util/mediautil"
+	"github.com/iyear/tdl/core/util/rateutil"
 )
 
 // MaxPartSize refer to https://core.telegram.org/api/files#uploading-files
@@ -31,6 +33,8 @@ type Options struct {
 	Threads  int
 	Iter     Iter
 	Progress Progress
+	// Limiter limits the upload bandwidth in bytes per second, shared by all elements. Nil means unlimited.
+	Limiter *rate.Limiter
 }
 
 func New(o Options) *Uploader {
@@ -44,11 +48,14 @@ func (u *Uploader) Upload(ctx context.Context, limit int) error {
 	for u.opts.Iter.Next(wgctx) {
 		elem := u.opts.Iter.Value()
 
-		wg.Go(func() (rerr error) {
+		wg.Go(func() error {
 			u.opts.Progress.OnAdd(elem)
-			defer func() { u.opts.Progress.OnDone(elem, rerr) }()
 
-			if err := u.upload(wgctx, elem); err != nil {
+			err := u.upload(wgctx, elem)
+			// report the error of element to progress, so failed uploads won't be treated as done
+			u.opts.Progress.OnDone(elem, err)
+
+			if err != nil {
 				// canceled by user, so we directly return error to stop all
 				if errors.Is(err, context.Canceled) {
 					return errors.Wrap(err, "upload")
@@ -83,7 +90,8 @@ func (u *Uploader) upload(ctx context.Context, elem Elem) error {
 			process: u.opts.Progress,
 		})
 
-	f, err := up.Upload(ctx, uploader.NewUpload(elem.File().Name(), elem.File(), elem.File().Size()))
+	f, err := up.Upload(ctx, uploader.NewUpload(elem.File().Name(),
+		rateutil.NewReader(ctx, elem.File(), u.opts.Limiter), elem.File().Size()))
 	if err != nil {
 		return errors.Wrap(err, "upload file")
 	}
@@ -0,0 +1,72 @@
+package rateutil
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// burst allows a whole part to pass at once, parts are at most 1 MiB
+const burst = 1024 * 1024
+
+// NewLimiter returns a bandwidth limiter in bytes per second. Zero or negative means unlimited.
+func NewLimiter(bytesPerSec int64) *rate.Limiter {
+	return rate.NewLimiter(Limit(bytesPerSec), burst)
+}
+
+// Limit converts bytes per second to rate.Limit. Zero or negative means unlimited.
+func Limit(bytesPerSec int64) rate.Limit {
+	if bytesPerSec <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSec)
+}
+
+// WaitN blocks until n bytes are allowed by limiter. Nil limiter never blocks.
+func WaitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+
+	// WaitN fails if n exceeds burst, so wait in burst-sized chunks
+	for n > 0 {
+		c := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, c); err != nil {
+			return err
+		}
+		n -= c
+	}
+
+	return nil
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// NewReader wraps r to read no faster than limiter allows. Nil limiter returns r itself.
+func NewReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+
+	return &reader{
+		ctx:     ctx,
+		r:       r,
+		limiter: limiter,
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := WaitN(r.ctx, r.limiter, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
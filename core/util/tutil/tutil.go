@@ -11,84 +11,199 @@ import (
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/telegram/query"
 	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
 )
 
 // ErrMessageDeleted is returned when a message is detected as deleted.
 var ErrMessageDeleted = errors.New("message may be deleted")
 
-// ParseMessageLink return dialog id, msg id, error
-func ParseMessageLink(ctx context.Context, manager *peers.Manager, s string) (peers.Peer, int, error) {
-	parse := func(from, msg string) (peers.Peer, int, error) {
-		ch, err := GetInputPeer(ctx, manager, from)
-		if err != nil {
-			return nil, 0, errors.Wrap(err, "input peer")
-		}
+// MaxLinkRange is the max number of messages of range link
+const MaxLinkRange = 10000
 
-		m, err := strconv.Atoi(msg)
-		if err != nil {
-			return nil, 0, errors.Wrap(err, "parse message id")
-		}
+// MessageLink is the parsed link of messages or stories
+type MessageLink struct {
+	Peer     peers.Peer
+	Messages []int // message ids, expanded from range like '100-200'
+	Stories  []int // story ids of story links
+}
 
-		return ch, m, nil
+// ParseMessageLinks parses links of message, message range and story, including 'tg://' deep links
+func ParseMessageLinks(ctx context.Context, manager *peers.Manager, s string) (*MessageLink, error) {
+	parts, err := parseLinkParts(s)
+	if err != nil {
+		return nil, err
 	}
 
+	return parseLink(ctx, manager, parts)
+}
+
+// linkParts is the unresolved parts of message link
+type linkParts struct {
+	chat    string // id or username
+	ids     string // single id or range
+	comment string // comment id of channel post, ids are ignored if it's set
+	story   bool
+}
+
+// parseLinkParts splits the link into parts without resolving the chat
+func parseLinkParts(s string) (linkParts, error) {
 	u, err := url.Parse(s)
 	if err != nil {
-		return nil, 0, err
+		return linkParts{}, err
+	}
+
+	if u.Scheme == "tg" {
+		return parseDeepLink(u, s)
 	}
 
 	paths := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
 
 	// https://t.me/opencfdchannel/4434?comment=360409
 	if c := u.Query().Get("comment"); c != "" {
-		peer, err := GetInputPeer(ctx, manager, paths[0])
-		if err != nil {
-			return nil, 0, errors.Wrap(err, "input peer")
-		}
-
-		ch, ok := peer.(peers.Channel)
-		if !ok || !ch.IsBroadcast() {
-			return nil, 0, errors.New("not channel")
-		}
-
-		raw, err := ch.FullRaw(ctx)
-		if err != nil {
-			return nil, 0, errors.Wrap(err, "full raw")
-		}
-
-		linked, ok := raw.GetLinkedChatID()
-		if !ok {
-			return nil, 0, errors.New("no linked chat")
-		}
-
-		return parse(strconv.FormatInt(linked, 10), c)
+		return linkParts{chat: paths[0], comment: c}, nil
 	}
 
 	switch len(paths) {
 	case 2:
 		// https://t.me/telegram/193
 		// https://t.me/myhostloc/1485524?thread=1485523
-		return parse(paths[0], paths[1])
+		// https://t.me/telegram/100-200
+		return linkParts{chat: paths[0], ids: paths[1]}, nil
 	case 3:
 		// https://t.me/c/1697797156/151
 		// https://t.me/iFreeKnow/45662/55005
-		if paths[0] == "c" {
-			return parse(paths[1], paths[2])
+		// https://t.me/telegram/s/12
+		switch {
+		case paths[0] == "c":
+			return linkParts{chat: paths[1], ids: paths[2]}, nil
+		case paths[1] == "s":
+			return linkParts{chat: paths[0], ids: paths[2], story: true}, nil
 		}
 
 		// "45662" means topic id, we don't need it
-		return parse(paths[0], paths[2])
+		return linkParts{chat: paths[0], ids: paths[2]}, nil
 	case 4:
 		// https://t.me/c/1492447836/251015/251021
 		if paths[0] != "c" {
-			return nil, 0, fmt.Errorf("invalid message link")
+			return linkParts{}, fmt.Errorf("invalid message link")
 		}
 
 		// "251015" means topic id, we don't need it
-		return parse(paths[1], paths[3])
+		return linkParts{chat: paths[1], ids: paths[3]}, nil
+	default:
+		return linkParts{}, fmt.Errorf("invalid message link: %s", s)
+	}
+}
+
+// parseDeepLink parses 'tg://' links of messages and stories:
+//
+//	tg://privatepost?channel=1697797156&post=151&thread=150&comment=1
+//	tg://resolve?domain=telegram&post=193
+//	tg://resolve?domain=telegram&story=12
+func parseDeepLink(u *url.URL, s string) (linkParts, error) {
+	q := u.Query()
+
+	// tg:resolve?domain=... is also valid
+	action := u.Host
+	if action == "" {
+		action = strings.SplitN(u.Opaque, "?", 2)[0]
+	}
+
+	switch action {
+	case "privatepost":
+		return linkParts{chat: q.Get("channel"), ids: q.Get("post"), comment: q.Get("comment")}, nil
+	case "resolve":
+		if story := q.Get("story"); story != "" {
+			return linkParts{chat: q.Get("domain"), ids: story, story: true}, nil
+		}
+		if q.Get("post") == "" && q.Get("comment") == "" {
+			return linkParts{}, fmt.Errorf("no post or story in link: %s", s)
+		}
+		return linkParts{chat: q.Get("domain"), ids: q.Get("post"), comment: q.Get("comment")}, nil
 	default:
-		return nil, 0, fmt.Errorf("invalid message link: %s", s)
+		return linkParts{}, fmt.Errorf("invalid message link: %s", s)
+	}
+}
+
+// parseLink resolves the chat and expands ids. If comment is set, ids are comments of channel post in the linked group.
+func parseLink(ctx context.Context, manager *peers.Manager, parts linkParts) (*MessageLink, error) {
+	if parts.chat == "" {
+		return nil, errors.New("empty chat in link")
+	}
+
+	peer, err := GetInputPeer(ctx, manager, parts.chat)
+	if err != nil {
+		return nil, errors.Wrap(err, "input peer")
+	}
+
+	ids := parts.ids
+	if parts.comment != "" {
+		ch, ok := peer.(peers.Channel)
+		if !ok || !ch.IsBroadcast() {
+			return nil, errors.New("not channel")
+		}
+
+		raw, err := ch.FullRaw(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "full raw")
+		}
+
+		linked, ok := raw.GetLinkedChatID()
+		if !ok {
+			return nil, errors.New("no linked chat")
+		}
+
+		if peer, err = GetInputPeer(ctx, manager, strconv.FormatInt(linked, 10)); err != nil {
+			return nil, errors.Wrap(err, "input peer")
+		}
+		ids = parts.comment
+	}
+
+	parsed, err := parseLinkIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &MessageLink{Peer: peer}
+	if parts.story {
+		link.Stories = parsed
+	} else {
+		link.Messages = parsed
 	}
+
+	return link, nil
+}
+
+// parseLinkIDs parses single id like '100' or range like '100-200'
+func parseLinkIDs(s string) ([]int, error) {
+	from, to, isRange := strings.Cut(s, "-")
+
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse message id")
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(to); err != nil {
+			return nil, errors.Wrap(err, "parse message id")
+		}
+	}
+
+	if start <= 0 || end < start {
+		return nil, errors.Errorf("invalid message id range: %s", s)
+	}
+	if end-start+1 > MaxLinkRange {
+		return nil, errors.Errorf("message id range %s is too large, at most %d messages", s, MaxLinkRange)
+	}
+
+	ids := make([]int, 0, end-start+1)
+	for id := start; id <= end; id++ {
+		ids = append(ids, id)
+	}
+
+	return ids, nil
 }
 
 func GetInputPeer(ctx context.Context, manager *peers.Manager, from string) (peers.Peer, error) {
@@ -129,6 +244,20 @@ func GetPeerID(peer tg.PeerClass) int64 {
 	return 0
 }
 
+// GetPeer converts input peer to peer, and returns nil if it's not user, chat or channel
+func GetPeer(peer tg.InputPeerClass) tg.PeerClass {
+	switch p := peer.(type) {
+	case *tg.InputPeerUser:
+		return &tg.PeerUser{UserID: p.UserID}
+	case *tg.InputPeerChat:
+		return &tg.PeerChat{ChatID: p.ChatID}
+	case *tg.InputPeerChannel:
+		return &tg.PeerChannel{ChannelID: p.ChannelID}
+	}
+
+	return nil
+}
+
 func GetInputPeerID(peer tg.InputPeerClass) int64 {
 	switch p := peer.(type) {
 	case *tg.InputPeerUser:
@@ -196,6 +325,136 @@ func GetSingleMessage(ctx context.Context, c *tg.Client, peer tg.InputPeerClass,
 	return m, nil
 }
 
+// MaxMessagesPerRequest is the max number of message ids in one messages.getMessages request
+const MaxMessagesPerRequest = 100
+
+// GetMessages returns messages of the peer by ids, splitting them into batches of MaxMessagesPerRequest.
+// Deleted or empty messages are not included in the result.
+func GetMessages(ctx context.Context, c *tg.Client, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	res := make(map[int]*tg.Message, len(ids))
+	ch, isChannel := peer.(*tg.InputPeerChannel)
+	// ids of users and basic groups are shared by the account, so messages of other peers must be excluded
+	peerID := GetInputPeerID(peer)
+
+	for len(ids) > 0 {
+		n := min(len(ids), MaxMessagesPerRequest)
+		batch := make([]tg.InputMessageClass, 0, n)
+		for _, id := range ids[:n] {
+			batch = append(batch, &tg.InputMessageID{ID: id})
+		}
+		ids = ids[n:]
+
+		var (
+			result tg.MessagesMessagesClass
+			err    error
+		)
+		if isChannel {
+			result, err = c.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+				Channel: &tg.InputChannel{ChannelID: ch.ChannelID, AccessHash: ch.AccessHash},
+				ID:      batch,
+			})
+		} else {
+			result, err = c.MessagesGetMessages(ctx, batch)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "get messages")
+		}
+
+		modified, ok := result.AsModified()
+		if !ok {
+			return nil, errors.Errorf("unexpected messages type: %T", result)
+		}
+
+		for _, msg := range modified.GetMessages() {
+			m, ok := msg.(*tg.Message)
+			if !ok {
+				continue
+			}
+			if !isChannel && peerID != 0 && GetPeerID(m.PeerID) != peerID {
+				continue
+			}
+			res[m.ID] = m
+		}
+	}
+
+	return res, nil
+}
+
+// GetStories returns stories of the peer by ids, which are wrapped as messages with story media,
+// so that they can be processed like messages. Deleted or expired stories are not included in the result.
+func GetStories(ctx context.Context, c *tg.Client, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	res := make(map[int]*tg.Message, len(ids))
+
+	for len(ids) > 0 {
+		n := min(len(ids), MaxMessagesPerRequest)
+		stories, err := c.StoriesGetStoriesByID(ctx, &tg.StoriesGetStoriesByIDRequest{
+			Peer: peer,
+			ID:   ids[:n],
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "get stories")
+		}
+		ids = ids[n:]
+
+		for _, s := range stories.Stories {
+			item, ok := s.(*tg.StoryItem)
+			if !ok {
+				continue
+			}
+
+			media := &tg.MessageMediaStory{
+				Peer:  GetPeer(peer),
+				ID:    item.ID,
+				Story: item,
+			}
+			media.SetFlags()
+
+			msg := &tg.Message{
+				ID:       item.ID,
+				PeerID:   media.Peer,
+				Date:     item.Date,
+				Message:  item.Caption,
+				Entities: item.Entities,
+				Media:    media,
+			}
+			msg.SetFlags()
+
+			res[item.ID] = msg
+		}
+	}
+
+	return res, nil
+}
+
+// FillStory fetches the story of message whose story media doesn't carry the story, e.g. forwarded stories,
+// so that media of the story can be flattened. It returns false if the story is deleted or expired.
+func FillStory(ctx context.Context, c *tg.Client, manager *peers.Manager, msg *tg.Message) (bool, error) {
+	media, ok := msg.Media.(*tg.MessageMediaStory)
+	if !ok {
+		return true, nil
+	}
+	if _, ok = media.GetStory(); ok {
+		return true, nil
+	}
+
+	p, err := manager.ResolvePeer(ctx, media.Peer)
+	if err != nil {
+		return false, errors.Wrap(err, "resolve story peer")
+	}
+
+	stories, err := GetStories(ctx, c, p.InputPeer(), []int{media.ID})
+	if err != nil {
+		return false, err
+	}
+
+	story, ok := stories[media.ID]
+	if !ok {
+		return false, nil
+	}
+	msg.Media = story.Media
+	return true, nil
+}
+
 type Messages []*tg.Message
 
 func (m Messages) Len() int {
@@ -273,3 +532,86 @@ func BestThreads(size int64, max int) int {
 	}
 	return max
 }
+
+// GetForumTopics returns all topics of forum channel.
+// https://github.com/telegramdesktop/tdesktop/blob/4047f1733decd5edf96d125589f128758b68d922/Telegram/SourceFiles/data/data_forum.cpp#L135
+func GetForumTopics(ctx context.Context, api *tg.Client, c tg.InputChannelClass) ([]*tg.ForumTopic, error) {
+	log := logctx.From(ctx)
+	res := make([]*tg.ForumTopic, 0)
+	limit := 100 // why can't we use 500 like tdesktop?
+	offsetTopic, offsetID, offsetDate := 0, 0, 0
+	lastOffsetTopic := -1 // Track the last offsetTopic to detect infinite loops
+
+	// Track seen offsetTopics to detect cycles
+	seenOffsets := make(map[int]bool)
+
+	for {
+		// Detect infinite loop: if offsetTopic hasn't changed or we've seen it before
+		if offsetTopic == lastOffsetTopic && lastOffsetTopic != -1 {
+			log.Warn("pagination stuck (same offset), breaking loop",
+				zap.Int("offset_topic", offsetTopic))
+			break
+		}
+		if seenOffsets[offsetTopic] {
+			log.Warn("pagination cycle detected, breaking loop",
+				zap.Int("offset_topic", offsetTopic))
+			break
+		}
+		seenOffsets[offsetTopic] = true
+		lastOffsetTopic = offsetTopic
+
+		req := &tg.ChannelsGetForumTopicsRequest{
+			Channel:     c,
+			Limit:       limit,
+			OffsetTopic: offsetTopic,
+			OffsetID:    offsetID,
+			OffsetDate:  offsetDate,
+		}
+
+		topics, err := api.ChannelsGetForumTopics(ctx, req)
+		if err != nil {
+			return nil, errors.Wrap(err, "get forum topics")
+		}
+
+		// If no topics returned, we're done
+		if len(topics.Topics) == 0 {
+			break
+		}
+
+		for _, tp := range topics.Topics {
+			if t, ok := tp.(*tg.ForumTopic); ok {
+				res = append(res, t)
+
+				offsetTopic = t.ID
+			}
+		}
+
+		// Safety break if we've collected all topics
+		if len(res) >= topics.Count {
+			break
+		}
+
+		// last page
+		if len(topics.Topics) < limit {
+			break
+		}
+
+		// Update offset using last message if available
+		// Use a local variable for length to be absolutely safe against index out of range
+		msgCount := len(topics.Messages)
+		if msgCount > 0 {
+			if lastMsg, ok := topics.Messages[msgCount-1].AsNotEmpty(); ok {
+				offsetID, offsetDate = lastMsg.GetID(), lastMsg.GetDate()
+			} else {
+				log.Debug("no valid message for offset, relying on offsetTopic only",
+					zap.Int("offset_topic", offsetTopic))
+			}
+		} else {
+			log.Debug("no messages in topics response, relying on offsetTopic only",
+				zap.Int("offset_topic", offsetTopic),
+				zap.Int("topics_count", len(topics.Topics)))
+		}
+	}
+
+	return res, nil
+}
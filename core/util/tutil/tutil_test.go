@@ -0,0 +1,98 @@
+package tutil
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseLinkIDs(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{input: "100", want: []int{100}},
+		{input: "100-103", want: []int{100, 101, 102, 103}},
+		{input: "100-100", want: []int{100}},
+		{input: "100-200", want: sequence(100, 200)},
+		{input: "1-" + strconv.Itoa(MaxLinkRange), want: sequence(1, MaxLinkRange)},
+		{input: "0", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "200-100", wantErr: true},
+		{input: "1-" + strconv.Itoa(MaxLinkRange+1), wantErr: true},
+		{input: "100-", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseLinkIDs(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseLinkParts(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    linkParts
+		wantErr bool
+	}{
+		{input: "https://t.me/telegram/193", want: linkParts{chat: "telegram", ids: "193"}},
+		{input: "https://t.me/telegram/100-200", want: linkParts{chat: "telegram", ids: "100-200"}},
+		{input: "https://t.me/myhostloc/1485524?thread=1485523", want: linkParts{chat: "myhostloc", ids: "1485524"}},
+		{input: "https://t.me/c/1697797156/151", want: linkParts{chat: "1697797156", ids: "151"}},
+		{input: "https://t.me/iFreeKnow/45662/55005", want: linkParts{chat: "iFreeKnow", ids: "55005"}},
+		{input: "https://t.me/c/1492447836/251015/251021", want: linkParts{chat: "1492447836", ids: "251021"}},
+		{input: "https://t.me/u/s/12", want: linkParts{chat: "u", ids: "12", story: true}},
+		{input: "https://t.me/opencfdchannel/4434?comment=360409", want: linkParts{chat: "opencfdchannel", comment: "360409"}},
+		{input: "tg://privatepost?channel=1697797156&post=151&thread=150", want: linkParts{chat: "1697797156", ids: "151"}},
+		{input: "tg://privatepost?channel=1697797156&post=151&comment=1", want: linkParts{chat: "1697797156", ids: "151", comment: "1"}},
+		{input: "tg://resolve?domain=telegram&post=193", want: linkParts{chat: "telegram", ids: "193"}},
+		{input: "tg:resolve?domain=x&story=1", want: linkParts{chat: "x", ids: "1", story: true}},
+		{input: "tg://resolve?domain=telegram", wantErr: true},
+		{input: "tg://user?id=1", wantErr: true},
+		{input: "https://t.me/telegram", wantErr: true},
+		{input: "https://t.me/a/b/c/d", wantErr: true},
+		{input: "https://t.me/a/b/c/d/e", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseLinkParts(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func sequence(from, to int) []int {
+	ids := make([]int, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		ids = append(ids, i)
+	}
+	return ids
+}
@@ -0,0 +1,173 @@
+package bandwidth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-faster/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/iyear/tdl/core/util/rateutil"
+)
+
+var units = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseRate parses rate like '10MiB/s', '512KB' or '1048576' into bytes per second.
+// Units are binary and case-insensitive. Empty string or zero means unlimited.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	if s == "" {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+
+	unit, ok := units[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, errors.Errorf("invalid rate unit %q", s[i:])
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// Window is a time-of-day range with its own rate, To may be earlier than From to cross midnight.
+type Window struct {
+	From time.Duration // offset since midnight
+	To   time.Duration // offset since midnight
+	Rate int64
+}
+
+func (w Window) contains(t time.Time) bool {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	if w.From <= w.To {
+		return d >= w.From && d < w.To
+	}
+	return d >= w.From || d < w.To
+}
+
+// ParseWindow parses window like '09:00-18:00=2MiB/s'
+func ParseWindow(s string) (Window, error) {
+	span, r, ok := strings.Cut(s, "=")
+	if !ok {
+		return Window{}, errors.Errorf("invalid schedule %q, format: HH:MM-HH:MM=RATE", s)
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return Window{}, errors.Errorf("invalid schedule %q, format: HH:MM-HH:MM=RATE", s)
+	}
+
+	w := Window{}
+	var err error
+	if w.From, err = parseClock(from); err != nil {
+		return Window{}, err
+	}
+	if w.To, err = parseClock(to); err != nil {
+		return Window{}, err
+	}
+	if w.Rate, err = ParseRate(r); err != nil {
+		return Window{}, err
+	}
+
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Schedule chooses the rate by time of day. The first matched window wins,
+// and the default rate is used if no window matches.
+type Schedule struct {
+	Default int64
+	Windows []Window
+}
+
+// ParseSchedule parses default rate and windows like '09:00-18:00=2MiB/s'
+func ParseSchedule(def string, windows []string) (Schedule, error) {
+	r, err := ParseRate(def)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	s := Schedule{Default: r}
+	for _, window := range windows {
+		w, err := ParseWindow(window)
+		if err != nil {
+			return Schedule{}, err
+		}
+		s.Windows = append(s.Windows, w)
+	}
+
+	return s, nil
+}
+
+// Rate returns the rate in bytes per second at t, zero means unlimited.
+func (s Schedule) Rate(t time.Time) int64 {
+	for _, w := range s.Windows {
+		if w.contains(t) {
+			return w.Rate
+		}
+	}
+
+	return s.Default
+}
+
+// Limiter returns the limiter following the schedule until ctx is done.
+// Nil is returned if the schedule is always unlimited.
+func (s Schedule) Limiter(ctx context.Context) *rate.Limiter {
+	if s.Default <= 0 && len(s.Windows) == 0 {
+		return nil
+	}
+
+	limiter := rateutil.NewLimiter(s.Rate(time.Now()))
+	if len(s.Windows) == 0 {
+		return limiter
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				limiter.SetLimit(rateutil.Limit(s.Rate(t)))
+			}
+		}
+	}()
+
+	return limiter
+}
@@ -0,0 +1,77 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"10MiB/s", 10 << 20, false},
+		{"10mb", 10 << 20, false},
+		{"512 KB/s", 512 << 10, false},
+		{"1.5M", 3 << 19, false},
+		{"1G", 1 << 30, false},
+		{"10x", 0, true},
+		{"MiB", 0, true},
+		{"-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRate(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("09:00-18:30=2MiB/s")
+	require.NoError(t, err)
+	assert.Equal(t, Window{From: 9 * time.Hour, To: 18*time.Hour + 30*time.Minute, Rate: 2 << 20}, w)
+
+	for _, s := range []string{"09:00-18:00", "09:00=1M", "9-18=1M", "09:00-25:00=1M", "09:00-18:00=1X"} {
+		_, err = ParseWindow(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestScheduleRate(t *testing.T) {
+	s, err := ParseSchedule("10MiB/s", []string{"09:00-18:00=1MiB/s", "22:00-06:00=0"})
+	require.NoError(t, err)
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 1, 1, hour, min, 0, 0, time.Local)
+	}
+
+	assert.Equal(t, int64(1<<20), s.Rate(at(9, 0)))
+	assert.Equal(t, int64(1<<20), s.Rate(at(17, 59)))
+	assert.Equal(t, int64(10<<20), s.Rate(at(18, 0)))
+	assert.Equal(t, int64(0), s.Rate(at(23, 0)))
+	assert.Equal(t, int64(0), s.Rate(at(5, 59)))
+	assert.Equal(t, int64(10<<20), s.Rate(at(6, 0)))
+}
+
+func TestScheduleLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(t, Schedule{}.Limiter(ctx))
+	assert.NotNil(t, Schedule{Default: 1024}.Limiter(ctx))
+}
@@ -0,0 +1,20 @@
+package bandwidth
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+type ctxKey struct{}
+
+// With returns a context carrying the shared limiter
+func With(ctx context.Context, limiter *rate.Limiter) context.Context {
+	return context.WithValue(ctx, ctxKey{}, limiter)
+}
+
+// From returns the shared limiter, nil means unlimited
+func From(ctx context.Context) *rate.Limiter {
+	limiter, _ := ctx.Value(ctxKey{}).(*rate.Limiter)
+	return limiter
+}
@@ -10,6 +10,10 @@ const (
 	FlagLimit            = "limit"
 	FlagPoolSize         = "pool"
 	FlagDelay            = "delay"
+	FlagRate             = "rate"
+	FlagRateSchedule     = "rate-schedule"
+	FlagProgress         = "progress"
+	FlagMetricsAddr      = "metrics-addr"
 	FlagNTP              = "ntp"
 	FlagReconnectTimeout = "reconnect-timeout"
 	FlagDlTemplate       = "template"
@@ -1,6 +1,8 @@
 package key
 
 import (
+	"strconv"
+
 	"github.com/iyear/tdl/core/storage/keygen"
 )
 
@@ -11,3 +13,23 @@ func App() string {
 func Resume(fingerprint string) string {
 	return keygen.New("resume", fingerprint)
 }
+
+func ResumeParts(fingerprint string) string {
+	return keygen.New("resume", fingerprint, "parts")
+}
+
+func DedupFile(id, accessHash int64) string {
+	return keygen.New("dedup", "file", strconv.FormatInt(id, 10), strconv.FormatInt(accessHash, 10))
+}
+
+func DedupHash(sum string) string {
+	return keygen.New("dedup", "sha256", sum)
+}
+
+func DaemonJobs() string {
+	return keygen.New("daemon", "jobs")
+}
+
+func ExportMark(chat int64, thread int, scope string) string {
+	return keygen.New("export", "mark", strconv.FormatInt(chat, 10), strconv.Itoa(thread), scope)
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/iyear/tdl/core/middlewares/retry"
+	"github.com/iyear/tdl/core/middlewares/stats"
+)
+
+const namespace = "tdl"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "Number of RPC attempts.",
+	}, []string{"dc", "method"})
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed RPC attempts by error type.",
+	}, []string{"dc", "type"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of RPC attempts.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"dc"})
+	retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_retries_total",
+		Help:      "Number of RPC attempts failed with flood wait or internal server errors, which are retried.",
+	}, []string{"dc"})
+	floodWaits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flood_waits_total",
+		Help:      "Number of flood waits.",
+	}, []string{"dc"})
+	floodWaitSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flood_wait_seconds_total",
+		Help:      "Total duration of flood waits required by Telegram.",
+	}, []string{"dc"})
+
+	transferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transferred_bytes_total",
+		Help:      "Bytes transferred by kind: download, upload, forward(cloned media), serve.",
+	}, []string{"kind"})
+	inflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inflight_elements",
+		Help:      "Number of elements being transferred.",
+	}, []string{"kind"})
+	elements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "elements_total",
+		Help:      "Number of finished elements by status: done, failed, canceled.",
+	}, []string{"kind", "status"})
+)
+
+func init() {
+	registry.MustRegister(
+		requests, requestErrors, requestDuration, retries, floodWaits, floodWaitSeconds,
+		transferred, inflight, elements,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Serve exposes metrics on http://addr/metrics until ctx is done
+func Serve(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	s := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	go func() { _ = s.Serve(l) }()
+
+	return nil
+}
+
+// AddBytes records bytes transferred outside progress hooks, e.g. served by HTTP
+func AddBytes(kind string, n int64) {
+	transferred.WithLabelValues(kind).Add(float64(n))
+}
+
+type observer struct{}
+
+// Observer returns the observer recording RPC metrics, which should be set to context before creating clients.
+func Observer() stats.Observer {
+	return observer{}
+}
+
+func (observer) Observe(_ context.Context, r stats.Request) {
+	dc := "main"
+	if r.DC != 0 {
+		dc = strconv.Itoa(r.DC)
+	}
+
+	requests.WithLabelValues(dc, r.Method).Inc()
+	requestDuration.WithLabelValues(dc).Observe(r.Duration.Seconds())
+
+	if r.Err == nil {
+		return
+	}
+
+	typ := "unknown"
+	if rpcErr, ok := tgerr.As(r.Err); ok {
+		typ = rpcErr.Type
+	} else if errors.Is(r.Err, context.Canceled) {
+		typ = "canceled"
+	}
+	requestErrors.WithLabelValues(dc, typ).Inc()
+
+	if d, ok := tgerr.AsFloodWait(r.Err); ok {
+		floodWaits.WithLabelValues(dc).Inc()
+		floodWaitSeconds.WithLabelValues(dc).Add(d.Seconds())
+		retries.WithLabelValues(dc).Inc()
+		return
+	}
+
+	if retry.Retryable(r.Err) {
+		retries.WithLabelValues(dc).Inc()
+	}
+}
+
+type countWriter struct {
+	http.ResponseWriter
+	kind string
+}
+
+// CountWriter wraps w to record written bytes as transferred bytes of kind
+func CountWriter(w http.ResponseWriter, kind string) http.ResponseWriter {
+	return &countWriter{ResponseWriter: w, kind: kind}
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	AddBytes(w.kind, int64(n))
+	return n, err
+}
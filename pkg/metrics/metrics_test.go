@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tgerr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/iyear/tdl/core/downloader"
+	"github.com/iyear/tdl/core/middlewares/stats"
+)
+
+func TestObserver(t *testing.T) {
+	o := Observer()
+	ctx := context.Background()
+
+	o.Observe(ctx, stats.Request{DC: 2, Method: "upload.getFile", Duration: time.Millisecond})
+	o.Observe(ctx, stats.Request{DC: 2, Method: "upload.getFile", Err: tgerr.New(420, "FLOOD_WAIT_3")})
+	o.Observe(ctx, stats.Request{DC: 2, Method: "upload.getFile", Err: tgerr.New(500, "RPC_CALL_FAIL")})
+	o.Observe(ctx, stats.Request{Method: "help.getConfig", Err: errors.New("network")})
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(requests.WithLabelValues("2", "upload.getFile")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("main", "help.getConfig")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestErrors.WithLabelValues("2", "FLOOD_WAIT")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestErrors.WithLabelValues("main", "unknown")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(floodWaits.WithLabelValues("2")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(floodWaitSeconds.WithLabelValues("2")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(retries.WithLabelValues("2")))
+}
+
+type nopProgress struct{}
+
+func (nopProgress) OnAdd(downloader.Elem)                                {}
+func (nopProgress) OnDownload(downloader.Elem, downloader.ProgressState) {}
+func (nopProgress) OnDone(downloader.Elem, error)                        {}
+
+type elem struct{ downloader.Elem }
+
+func TestDownload(t *testing.T) {
+	p := Download(nopProgress{})
+	a, b := &elem{}, &elem{}
+
+	p.OnAdd(a)
+	p.OnAdd(b)
+	assert.Equal(t, 2.0, testutil.ToFloat64(inflight.WithLabelValues(KindDownload)))
+
+	p.OnDownload(a, downloader.ProgressState{Downloaded: 100, Total: 300})
+	p.OnDownload(a, downloader.ProgressState{Downloaded: 300, Total: 300})
+	p.OnDownload(b, downloader.ProgressState{Downloaded: 50, Total: 300})
+	assert.Equal(t, 350.0, testutil.ToFloat64(transferred.WithLabelValues(KindDownload)))
+
+	p.OnDone(a, nil)
+	p.OnDone(b, errors.Wrap(context.Canceled, "download"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(inflight.WithLabelValues(KindDownload)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(elements.WithLabelValues(KindDownload, "done")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(elements.WithLabelValues(KindDownload, "canceled")))
+}
+
+func TestCountWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := CountWriter(rec, KindServe)
+
+	_, _ = w.Write([]byte("hello"))
+	_, _ = w.Write([]byte("world"))
+
+	assert.Equal(t, "helloworld", rec.Body.String())
+	assert.Equal(t, 10.0, testutil.ToFloat64(transferred.WithLabelValues(KindServe)))
+}
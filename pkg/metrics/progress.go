@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/iyear/tdl/core/downloader"
+	"github.com/iyear/tdl/core/forwarder"
+	"github.com/iyear/tdl/core/uploader"
+)
+
+const (
+	KindDownload = "download"
+	KindUpload   = "upload"
+	KindForward  = "forward"
+	KindServe    = "serve"
+)
+
+// transfer converts cumulative progress of elements to metrics
+type transfer struct {
+	kind string
+	mu   *sync.Mutex
+	last map[any]int64 // last reported bytes of each element
+}
+
+func newTransfer(kind string) *transfer {
+	return &transfer{
+		kind: kind,
+		mu:   &sync.Mutex{},
+		last: make(map[any]int64),
+	}
+}
+
+func (t *transfer) add(elem any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last[elem] = 0
+	inflight.WithLabelValues(t.kind).Inc()
+}
+
+func (t *transfer) update(elem any, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if delta := n - t.last[elem]; delta > 0 {
+		transferred.WithLabelValues(t.kind).Add(float64(delta))
+	}
+	t.last[elem] = n
+}
+
+func (t *transfer) done(elem any, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.last, elem)
+	inflight.WithLabelValues(t.kind).Dec()
+
+	status := "done"
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = "canceled"
+	case err != nil:
+		status = "failed"
+	}
+	elements.WithLabelValues(t.kind, status).Inc()
+}
+
+type downloadProgress struct {
+	downloader.Progress
+	t *transfer
+}
+
+// Download wraps progress of downloader to record metrics
+func Download(p downloader.Progress) downloader.Progress {
+	return &downloadProgress{Progress: p, t: newTransfer(KindDownload)}
+}
+
+func (p *downloadProgress) OnAdd(elem downloader.Elem) {
+	p.t.add(elem)
+	p.Progress.OnAdd(elem)
+}
+
+func (p *downloadProgress) OnDownload(elem downloader.Elem, state downloader.ProgressState) {
+	p.t.update(elem, state.Downloaded)
+	p.Progress.OnDownload(elem, state)
+}
+
+func (p *downloadProgress) OnDone(elem downloader.Elem, err error) {
+	p.t.done(elem, err)
+	p.Progress.OnDone(elem, err)
+}
+
+type uploadProgress struct {
+	uploader.Progress
+	t *transfer
+}
+
+// Upload wraps progress of uploader to record metrics
+func Upload(p uploader.Progress) uploader.Progress {
+	return &uploadProgress{Progress: p, t: newTransfer(KindUpload)}
+}
+
+func (p *uploadProgress) OnAdd(elem uploader.Elem) {
+	p.t.add(elem)
+	p.Progress.OnAdd(elem)
+}
+
+func (p *uploadProgress) OnUpload(elem uploader.Elem, state uploader.ProgressState) {
+	p.t.update(elem, state.Uploaded)
+	p.Progress.OnUpload(elem, state)
+}
+
+func (p *uploadProgress) OnDone(elem uploader.Elem, err error) {
+	p.t.done(elem, err)
+	p.Progress.OnDone(elem, err)
+}
+
+type forwardProgress struct {
+	forwarder.Progress
+	t *transfer
+}
+
+// Forward wraps progress of forwarder to record metrics, only cloned media are counted as transferred bytes
+func Forward(p forwarder.Progress) forwarder.Progress {
+	return &forwardProgress{Progress: p, t: newTransfer(KindForward)}
+}
+
+func (p *forwardProgress) OnAdd(elem forwarder.Elem) {
+	p.t.add(elem)
+	p.Progress.OnAdd(elem)
+}
+
+func (p *forwardProgress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
+	p.t.update(elem, state.Done)
+	p.Progress.OnClone(elem, state)
+}
+
+func (p *forwardProgress) OnDone(elem forwarder.Elem, err error) {
+	p.t.done(elem, err)
+	p.Progress.OnDone(elem, err)
+}
@@ -2,16 +2,59 @@ package prog
 
 import (
 	"context"
+	"io"
+	"os"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/jedib0t/go-pretty/v6/progress"
 	"github.com/jedib0t/go-pretty/v6/text"
 	tsize "github.com/kopoli/go-terminal-size"
+	"github.com/mattn/go-isatty"
 )
 
+//go:generate go-enum --names --values --flag --nocase
+
+// Mode is the way to render progress
+// ENUM(auto, tty, plain, summary, json)
+type Mode int
+
+var (
+	mode             = ModeAuto
+	output io.Writer = os.Stdout
+)
+
+// SetMode sets the render mode of writers created afterwards
+func SetMode(m Mode) {
+	mode = m
+}
+
+// SetOutput sets the output of writers created afterwards, e.g. stderr if stdout is used to pipe data
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// resolveMode chooses terminal renderer only if stdout is a terminal in auto mode
+func resolveMode(m Mode) Mode {
+	if m != ModeAuto {
+		return m
+	}
+
+	if f, ok := output.(*os.File); ok {
+		if fd := f.Fd(); isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd) {
+			return ModeTty
+		}
+	}
+	return ModePlain
+}
+
 func New(formatter progress.UnitsFormatter) progress.Writer {
+	if m := resolveMode(mode); m != ModeTty {
+		return newWriter(m)
+	}
+
 	pw := progress.NewWriter()
+	pw.SetOutputWriter(output)
 	pw.SetAutoStop(false)
 
 	width := 100
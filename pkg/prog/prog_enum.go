@@ -0,0 +1,120 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version: 0.5.8
+// Revision: 3d844c8ecc59661ed7aa17bfd65727bc06a60ad8
+// Build Date: 2023-09-18T14:55:21Z
+// Built By: goreleaser
+
+package prog
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ModeAuto is a Mode of type Auto.
+	ModeAuto Mode = iota
+	// ModeTty is a Mode of type Tty.
+	ModeTty
+	// ModePlain is a Mode of type Plain.
+	ModePlain
+	// ModeSummary is a Mode of type Summary.
+	ModeSummary
+	// ModeJson is a Mode of type Json.
+	ModeJson
+)
+
+var ErrInvalidMode = fmt.Errorf("not a valid Mode, try [%s]", strings.Join(_ModeNames, ", "))
+
+const _ModeName = "autottyplainsummaryjson"
+
+var _ModeNames = []string{
+	_ModeName[0:4],
+	_ModeName[4:7],
+	_ModeName[7:12],
+	_ModeName[12:19],
+	_ModeName[19:23],
+}
+
+// ModeNames returns a list of possible string values of Mode.
+func ModeNames() []string {
+	tmp := make([]string, len(_ModeNames))
+	copy(tmp, _ModeNames)
+	return tmp
+}
+
+// ModeValues returns a list of the values for Mode
+func ModeValues() []Mode {
+	return []Mode{
+		ModeAuto,
+		ModeTty,
+		ModePlain,
+		ModeSummary,
+		ModeJson,
+	}
+}
+
+var _ModeMap = map[Mode]string{
+	ModeAuto:    _ModeName[0:4],
+	ModeTty:     _ModeName[4:7],
+	ModePlain:   _ModeName[7:12],
+	ModeSummary: _ModeName[12:19],
+	ModeJson:    _ModeName[19:23],
+}
+
+// String implements the Stringer interface.
+func (x Mode) String() string {
+	if str, ok := _ModeMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("Mode(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x Mode) IsValid() bool {
+	_, ok := _ModeMap[x]
+	return ok
+}
+
+var _ModeValue = map[string]Mode{
+	_ModeName[0:4]:                    ModeAuto,
+	strings.ToLower(_ModeName[0:4]):   ModeAuto,
+	_ModeName[4:7]:                    ModeTty,
+	strings.ToLower(_ModeName[4:7]):   ModeTty,
+	_ModeName[7:12]:                   ModePlain,
+	strings.ToLower(_ModeName[7:12]):  ModePlain,
+	_ModeName[12:19]:                  ModeSummary,
+	strings.ToLower(_ModeName[12:19]): ModeSummary,
+	_ModeName[19:23]:                  ModeJson,
+	strings.ToLower(_ModeName[19:23]): ModeJson,
+}
+
+// ParseMode attempts to convert a string to a Mode.
+func ParseMode(name string) (Mode, error) {
+	if x, ok := _ModeValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _ModeValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return Mode(0), fmt.Errorf("%s is %w", name, ErrInvalidMode)
+}
+
+// Set implements the Golang flag.Value interface func.
+func (x *Mode) Set(val string) error {
+	v, err := ParseMode(val)
+	*x = v
+	return err
+}
+
+// Get implements the Golang flag.Getter interface func.
+func (x *Mode) Get() interface{} {
+	return *x
+}
+
+// Type implements the github.com/spf13/pFlag Value interface.
+func (x *Mode) Type() string {
+	return "Mode"
+}
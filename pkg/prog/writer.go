@@ -0,0 +1,348 @@
+package prog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"go.uber.org/atomic"
+)
+
+const (
+	pollInterval   = 500 * time.Millisecond // check state changes of trackers
+	reportInterval = 10 * time.Second       // summary and progress events
+)
+
+// writer is a progress.Writer for non-interactive output like Docker or systemd logs.
+// Instead of redrawing the terminal, it polls the trackers and prints what happened as lines.
+type writer struct {
+	mode  Mode
+	out   io.Writer
+	style *progress.Style
+
+	mu       *sync.Mutex
+	trackers []*trackerState // finished trackers are removed after their final line is printed
+	logs     []string
+	pinned   []string
+	units    *progress.Units // units of the first tracker, all trackers share the same units
+
+	// stats of removed trackers
+	done, failed         int
+	doneValue, doneTotal int64
+
+	lastReport time.Time
+	lastValue  int64
+
+	rendering *atomic.Bool
+	stop      chan struct{}
+	stopOnce  *sync.Once
+}
+
+type trackerState struct {
+	tracker  *progress.Tracker
+	started  time.Time
+	reported int64 // value of last progress event
+}
+
+// event is one line of JSON mode
+type event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // start, progress, done, failed, log, summary
+	Message string    `json:"message,omitempty"`
+	Value   int64     `json:"value,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Elapsed float64   `json:"elapsed,omitempty"` // seconds
+
+	Active int `json:"active,omitempty"`
+	Done   int `json:"done,omitempty"`
+	Failed int `json:"failed,omitempty"`
+
+	value string // formatted value with units of tracker
+}
+
+func newWriter(mode Mode) *writer {
+	style := progress.StyleDefault
+
+	return &writer{
+		mode:       mode,
+		out:        output,
+		style:      &style,
+		mu:         &sync.Mutex{},
+		trackers:   make([]*trackerState, 0),
+		logs:       make([]string, 0),
+		lastReport: time.Now(),
+		rendering:  atomic.NewBool(false),
+		stop:       make(chan struct{}),
+		stopOnce:   &sync.Once{},
+	}
+}
+
+func (w *writer) AppendTracker(tracker *progress.Tracker) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.units == nil {
+		units := tracker.Units
+		w.units = &units
+	}
+	w.trackers = append(w.trackers, &trackerState{tracker: tracker})
+}
+
+func (w *writer) AppendTrackers(trackers []*progress.Tracker) {
+	for _, t := range trackers {
+		w.AppendTracker(t)
+	}
+}
+
+func (w *writer) IsRenderInProgress() bool { return w.rendering.Load() }
+
+func (w *writer) Length() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.trackers) + w.done + w.failed
+}
+
+func (w *writer) LengthActive() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	active := 0
+	for _, s := range w.trackers {
+		if !s.tracker.IsDone() {
+			active++
+		}
+	}
+	return active
+}
+
+func (w *writer) LengthDone() int { return w.Length() - w.LengthActive() }
+
+func (w *writer) LengthInQueue() int { return 0 }
+
+func (w *writer) Log(msg string, a ...interface{}) {
+	if len(a) > 0 {
+		msg = fmt.Sprintf(msg, a...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.logs = append(w.logs, msg)
+}
+
+func (w *writer) SetPinnedMessages(messages ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pinned = messages
+}
+
+func (w *writer) SetOutputWriter(output io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.out = output
+}
+
+func (w *writer) Style() *progress.Style { return w.style }
+
+func (w *writer) SetStyle(style progress.Style) { w.style = &style }
+
+// below options only make sense for terminal renderer
+
+func (w *writer) SetAutoStop(bool)                     {}
+func (w *writer) SetMessageWidth(int)                  {}
+func (w *writer) SetNumTrackersExpected(int)           {}
+func (w *writer) SetSortBy(progress.SortBy)            {}
+func (w *writer) SetTrackerLength(int)                 {}
+func (w *writer) SetTrackerPosition(progress.Position) {}
+func (w *writer) SetUpdateFrequency(time.Duration)     {}
+func (w *writer) ShowETA(bool)                         {}
+func (w *writer) ShowOverallTracker(bool)              {}
+func (w *writer) ShowPercentage(bool)                  {}
+func (w *writer) ShowTime(bool)                        {}
+func (w *writer) ShowTracker(bool)                     {}
+func (w *writer) ShowValue(bool)                       {}
+
+func (w *writer) Render() {
+	if !w.rendering.CompareAndSwap(false, true) {
+		return
+	}
+	defer w.rendering.Store(false)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.poll(true)
+			return
+		case <-ticker.C:
+			w.poll(false)
+		}
+	}
+}
+
+func (w *writer) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *writer) poll(final bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, msg := range w.logs {
+		w.emit(event{Type: "log", Message: msg})
+	}
+	w.logs = w.logs[:0]
+
+	// summary mode only prints logs and summaries, without lines of each tracker
+	perTracker := w.mode != ModeSummary
+
+	active := w.trackers[:0]
+	for _, s := range w.trackers {
+		if s.started.IsZero() {
+			s.started = time.Now()
+			if perTracker {
+				w.emit(w.trackerEvent("start", s))
+			}
+		}
+
+		if !s.tracker.IsDone() {
+			active = append(active, s)
+			continue
+		}
+
+		typ := "done"
+		if s.tracker.IsErrored() {
+			typ = "failed"
+			w.failed++
+		} else {
+			w.done++
+		}
+		w.doneValue += s.tracker.Value()
+		w.doneTotal += s.tracker.Total
+		if perTracker {
+			w.emit(w.trackerEvent(typ, s))
+		}
+	}
+	// drop references of finished trackers, so long-running watch or daemon won't grow
+	clear(w.trackers[len(active):])
+	w.trackers = active
+
+	if final || time.Since(w.lastReport) >= reportInterval {
+		w.report(final)
+		w.lastReport = time.Now()
+	}
+}
+
+// report emits progress of active trackers in JSON mode, and summary in other modes
+func (w *writer) report(final bool) {
+	if w.mode == ModeJson {
+		for _, s := range w.trackers {
+			if s.tracker.Value() == s.reported {
+				continue
+			}
+			s.reported = s.tracker.Value()
+			w.emit(w.trackerEvent("progress", s))
+		}
+	}
+
+	// plain mode only prints the final summary
+	if w.mode == ModePlain && !final {
+		return
+	}
+
+	e := event{
+		Type:   "summary",
+		Active: len(w.trackers),
+		Done:   w.done,
+		Failed: w.failed,
+		Value:  w.doneValue,
+		Total:  w.doneTotal,
+	}
+	for _, s := range w.trackers {
+		e.Value += s.tracker.Value()
+		e.Total += s.tracker.Total
+	}
+	if e.Active+e.Done+e.Failed == 0 {
+		return
+	}
+
+	if w.mode != ModeJson {
+		e.Message = fmt.Sprintf("%s/%s", w.sprint(e.Value), w.sprint(e.Total))
+		if !final {
+			speed := float64(e.Value-w.lastValue) / time.Since(w.lastReport).Seconds()
+			e.Message += fmt.Sprintf(", %s/s", w.sprint(int64(speed)))
+		}
+		if len(w.pinned) > 0 {
+			e.Message += ", " + strings.Join(w.pinned, " ")
+		}
+	}
+	w.lastValue = e.Value
+
+	w.emit(e)
+}
+
+func (w *writer) trackerEvent(typ string, s *trackerState) event {
+	e := event{
+		Type:    typ,
+		Message: s.tracker.Message,
+		Value:   s.tracker.Value(),
+		Total:   s.tracker.Total,
+		value:   s.tracker.Units.Sprint(s.tracker.Value()),
+	}
+	if typ != "start" {
+		e.Elapsed = time.Since(s.started).Seconds()
+	}
+
+	return e
+}
+
+// sprint formats value with units of the first tracker, because all trackers share the same units
+func (w *writer) sprint(v int64) string {
+	if w.units == nil {
+		return fmt.Sprint(v)
+	}
+	return w.units.Sprint(v)
+}
+
+func (w *writer) emit(e event) {
+	e.Time = time.Now()
+	e.Message = text.StripEscape(e.Message)
+
+	if w.mode == ModeJson {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		_, _ = fmt.Fprintln(w.out, string(b))
+		return
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(e.Time.Format(time.DateTime))
+	b.WriteString(" [")
+	b.WriteString(e.Type)
+	b.WriteString("] ")
+
+	switch e.Type {
+	case "log":
+		b.WriteString(e.Message)
+	case "summary":
+		fmt.Fprintf(b, "active: %d, done: %d, failed: %d, %s", e.Active, e.Done, e.Failed, e.Message)
+	case "start":
+		b.WriteString(e.Message)
+	default:
+		fmt.Fprintf(b, "%s (%s in %s)", e.Message, e.value, time.Duration(e.Elapsed*float64(time.Second)).Round(time.Millisecond))
+	}
+
+	_, _ = fmt.Fprintln(w.out, b.String())
+}
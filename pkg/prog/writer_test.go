@@ -0,0 +1,144 @@
+package prog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/utils"
+)
+
+// render runs f while rendering, then stops the writer and waits for the final output
+func render(t *testing.T, w *writer, f func()) {
+	done := make(chan struct{})
+	go func() {
+		w.Render()
+		close(done)
+	}()
+	require.Eventually(t, w.IsRenderInProgress, time.Second, time.Millisecond)
+
+	f()
+	w.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("render is not stopped")
+	}
+}
+
+func TestWriterPlain(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newWriter(ModePlain)
+	w.SetOutputWriter(buf)
+
+	render(t, w, func() {
+		ok := AppendTracker(w, utils.Byte.FormatBinaryBytes, "a.jpg", 2048)
+		ok.SetValue(2048)
+
+		failed := AppendTracker(w, utils.Byte.FormatBinaryBytes, "b.jpg", 2048)
+		failed.MarkAsErrored()
+
+		AppendTracker(w, utils.Byte.FormatBinaryBytes, "c.jpg", 2048)
+		w.Log("\x1b[31mred\x1b[0m log")
+
+		assert.Equal(t, 3, w.Length())
+		assert.Equal(t, 1, w.LengthActive())
+	})
+
+	out := buf.String()
+	assert.Contains(t, out, "[log] red log\n")
+	assert.Contains(t, out, "[start] a.jpg\n")
+	assert.Contains(t, out, "[done] a.jpg (2.00 KB in ")
+	assert.Contains(t, out, "[failed] b.jpg (0 B in ")
+	assert.NotContains(t, out, "[done] c.jpg")
+	assert.Contains(t, out, "[summary] active: 1, done: 1, failed: 1, 2.00 KB/")
+}
+
+func TestWriterSummary(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newWriter(ModeSummary)
+	w.SetOutputWriter(buf)
+
+	ok := AppendTracker(w, utils.Byte.FormatBinaryBytes, "a.jpg", 2048)
+	ok.SetValue(2048)
+	AppendTracker(w, utils.Byte.FormatBinaryBytes, "b.jpg", 2048).MarkAsErrored()
+	AppendTracker(w, utils.Byte.FormatBinaryBytes, "c.jpg", 2048)
+	w.Log("log")
+
+	// summary is printed every report interval
+	for i := 0; i < 2; i++ {
+		w.lastReport = time.Now().Add(-reportInterval)
+		w.poll(false)
+	}
+	w.poll(false) // not yet
+	w.poll(true)
+
+	out := buf.String()
+	assert.Contains(t, out, "[log] log\n")
+	assert.NotContains(t, out, "[start]")
+	assert.NotContains(t, out, "[done]")
+	assert.NotContains(t, out, "[failed]")
+	assert.Equal(t, 3, strings.Count(out, "[summary] active: 1, done: 1, failed: 1, 2.00 KB/"))
+}
+
+func TestWriterRemoveFinished(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newWriter(ModePlain)
+	w.SetOutputWriter(buf)
+
+	for i := 0; i < 100; i++ {
+		tracker := AppendTracker(w, utils.Byte.FormatBinaryBytes, "a.jpg", 1024)
+		tracker.SetValue(1024)
+	}
+	AppendTracker(w, utils.Byte.FormatBinaryBytes, "b.jpg", 1024)
+
+	w.poll(true)
+
+	assert.Len(t, w.trackers, 1)
+	assert.Equal(t, 101, w.Length())
+	assert.Equal(t, 1, w.LengthActive())
+	assert.Equal(t, 100, w.LengthDone())
+	assert.Contains(t, buf.String(), "[summary] active: 1, done: 100, failed: 0, 100.00 KB/101.00 KB")
+}
+
+func TestWriterJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newWriter(ModeJson)
+	w.SetOutputWriter(buf)
+
+	render(t, w, func() {
+		tracker := AppendTracker(w, progress.FormatNumber, "chat", 0)
+		tracker.Increment(10)
+	})
+
+	events := make([]event, 0)
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		e := event{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, "start,progress,summary", strings.Join(types, ","))
+	assert.Equal(t, int64(10), events[1].Value)
+	assert.Equal(t, 1, events[2].Active)
+}
+
+func TestResolveMode(t *testing.T) {
+	// stdout is not a terminal in tests
+	assert.Equal(t, ModePlain, resolveMode(ModeAuto))
+	assert.Equal(t, ModeSummary, resolveMode(ModeSummary))
+	assert.Equal(t, ModeTty, resolveMode(ModeTty))
+}
@@ -0,0 +1,235 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go.uber.org/multierr"
+)
+
+type Status string
+
+const (
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+	StatusSkipped  Status = "skipped"
+)
+
+type Peer struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Entry is the result of one element
+type Entry struct {
+	From     *Peer     `json:"from,omitempty"`
+	Message  int       `json:"message,omitempty"`
+	File     string    `json:"file,omitempty"` // local source file
+	To       *Peer     `json:"to,omitempty"`
+	Path     string    `json:"path,omitempty"` // local destination file
+	Bytes    int64     `json:"bytes"`          // transferred bytes
+	Status   Status    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Reason   string    `json:"reason,omitempty"` // why the element is skipped
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration int64     `json:"duration_ms"`
+}
+
+// Report is the whole result of one run, only used by JSON format
+type Report struct {
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Failed   int       `json:"failed"`
+	Canceled int       `json:"canceled"`
+	Skipped  int       `json:"skipped"`
+	Entries  []*Entry  `json:"entries"`
+}
+
+// Reporter collects results of elements from progress callbacks.
+//
+// If the path ends with '.jsonl', every entry is written as one line once it's done,
+// otherwise the whole report is written as JSON when closing.
+// All methods of nil Reporter are no-op, so callers don't need to check if report is enabled.
+type Reporter struct {
+	mu      *sync.Mutex
+	path    string
+	stream  *json.Encoder // not nil in JSONL format
+	file    *os.File
+	report  *Report
+	started map[any]*Entry
+	err     error // first error of streaming
+}
+
+// New creates a reporter for command. Nil reporter is returned if path is empty.
+func New(command, path string) (*Reporter, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create report dir")
+	}
+
+	r := &Reporter{
+		mu:   &sync.Mutex{},
+		path: path,
+		report: &Report{
+			Command: command,
+			Started: time.Now(),
+			Entries: make([]*Entry, 0),
+		},
+		started: make(map[any]*Entry),
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "create report")
+		}
+		r.file, r.stream = f, json.NewEncoder(f)
+	}
+
+	return r, nil
+}
+
+// Start records the start of element identified by key
+func (r *Reporter) Start(key any, e Entry) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Started = time.Now()
+	r.started[key] = &e
+}
+
+// Done records the result of element identified by key. update can be used to fill fields known after transfer.
+func (r *Reporter) Done(key any, err error, update func(e *Entry)) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.started[key]
+	if !ok {
+		return
+	}
+	delete(r.started, key)
+
+	if update != nil {
+		update(e)
+	}
+
+	e.Finished = time.Now()
+	e.Duration = e.Finished.Sub(e.Started).Milliseconds()
+	e.Status = status(err)
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	r.add(e)
+}
+
+// Skip records the element which is skipped before transfer, like filtered or duplicate ones
+func (r *Reporter) Skip(e Entry, reason string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Started = time.Now()
+	e.Finished = e.Started
+	e.Status = StatusSkipped
+	e.Reason = reason
+
+	r.add(&e)
+}
+
+func (r *Reporter) add(e *Entry) {
+	r.report.Total++
+	switch e.Status {
+	case StatusDone:
+		r.report.Done++
+	case StatusFailed:
+		r.report.Failed++
+	case StatusCanceled:
+		r.report.Canceled++
+	case StatusSkipped:
+		r.report.Skipped++
+	}
+
+	if r.stream != nil {
+		// don't interrupt the transfer, the error will be returned when closing
+		if err := r.stream.Encode(e); err != nil && r.err == nil {
+			r.err = errors.Wrap(err, "write report")
+		}
+		return
+	}
+	r.report.Entries = append(r.report.Entries, e)
+}
+
+// Close writes the report. Elements which are not done are reported as canceled.
+func (r *Reporter) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	for key := range r.pending() {
+		r.Done(key, context.Canceled, nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stream != nil {
+		return multierr.Append(r.err, r.file.Close())
+	}
+
+	r.report.Finished = time.Now()
+
+	b, err := json.MarshalIndent(r.report, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal report")
+	}
+
+	return os.WriteFile(r.path, b, 0o644)
+}
+
+func (r *Reporter) pending() map[any]struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make(map[any]struct{}, len(r.started))
+	for key := range r.started {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func status(err error) Status {
+	switch {
+	case err == nil:
+		return StatusDone
+	case errors.Is(err, context.Canceled):
+		return StatusCanceled
+	default:
+		return StatusFailed
+	}
+}
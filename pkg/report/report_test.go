@@ -0,0 +1,103 @@
+package report
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporterNil(t *testing.T) {
+	r, err := New("dl", "")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	// all methods are no-op
+	r.Start(1, Entry{})
+	r.Done(1, nil, nil)
+	r.Skip(Entry{}, "filtered")
+	assert.NoError(t, r.Close())
+}
+
+func TestReporterJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	r, err := New("dl", path)
+	require.NoError(t, err)
+
+	r.Start(1, Entry{From: &Peer{ID: 1, Name: "a"}, Message: 10})
+	r.Start(2, Entry{From: &Peer{ID: 1, Name: "a"}, Message: 11})
+	r.Start(3, Entry{From: &Peer{ID: 1, Name: "a"}, Message: 12})
+
+	r.Done(1, nil, func(e *Entry) {
+		e.Path = "downloads/a.jpg"
+		e.Bytes = 1024
+	})
+	r.Done(2, errors.New("boom"), nil)
+	// unknown key is ignored
+	r.Done(4, nil, nil)
+	r.Skip(Entry{From: &Peer{ID: 1, Name: "a"}, Message: 13, Path: "downloads/b.jpg"}, "duplicate")
+	require.NoError(t, r.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	report := Report{}
+	require.NoError(t, json.Unmarshal(b, &report))
+
+	assert.Equal(t, "dl", report.Command)
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, 1, report.Done)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Canceled)
+	assert.Equal(t, 1, report.Skipped)
+	require.Len(t, report.Entries, 4)
+
+	assert.Equal(t, StatusDone, report.Entries[0].Status)
+	assert.Equal(t, "downloads/a.jpg", report.Entries[0].Path)
+	assert.Equal(t, int64(1024), report.Entries[0].Bytes)
+	assert.Equal(t, StatusFailed, report.Entries[1].Status)
+	assert.Equal(t, "boom", report.Entries[1].Error)
+	assert.Equal(t, StatusSkipped, report.Entries[2].Status)
+	assert.Equal(t, "duplicate", report.Entries[2].Reason)
+	assert.Equal(t, 13, report.Entries[2].Message)
+	assert.Equal(t, StatusCanceled, report.Entries[3].Status)
+	assert.Equal(t, 12, report.Entries[3].Message)
+}
+
+func TestReporterJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.jsonl")
+
+	r, err := New("up", path)
+	require.NoError(t, err)
+
+	r.Start("a", Entry{File: "a.jpg", To: &Peer{ID: 2, Name: "b"}})
+	r.Start("b", Entry{File: "b.jpg", To: &Peer{ID: 2, Name: "b"}})
+	r.Done("a", nil, nil)
+	r.Done("b", errors.Wrap(context.Canceled, "upload"), nil)
+	require.NoError(t, r.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := Entry{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, entries, 2)
+	assert.Equal(t, "a.jpg", entries[0].File)
+	assert.Equal(t, StatusDone, entries[0].Status)
+	assert.Equal(t, StatusCanceled, entries[1].Status)
+}
@@ -0,0 +1,244 @@
+package tmessage
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/core/util/tutil"
+)
+
+// ChatOptions narrows messages of the chat. Zero values mean unlimited.
+type ChatOptions struct {
+	Chat  string
+	Topic int    // topic id or message id of replies
+	Range [2]int // message id range, both inclusive
+	Since time.Time
+	Until time.Time
+}
+
+// FromChat collects ids of messages from history or replies of the chat, without exported files.
+// Downloads should iterate ChatStream directly instead, which doesn't hold the whole chat in memory.
+func FromChat(ctx context.Context, pool dcpool.Pool, kvd storage.Storage, opts ChatOptions, onlyMedia bool) ParseSource {
+	return func() ([]*Dialog, error) {
+		if opts.Chat == "" {
+			return []*Dialog{}, nil
+		}
+
+		manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
+
+		stream, err := NewChatStream(ctx, pool.Default(ctx), manager, opts, onlyMedia)
+		if err != nil {
+			return nil, err
+		}
+
+		d := &Dialog{
+			Peer:     stream.Peer().InputPeer(),
+			Messages: make([]int, 0),
+		}
+		for stream.Next(ctx) {
+			d.Messages = append(d.Messages, stream.Value().ID)
+		}
+		if err = stream.Err(); err != nil {
+			return nil, err
+		}
+
+		logctx.From(ctx).Debug("Collect chat",
+			zap.Int64("id", stream.Peer().ID()),
+			zap.Int("topic", opts.Topic),
+			zap.Int("num", len(d.Messages)))
+		return []*Dialog{d}, nil
+	}
+}
+
+// ChatStream iterates messages of the chat lazily, from the newest one in range of options
+type ChatStream struct {
+	peer      peers.Peer
+	iter      *messages.Iterator
+	opts      ChatOptions
+	onlyMedia bool
+
+	cur  *tg.Message
+	done bool
+	err  error
+}
+
+// NewChatStream resolves the chat, and iterates its history or replies of the topic
+func NewChatStream(ctx context.Context, api *tg.Client, manager *peers.Manager, opts ChatOptions, onlyMedia bool) (*ChatStream, error) {
+	peer, err := tutil.GetInputPeer(ctx, manager, opts.Chat)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve chat %q", opts.Chat)
+	}
+
+	var q messages.Query = query.NewQuery(api).Messages().GetHistory(peer.InputPeer())
+	if opts.Topic != 0 {
+		q = query.NewQuery(api).Messages().GetReplies(peer.InputPeer()).MsgID(opts.Topic)
+
+		// replies of channel post are in the discussion group
+		if ch, ok := peer.(peers.Channel); ok && ch.IsBroadcast() {
+			if peer, err = linkedChat(ctx, manager, ch); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// iterate from the newest one in range
+	iter := messages.NewIterator(q, 100)
+	switch {
+	case opts.Range[1] > 0:
+		iter = iter.OffsetID(opts.Range[1] + 1)
+	case !opts.Until.IsZero():
+		iter = iter.OffsetDate(int(opts.Until.Unix()) + 1)
+	}
+
+	return &ChatStream{
+		peer:      peer,
+		iter:      iter,
+		opts:      opts,
+		onlyMedia: onlyMedia,
+	}, nil
+}
+
+// Peer returns the peer which messages are from, it's the discussion group for replies of channel post
+func (s *ChatStream) Peer() peers.Peer {
+	return s.peer
+}
+
+// Next fetches the next matching message. Messages are requested in batches only when they are consumed.
+func (s *ChatStream) Next(ctx context.Context) bool {
+	if s.done {
+		return false
+	}
+
+	for s.iter.Next(ctx) {
+		m, ok := s.iter.Value().Msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+
+		// the following messages are older ones
+		if m.ID < s.opts.Range[0] || (!s.opts.Since.IsZero() && int64(m.Date) < s.opts.Since.Unix()) {
+			break
+		}
+		if (s.opts.Range[1] > 0 && m.ID > s.opts.Range[1]) || (!s.opts.Until.IsZero() && int64(m.Date) > s.opts.Until.Unix()) {
+			continue
+		}
+
+		if _, ok = tmedia.GetMedia(m); s.onlyMedia && !ok {
+			continue
+		}
+
+		s.cur = m
+		return true
+	}
+
+	s.done = true
+	if err := s.iter.Err(); err != nil {
+		s.err = errors.Wrap(err, "iterate messages")
+	}
+	return false
+}
+
+func (s *ChatStream) Value() *tg.Message {
+	return s.cur
+}
+
+func (s *ChatStream) Err() error {
+	return s.err
+}
+
+func linkedChat(ctx context.Context, manager *peers.Manager, ch peers.Channel) (peers.Peer, error) {
+	raw, err := ch.FullRaw(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get channel full")
+	}
+
+	linked, ok := raw.GetLinkedChatID()
+	if !ok {
+		return nil, errors.New("no linked group")
+	}
+
+	return manager.ResolveChannelID(ctx, linked)
+}
+
+// ParseRange parses message id range like '100-5000'. Either side can be omitted, like '100-' and '-5000'.
+func ParseRange(s string) ([2]int, error) {
+	r := [2]int{0, 0}
+	if s == "" {
+		return r, nil
+	}
+
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return r, errors.Errorf("invalid range %q, format: FROM-TO", s)
+	}
+
+	for i, v := range []string{from, to} {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return r, errors.Errorf("invalid message id %q of range %q", v, s)
+		}
+		r[i] = id
+	}
+
+	if r[1] > 0 && r[0] > r[1] {
+		return r, errors.Errorf("invalid range %q, start is greater than end", s)
+	}
+
+	return r, nil
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	time.DateTime,
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// ParseDate parses date in local time zone. Formats: unix timestamp, RFC3339, '2006-01-02 15:04:05', '2006-01-02 15:04' and '2006-01-02'.
+func ParseDate(s string) (time.Time, error) {
+	return parseDate(s, false)
+}
+
+// ParseUntil is like ParseDate, but date without time means the end of that day, so the whole day is included
+func ParseUntil(s string) (time.Time, error) {
+	return parseDate(s, true)
+}
+
+func parseDate(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil && ts >= 0 && ts <= math.MaxInt32 {
+		return time.Unix(ts, 0), nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if endOfDay && layout == time.DateOnly {
+				return t.AddDate(0, 0, 1).Add(-time.Second), nil
+			}
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid date %q, format: timestamp, RFC3339, 'YYYY-MM-DD[ HH:MM[:SS]]'", s)
+}
@@ -0,0 +1,82 @@
+package tmessage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    [2]int
+		wantErr bool
+	}{
+		{input: "", want: [2]int{0, 0}},
+		{input: "100-5000", want: [2]int{100, 5000}},
+		{input: "100-", want: [2]int{100, 0}},
+		{input: "-5000", want: [2]int{0, 5000}},
+		{input: " 1 - 1 ", want: [2]int{1, 1}},
+		{input: "100", wantErr: true},
+		{input: "a-b", wantErr: true},
+		{input: "0-10", wantErr: true},
+		{input: "10-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRange(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: "", want: time.Time{}},
+		{input: "1700000000", want: time.Unix(1700000000, 0)},
+		{input: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)},
+		{input: "2024-01-02 15:04", want: time.Date(2024, 1, 2, 15, 4, 0, 0, time.Local)},
+		{input: "2024-01-02 15:04:05", want: time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)},
+		{input: "2024-01-02T15:04:05Z", want: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{input: "yesterday", wantErr: true},
+		{input: "2024/01/02", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDate(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "want %s, got %s", tt.want, got)
+		})
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	got, err := ParseUntil("2024-01-02")
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 1, 2, 23, 59, 59, 0, time.Local).Equal(got), got)
+
+	got, err = ParseUntil("2024-01-02 15:04")
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 1, 2, 15, 4, 0, 0, time.Local).Equal(got), got)
+
+	got, err = ParseUntil("")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+}
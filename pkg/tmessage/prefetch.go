@@ -0,0 +1,160 @@
+package tmessage
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+
+	"github.com/iyear/tdl/core/util/tutil"
+)
+
+// PrefetchAhead is the default number of batches fetched ahead of the consumer
+const PrefetchAhead = 2
+
+// Fetched is a prefetched message of dialogs. Message is nil if it may be deleted.
+type Fetched struct {
+	From    peers.Peer
+	Peer    tg.InputPeerClass
+	ID      int
+	Story   bool // message is wrapped from story, and ID is story id
+	Message *tg.Message
+}
+
+// PeerResolver resolves the peer of dialog
+type PeerResolver func(ctx context.Context, peer tg.InputPeerClass) (peers.Peer, error)
+
+// MessagesFetcher fetches messages of the peer by ids, missing ids are treated as deleted messages
+type MessagesFetcher func(ctx context.Context, peer tg.InputPeerClass, ids []int) (map[int]*tg.Message, error)
+
+type prefetchBatch struct {
+	messages []*Fetched
+	err      error
+}
+
+// Prefetcher fetches messages of dialogs in batches ahead of the consumer,
+// and returns them one by one in the order of dialogs.
+type Prefetcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	batches chan prefetchBatch
+	current []*Fetched
+	err     error
+}
+
+// NewPrefetcher starts fetching messages and then stories of dialogs in background.
+// Peer of each dialog is resolved only once, and at most ahead batches are buffered.
+func NewPrefetcher(ctx context.Context, dialogs []*Dialog, resolve PeerResolver, messages, stories MessagesFetcher, ahead int) *Prefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+
+	p := &Prefetcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		batches: make(chan prefetchBatch, ahead),
+	}
+	go p.run(dialogs, resolve, messages, stories)
+
+	return p
+}
+
+func (p *Prefetcher) run(dialogs []*Dialog, resolve PeerResolver, messages, stories MessagesFetcher) {
+	defer close(p.batches)
+
+	for _, d := range dialogs {
+		if d.Len() == 0 {
+			continue
+		}
+
+		from, err := resolve(p.ctx, d.Peer)
+		if err != nil {
+			p.send(prefetchBatch{err: errors.Wrap(err, "resolve from input peer")})
+			return
+		}
+
+		if !p.fetch(from, d.Peer, d.Messages, messages, false) ||
+			!p.fetch(from, d.Peer, d.Stories, stories, true) {
+			return
+		}
+	}
+}
+
+// fetch sends messages of ids in batches, and returns false if it's canceled or failed
+func (p *Prefetcher) fetch(from peers.Peer, peer tg.InputPeerClass, ids []int, fetch MessagesFetcher, story bool) bool {
+	for len(ids) > 0 {
+		n := min(len(ids), tutil.MaxMessagesPerRequest)
+
+		msgs, err := fetch(p.ctx, peer, ids[:n])
+		if err != nil {
+			p.send(prefetchBatch{err: errors.Wrap(err, "fetch messages")})
+			return false
+		}
+
+		batch := make([]*Fetched, 0, n)
+		for _, id := range ids[:n] {
+			batch = append(batch, &Fetched{
+				From:    from,
+				Peer:    peer,
+				ID:      id,
+				Story:   story,
+				Message: msgs[id], // nil if deleted
+			})
+		}
+		if !p.send(prefetchBatch{messages: batch}) {
+			return false
+		}
+
+		ids = ids[n:]
+	}
+
+	return true
+}
+
+func (p *Prefetcher) send(b prefetchBatch) bool {
+	select {
+	case p.batches <- b:
+		return b.err == nil
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// Next returns the next message. io.EOF is returned if all messages are consumed.
+func (p *Prefetcher) Next(ctx context.Context) (*Fetched, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	for len(p.current) == 0 {
+		select {
+		case b, ok := <-p.batches:
+			if !ok {
+				// channel is also closed when prefetcher is canceled
+				if p.err = p.ctx.Err(); p.err == nil {
+					p.err = io.EOF
+				}
+				return nil, p.err
+			}
+			if b.err != nil {
+				p.err = b.err
+				return nil, p.err
+			}
+			p.current = b.messages
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f := p.current[0]
+	p.current = p.current[1:]
+	return f, nil
+}
+
+// Close stops fetching in background. It's no-op for nil Prefetcher, so it can be called before fetching starts.
+func (p *Prefetcher) Close() {
+	if p == nil {
+		return
+	}
+	p.cancel()
+}
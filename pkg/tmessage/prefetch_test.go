@@ -0,0 +1,128 @@
+package tmessage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFetcher struct {
+	mu       sync.Mutex
+	resolved []int64
+	batches  [][]int
+	deleted  map[int]struct{}
+	err      error
+}
+
+func (f *fakeFetcher) resolve(_ context.Context, peer tg.InputPeerClass) (peers.Peer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.resolved = append(f.resolved, peer.(*tg.InputPeerChannel).ChannelID)
+	return nil, nil
+}
+
+func (f *fakeFetcher) fetch(_ context.Context, _ tg.InputPeerClass, ids []int) (map[int]*tg.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	f.batches = append(f.batches, append([]int(nil), ids...))
+	res := make(map[int]*tg.Message)
+	for _, id := range ids {
+		if _, ok := f.deleted[id]; ok {
+			continue
+		}
+		res[id] = &tg.Message{ID: id}
+	}
+	return res, nil
+}
+
+func sequence(from, to int) []int {
+	ids := make([]int, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		ids = append(ids, i)
+	}
+	return ids
+}
+
+func TestPrefetcher(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeFetcher{deleted: map[int]struct{}{5: {}}}
+	dialogs := []*Dialog{
+		{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: sequence(1, 250)},
+		{Peer: &tg.InputPeerChannel{ChannelID: 2}, Messages: nil},
+		{Peer: &tg.InputPeerChannel{ChannelID: 3}, Messages: []int{9, 3, 5}, Stories: []int{7}},
+		{Peer: &tg.InputPeerChannel{ChannelID: 4}, Stories: []int{1, 2}},
+	}
+
+	p := NewPrefetcher(ctx, dialogs, f.resolve, f.fetch, f.fetch, PrefetchAhead)
+	defer p.Close()
+
+	for _, d := range dialogs {
+		for idx, id := range append(append([]int{}, d.Messages...), d.Stories...) {
+			fetched, err := p.Next(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, d.Peer, fetched.Peer)
+			assert.Equal(t, id, fetched.ID)
+			assert.Equal(t, idx >= len(d.Messages), fetched.Story)
+
+			if id == 5 {
+				assert.Nil(t, fetched.Message)
+				continue
+			}
+			require.NotNil(t, fetched.Message)
+			assert.Equal(t, id, fetched.Message.ID)
+		}
+	}
+
+	_, err := p.Next(ctx)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, []int64{1, 3, 4}, f.resolved)
+	assert.Equal(t, [][]int{sequence(1, 100), sequence(101, 200), sequence(201, 250), {9, 3, 5}, {7}, {1, 2}}, f.batches)
+}
+
+func TestPrefetcherError(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeFetcher{err: errors.New("flood wait")}
+
+	p := NewPrefetcher(ctx, []*Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: []int{1}}}, f.resolve, f.fetch, f.fetch, PrefetchAhead)
+	defer p.Close()
+
+	_, err := p.Next(ctx)
+	assert.ErrorIs(t, err, f.err)
+
+	// error is sticky
+	_, err = p.Next(ctx)
+	assert.ErrorIs(t, err, f.err)
+}
+
+func TestPrefetcherClose(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeFetcher{}
+
+	p := NewPrefetcher(ctx, []*Dialog{{Peer: &tg.InputPeerChannel{ChannelID: 1}, Messages: sequence(1, 1000)}}, f.resolve, f.fetch, f.fetch, 1)
+	_, err := p.Next(ctx)
+	require.NoError(t, err)
+
+	p.Close()
+	for err == nil {
+		_, err = p.Next(ctx)
+	}
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// close before fetching starts
+	var nilPrefetcher *Prefetcher
+	nilPrefetcher.Close()
+}
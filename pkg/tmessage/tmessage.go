@@ -7,6 +7,7 @@ import (
 type Dialog struct {
 	Peer     tg.InputPeerClass
 	Messages []int
+	Stories  []int // story ids, only parsed from story links
 }
 
 type ParseSource func() ([]*Dialog, error)
@@ -14,3 +15,8 @@ type ParseSource func() ([]*Dialog, error)
 func Parse(src ParseSource) ([]*Dialog, error) {
 	return src()
 }
+
+// Len returns the number of messages and stories
+func (d *Dialog) Len() int {
+	return len(d.Messages) + len(d.Stories)
+}
@@ -19,22 +19,25 @@ func FromURL(ctx context.Context, pool dcpool.Pool, kvd storage.Storage, urls []
 		msgMap := make(map[int64]*Dialog)
 
 		for _, u := range urls {
-			ch, msgid, err := tutil.ParseMessageLink(ctx, manager, u)
+			link, err := tutil.ParseMessageLinks(ctx, manager, u)
 			if err != nil {
 				return nil, err
 			}
+			ch := link.Peer
 			logctx.From(ctx).Debug("Parse URL",
 				zap.String("url", u),
 				zap.Int64("peer_id", ch.ID()),
 				zap.String("peer_name", ch.VisibleName()),
-				zap.Int("msg", msgid))
+				zap.Ints("msgs", link.Messages),
+				zap.Ints("stories", link.Stories))
 
 			// init map value
 			if _, ok := msgMap[ch.ID()]; !ok {
 				msgMap[ch.ID()] = &Dialog{Peer: ch.InputPeer(), Messages: []int{}}
 			}
 
-			msgMap[ch.ID()].Messages = append(msgMap[ch.ID()].Messages, msgid)
+			msgMap[ch.ID()].Messages = append(msgMap[ch.ID()].Messages, link.Messages...)
+			msgMap[ch.ID()].Stories = append(msgMap[ch.ID()].Stories, link.Stories...)
 		}
 
 		// cap is at least len of map
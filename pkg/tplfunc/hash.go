@@ -0,0 +1,45 @@
+package tplfunc
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"text/template"
+)
+
+var Hash = []Func{HashString()}
+
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// HashString returns hex digest of the string. Algorithm is sha256 by default.
+func HashString() Func {
+	return func(funcMap template.FuncMap) {
+		funcMap["hash"] = func(s string, algo ...string) string {
+			name := "sha256"
+			switch len(algo) {
+			case 0:
+			case 1:
+				name = algo[0]
+			default:
+				panic("hash() requires at most 2 arguments")
+			}
+
+			f, ok := hashes[name]
+			if !ok {
+				panic("hash() unsupported algorithm: " + name)
+			}
+
+			h := f()
+			h.Write([]byte(s))
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+}
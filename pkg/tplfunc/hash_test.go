@@ -0,0 +1,51 @@
+package tplfunc
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name string
+		tpl  string
+		S    string
+		want string
+	}{
+		{name: "default", tpl: `{{ hash .S }}`, S: "tdl", want: "05ae803a6ea560edc9d33b33f731c2d4a0f1f86e11cb24baddfc8c6c4ac43391"},
+		{name: "md5", tpl: `{{ hash .S "md5" }}`, S: "", want: "d41d8cd98f00b204e9800998ecf8427e"},
+		{name: "sha1", tpl: `{{ hash .S "sha1" }}`, S: "", want: "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{name: "sha256", tpl: `{{ hash .S "sha256" }}`, S: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{name: "pipe", tpl: `{{ .S | hash | printf "%.8s" }}`, S: "", want: "e3b0c442"},
+	}
+
+	m := FuncMap(HashString())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Builder{}
+
+			err := template.Must(template.New("test").
+				Funcs(m).
+				Parse(tt.tpl)).
+				Execute(&got, tt)
+			if err != nil {
+				t.Errorf("hash() error = %v", err)
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("hash() got = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHashUnsupported(t *testing.T) {
+	err := template.Must(template.New("test").
+		Funcs(FuncMap(HashString())).
+		Parse(`{{ hash "tdl" "crc" }}`)).
+		Execute(&strings.Builder{}, nil)
+	if err == nil {
+		t.Errorf("hash() want error for unsupported algorithm")
+	}
+}
@@ -0,0 +1,70 @@
+package tplfunc
+
+import (
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+var Path = []Func{Sanitize()}
+
+// Sanitize replaces characters which are invalid in path component of the OS with '_'.
+// The OS is runtime.GOOS by default, and path separators are also replaced,
+// so the result can be used as a single directory or file name.
+func Sanitize() Func {
+	return func(funcMap template.FuncMap) {
+		funcMap["sanitize"] = func(s string, goos ...string) string {
+			switch len(goos) {
+			case 0:
+				return sanitize(s, runtime.GOOS)
+			case 1:
+				return sanitize(s, goos[0])
+			default:
+				panic("sanitize() requires at most 2 arguments")
+			}
+		}
+	}
+}
+
+// windowsReserved is the set of device names which can't be used as file name on windows, even with extension
+var windowsReserved = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+func sanitize(s, goos string) string {
+	invalid := "/\x00"
+	switch goos {
+	case "windows":
+		invalid = `<>:"/\|?*` + "\x00"
+	case "darwin":
+		invalid = "/:\x00"
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalid, r) || (goos == "windows" && r < 0x20) {
+			return '_'
+		}
+		return r
+	}, s)
+
+	if goos == "windows" {
+		// trailing dots and spaces are stripped by windows silently
+		if trimmed := strings.TrimRight(s, ". "); trimmed != s {
+			s = trimmed + strings.Repeat("_", len(s)-len(trimmed))
+		}
+
+		base, _, _ := strings.Cut(s, ".")
+		if _, ok := windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))]; ok {
+			s = "_" + s
+		}
+	}
+
+	switch s {
+	case "", ".", "..":
+		return strings.Repeat("_", max(len(s), 1))
+	}
+
+	return s
+}
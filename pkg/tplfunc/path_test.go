@@ -0,0 +1,48 @@
+package tplfunc
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		S    string
+		OS   string
+		want string
+	}{
+		{name: "empty", S: "", OS: "linux", want: "_"},
+		{name: "dot", S: "..", OS: "linux", want: "__"},
+		{name: "valid", S: "tdl chat 2024", OS: "windows", want: "tdl chat 2024"},
+		{name: "linux separator", S: "a/b\\c:d", OS: "linux", want: "a_b\\c:d"},
+		{name: "darwin colon", S: "a/b:c", OS: "darwin", want: "a_b_c"},
+		{name: "windows invalid", S: `a<b>c:d"e/f\g|h?i*j`, OS: "windows", want: "a_b_c_d_e_f_g_h_i_j"},
+		{name: "windows control", S: "a\tb\nc", OS: "windows", want: "a_b_c"},
+		{name: "windows trailing", S: "title. ", OS: "windows", want: "title__"},
+		{name: "windows reserved", S: "con", OS: "windows", want: "_con"},
+		{name: "windows reserved ext", S: "LPT1.txt", OS: "windows", want: "_LPT1.txt"},
+		{name: "windows not reserved", S: "CONSOLE", OS: "windows", want: "CONSOLE"},
+		{name: "unicode", S: "频道/话题", OS: "windows", want: "频道_话题"},
+	}
+
+	m := FuncMap(Sanitize())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Builder{}
+
+			err := template.Must(template.New("test").
+				Funcs(m).
+				Parse(`{{ sanitize .S .OS }}`)).
+				Execute(&got, tt)
+			if err != nil {
+				t.Errorf("sanitize() error = %v", err)
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("sanitize() got = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}